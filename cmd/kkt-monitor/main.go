@@ -6,9 +6,16 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/ai"
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/api"
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/collector"
 	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/config"
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/exporter"
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/history"
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/state"
 	"github.com/ranas-mukminov/kkt-54fz-monitoring/pkg/logger"
 )
 
@@ -45,26 +52,155 @@ func main() {
 		"commit", GitCommit,
 	)
 
+	if err := run(cfg, log); err != nil {
+		log.Error("KKT Monitor failed", "error", err)
+		os.Exit(1)
+	}
+}
+
+// run wires collectors, exporter and AI subsystem together and blocks until shutdown
+func run(cfg *config.Config, log *logger.Logger) error {
 	// Create context for graceful shutdown
-	_, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	// Setup signal handling
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigChan)
+
+	// Initialize collectors
+	collectors, err := buildCollectors(cfg.Collectors, log)
+	if err != nil {
+		return err
+	}
+	if len(collectors) == 0 {
+		log.Warn("No collectors enabled")
+	}
+
+	// Initialize exporter
+	exp := exporter.New(cfg.Exporter, log)
+	exp.Register(collector.SelfMetrics()...)
+	exp.SetInventory(cfg.Inventory.Devices)
+	exp.SetHealthSource(collectorHealth(collectors))
+	setStaleWindows(exp, collectors)
+
+	// Initialize AI subsystem
+	provider, err := ai.NewProvider(cfg.AI)
+	if err != nil {
+		return fmt.Errorf("failed to initialize AI provider: %w", err)
+	}
+	insights := ai.NewInsights()
+
+	// Initialize device state
+	store := state.New(cfg.State)
+	exp.Register(store.Collectors()...)
+	if cfg.State.SnapshotFile != "" {
+		restored, err := store.Load(cfg.State.SnapshotFile)
+		if err != nil {
+			return err
+		}
+		if restored {
+			log.Info("Device state restored", "devices", len(store.Devices()), "path", cfg.State.SnapshotFile)
+		}
+	}
+
+	// Initialize history
+	var hist *history.Store
+	if cfg.History.Dir != "" {
+		if hist, err = history.Open(cfg.History); err != nil {
+			return err
+		}
+	}
+
+	// Initialize API
+	apiServer := api.New(store, store.Errors, insights, log)
+	apiServer.SetLifecycle(store)
+	apiServer.SetToken(cfg.Server.APIToken)
+	if hist != nil {
+		apiServer.SetHistory(hist)
+	}
+	exp.Handle(cfg.Server.APIPath+"/", apiServer.Handler(cfg.Server.APIPath))
 
-	// TODO: Initialize collectors
-	// TODO: Initialize exporter
-	// TODO: Initialize AI subsystem
-	// TODO: Start HTTP server
+	if err := startCollectors(ctx, collectors); err != nil {
+		return err
+	}
 
-	log.Info("KKT Monitor started successfully", "port", cfg.Server.Port)
+	var pipeline sync.WaitGroup
+	runPipeline(ctx, &pipeline, collectors, exp, store, hist, log)
 
-	// Wait for shutdown signal
-	<-sigChan
-	log.Info("Shutdown signal received, stopping...")
+	// The last snapshot is saved once the pipeline has applied everything
+	// the collectors sent
+	stateCtx, stopState := context.WithCancel(context.Background())
+	defer stopState()
+
+	var wg sync.WaitGroup
+	if cfg.State.SnapshotFile != "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			store.Run(stateCtx, cfg.State.SnapshotFile, cfg.State.SnapshotInterval, log)
+		}()
+	}
+
+	if hist != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			hist.Run(ctx, log)
+		}()
+	}
+
+	if cfg.AI.ErrorClustering.Enabled {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runErrorClustering(ctx, cfg.AI.ErrorClustering, provider, store, insights, log)
+		}()
+	}
+
+	if cfg.AI.AlertAdvisor.Enabled {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runAlertAdvisor(ctx, cfg.AI.AlertAdvisor, provider, store, hist, insights, log)
+		}()
+	}
+
+	// Start HTTP server
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- exp.Start(ctx, cfg.Server)
+	}()
+
+	log.Info("KKT Monitor started successfully",
+		"port", cfg.Server.Port,
+		"api_path", cfg.Server.APIPath,
+		"collectors", len(collectors),
+		"ai_provider", provider.Name(),
+	)
+
+	// Wait for shutdown signal or server failure
+	var runErr error
+	select {
+	case sig := <-sigChan:
+		log.Info("Shutdown signal received, stopping...", "signal", sig.String())
+	case runErr = <-serverErr:
+		serverErr = nil
+	}
 
 	// Graceful shutdown
 	cancel()
+	stopCollectors(collectors, log)
+	pipeline.Wait()
+	stopState()
+	wg.Wait()
+	if serverErr != nil {
+		if err := <-serverErr; err != nil {
+			log.Error("Metrics server stopped with error", "error", err)
+		}
+	}
+
 	log.Info("KKT Monitor stopped")
+	return runErr
 }
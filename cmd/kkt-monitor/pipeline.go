@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/ai"
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/collector"
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/config"
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/domain"
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/exporter"
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/history"
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/state"
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/pkg/logger"
+)
+
+// buildCollectors creates all collectors enabled in configuration
+func buildCollectors(cfg config.CollectorsConfig, log *logger.Logger) ([]collector.Collector, error) {
+	var collectors []collector.Collector
+
+	for _, instance := range cfg {
+		if !instance.Enabled {
+			continue
+		}
+		c, err := collector.New(instance, log)
+		if err != nil {
+			return nil, err
+		}
+		collectors = append(collectors, c)
+	}
+
+	return collectors, nil
+}
+
+// startCollectors starts every collector, stopping the already started ones on failure
+func startCollectors(ctx context.Context, collectors []collector.Collector) error {
+	for i, c := range collectors {
+		if err := c.Start(ctx); err != nil {
+			stopCollectors(collectors[:i], nil)
+			return fmt.Errorf("failed to start collector %s: %w", c.Name(), err)
+		}
+	}
+	return nil
+}
+
+// stopCollectors stops every collector, logging failures
+func stopCollectors(collectors []collector.Collector, log *logger.Logger) {
+	for _, c := range collectors {
+		if err := c.Stop(); err != nil && log != nil {
+			log.Error("Failed to stop collector", "collector", c.Name(), "error", err)
+		}
+	}
+}
+
+// collectorHealth returns a health source over the collectors that report health
+func collectorHealth(collectors []collector.Collector) exporter.HealthSource {
+	return func() []collector.Health {
+		health := make([]collector.Health, 0, len(collectors))
+		for _, c := range collectors {
+			if reporter, ok := c.(collector.HealthReporter); ok {
+				health = append(health, reporter.Health())
+			}
+		}
+		return health
+	}
+}
+
+// setStaleWindows sets the stale windows of the collectors that have their
+// own in the exporter
+func setStaleWindows(exp *exporter.Exporter, collectors []collector.Collector) {
+	for _, c := range collectors {
+		if window, ok := c.(collector.StaleWindow); ok && window.StaleAfter() > 0 {
+			exp.SetStaleAfter(c.Name(), window.StaleAfter())
+		}
+	}
+}
+
+// runPipeline fans in metrics, errors and documents from all collectors
+// until ctx is canceled, then applies what the collectors already sent.
+// hist is nil when history is disabled.
+func runPipeline(ctx context.Context, wg *sync.WaitGroup, collectors []collector.Collector,
+	exp *exporter.Exporter, store *state.Store, hist *history.Store, log *logger.Logger) {
+	for _, c := range collectors {
+		wg.Add(2)
+
+		go func(c collector.Collector) {
+			defer wg.Done()
+			consume(ctx, c.Metrics(), func(m domain.Metrics) {
+				exp.UpdateMetrics(m)
+				store.ApplyMetrics(m)
+				if lister, ok := c.(collector.DeviceLister); ok {
+					if d, ok := lister.Device(m.KKTID); ok {
+						store.ApplyDevice(d)
+					}
+				}
+				if hist != nil {
+					if err := hist.AppendMetrics(m); err != nil {
+						log.Error("Failed to record metrics history", "kkt_id", m.KKTID, "error", err)
+					}
+				}
+			})
+		}(c)
+
+		go func(c collector.Collector) {
+			defer wg.Done()
+			consume(ctx, c.Errors(), func(kktErr domain.KKTError) {
+				log.Warn("KKT error reported",
+					"collector", c.Name(),
+					"kkt_id", kktErr.KKTID,
+					"error_code", kktErr.ErrorCode,
+					"message", kktErr.Message,
+				)
+				store.ApplyError(kktErr)
+				if hist != nil {
+					if err := hist.AppendError(kktErr); err != nil {
+						log.Error("Failed to record error history", "kkt_id", kktErr.KKTID, "error", err)
+					}
+				}
+			})
+		}(c)
+
+		if source, ok := c.(collector.DocumentSource); ok {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				consume(ctx, source.Documents(), store.ApplyDocument)
+			}()
+		}
+	}
+}
+
+// consume calls apply with every value received from ch until ctx is
+// canceled, and then with the values still buffered in ch
+func consume[T any](ctx context.Context, ch <-chan T, apply func(T)) {
+	for {
+		select {
+		case <-ctx.Done():
+			for {
+				select {
+				case v := <-ch:
+					apply(v)
+				default:
+					return
+				}
+			}
+		case v := <-ch:
+			apply(v)
+		}
+	}
+}
+
+// runErrorClustering periodically clusters recent errors with the AI provider
+// and keeps the clusters of at least MinClusterSize errors in insights
+func runErrorClustering(ctx context.Context, cfg config.ErrorClusteringConfig, provider ai.AIProvider,
+	store *state.Store, insights *ai.Insights, log *logger.Logger) {
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			recent := store.Errors()
+			if len(recent) == 0 {
+				continue
+			}
+
+			clusters, err := provider.ClusterErrors(ctx, recent)
+			if err != nil {
+				log.Error("Failed to cluster errors", "provider", provider.Name(), "error", err)
+				continue
+			}
+
+			detected := make([]ai.ErrorCluster, 0, len(clusters))
+			for _, cluster := range clusters {
+				if cluster.Count < cfg.MinClusterSize {
+					continue
+				}
+				detected = append(detected, cluster)
+				log.Info("Error cluster detected",
+					"cluster_id", cluster.ID,
+					"pattern", cluster.Pattern,
+					"count", cluster.Count,
+					"suggestion", cluster.Suggestion,
+				)
+			}
+			insights.SetClusters(detected, time.Now())
+		}
+	}
+}
+
+// maxAdvisorSamples caps the history samples given to the alert advisor
+const maxAdvisorSamples = 50000
+
+// runAlertAdvisor periodically asks the AI provider for alert
+// recommendations and keeps them in insights. The provider gets the
+// downsampled samples of the lookback period from hist, or the latest
+// metrics of every device when history is disabled.
+func runAlertAdvisor(ctx context.Context, cfg config.AlertAdvisorConfig, provider ai.AIProvider,
+	store *state.Store, hist *history.Store, insights *ai.Insights, log *logger.Logger) {
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			metrics := store.Metrics()
+			if hist != nil {
+				now := time.Now()
+				samples, err := hist.DownsampledMetrics(now.Add(-cfg.LookbackPeriod), now, maxAdvisorSamples)
+				if err != nil {
+					log.Error("Failed to read metrics history", "error", err)
+				} else {
+					metrics = samples
+				}
+			}
+			if len(metrics) == 0 {
+				continue
+			}
+
+			recommendations, err := provider.GenerateAlertRecommendations(ctx, metrics)
+			if err != nil {
+				log.Error("Failed to generate alert recommendations", "provider", provider.Name(), "error", err)
+				continue
+			}
+			insights.SetRecommendations(recommendations, time.Now())
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/collector"
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/config"
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/domain"
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/exporter"
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/state"
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/pkg/logger"
+)
+
+// fakeCollector is a collector of buffered metrics, errors and documents
+type fakeCollector struct {
+	metrics chan domain.Metrics
+	errors  chan domain.KKTError
+	docs    chan domain.FiscalDocument
+}
+
+func newFakeCollector() *fakeCollector {
+	return &fakeCollector{
+		metrics: make(chan domain.Metrics, 10),
+		errors:  make(chan domain.KKTError, 10),
+		docs:    make(chan domain.FiscalDocument, 10),
+	}
+}
+
+func (c *fakeCollector) Start(ctx context.Context) error         { return nil }
+func (c *fakeCollector) Stop() error                             { return nil }
+func (c *fakeCollector) Name() string                            { return "fake" }
+func (c *fakeCollector) Metrics() <-chan domain.Metrics          { return c.metrics }
+func (c *fakeCollector) Errors() <-chan domain.KKTError          { return c.errors }
+func (c *fakeCollector) Documents() <-chan domain.FiscalDocument { return c.docs }
+
+func TestRunPipeline(t *testing.T) {
+	log := logger.New("error", "text")
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	c := newFakeCollector()
+	exp := exporter.New(config.ExporterConfig{}, log)
+	store := state.New(config.StateConfig{ErrorsLimit: 10, AutoResolveCycles: 3})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	runPipeline(ctx, &wg, []collector.Collector{c}, exp, store, nil, log)
+
+	c.metrics <- domain.Metrics{KKTID: "kkt-001", Collector: "fake", Timestamp: now, Status: domain.KKTStatusRunning}
+	c.errors <- domain.KKTError{KKTID: "kkt-001", ErrorType: domain.ErrorTypeOFD, Timestamp: now}
+
+	// Values sent before the cancellation are applied after it
+	c.metrics <- domain.Metrics{KKTID: "kkt-002", Collector: "fake", Timestamp: now, Status: domain.KKTStatusRunning}
+	c.docs <- domain.FiscalDocument{KKTID: "kkt-002", Type: domain.DocumentTypeOpenShift, DateTime: now.Add(time.Minute)}
+	c.errors <- domain.KKTError{KKTID: "kkt-002", ErrorType: domain.ErrorTypeNetwork, Timestamp: now}
+	cancel()
+	wg.Wait()
+
+	if len(c.metrics) != 0 || len(c.errors) != 0 || len(c.docs) != 0 {
+		t.Fatalf("Expected all channels drained, got %d metrics, %d errors, %d documents",
+			len(c.metrics), len(c.errors), len(c.docs))
+	}
+	if devices := store.Devices(); len(devices) != 2 {
+		t.Errorf("Expected 2 devices, got %d", len(devices))
+	}
+	if errs := store.Errors(); len(errs) != 2 {
+		t.Errorf("Expected 2 errors, got %d", len(errs))
+	}
+	d, ok := store.Device("kkt-002")
+	if !ok || d.ShiftStatus != domain.ShiftStatusOpen || !d.LastSeen.Equal(now.Add(time.Minute)) {
+		t.Errorf("Expected the document applied to kkt-002, got %+v", d)
+	}
+
+	rec := httptest.NewRecorder()
+	exp.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	for _, id := range []string{"kkt-001", "kkt-002"} {
+		if !strings.Contains(rec.Body.String(), `kkt_id="`+id+`"`) {
+			t.Errorf("Expected exported metrics of %s", id)
+		}
+	}
+
+	// The state is saved when the snapshot loop is canceled
+	store.Run(ctx, path, time.Hour, log)
+	restored := state.New(config.StateConfig{ErrorsLimit: 10, AutoResolveCycles: 3})
+	loaded, err := restored.Load(path)
+	if err != nil || !loaded {
+		t.Fatalf("Expected a saved snapshot, got %v, %v", loaded, err)
+	}
+	if devices := restored.Devices(); len(devices) != 2 {
+		t.Errorf("Expected 2 restored devices, got %d", len(devices))
+	}
+	if errs := restored.Errors(); len(errs) != 2 {
+		t.Errorf("Expected 2 restored errors, got %d", len(errs))
+	}
+}
@@ -0,0 +1,56 @@
+package ai
+
+import (
+	"sync"
+	"time"
+)
+
+// Insights keeps the latest outputs of the AI provider
+type Insights struct {
+	mu                sync.RWMutex
+	clusters          []ErrorCluster
+	clustersAt        time.Time
+	recommendations   []AlertRecommendation
+	recommendationsAt time.Time
+}
+
+// NewInsights creates an empty insights store
+func NewInsights() *Insights {
+	return &Insights{}
+}
+
+// SetClusters replaces the error clusters with the result of a clustering run at t
+func (i *Insights) SetClusters(clusters []ErrorCluster, t time.Time) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.clusters = clusters
+	i.clustersAt = t
+}
+
+// Clusters returns the latest error clusters and the time they were computed
+func (i *Insights) Clusters() ([]ErrorCluster, time.Time) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	return i.clusters, i.clustersAt
+}
+
+// SetRecommendations replaces the alert recommendations with the result of
+// an advisor run at t
+func (i *Insights) SetRecommendations(recommendations []AlertRecommendation, t time.Time) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.recommendations = recommendations
+	i.recommendationsAt = t
+}
+
+// Recommendations returns the latest alert recommendations and the time
+// they were computed
+func (i *Insights) Recommendations() ([]AlertRecommendation, time.Time) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	return i.recommendations, i.recommendationsAt
+}
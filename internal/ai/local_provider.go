@@ -0,0 +1,255 @@
+package ai
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/config"
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/domain"
+)
+
+// LocalProvider clusters errors locally by the text similarity of their
+// messages and codes. Errors are compared as TF-IDF vectors of their words
+// with numbers and identifiers masked, so that "ФН 9960 переполнен" and
+// "ФН 9961 переполнен" are the same error. Alert recommendations are the
+// baseline ones with thresholds tuned to the metrics history.
+type LocalProvider struct {
+	minClusterSize      int
+	similarityThreshold float64
+}
+
+// NewLocalProvider creates a local AI provider with the clustering
+// thresholds of cfg
+func NewLocalProvider(cfg config.ErrorClusteringConfig) *LocalProvider {
+	return &LocalProvider{
+		minClusterSize:      cfg.MinClusterSize,
+		similarityThreshold: cfg.SimilarityThreshold,
+	}
+}
+
+// errorGroup is a group of errors with the same terms
+type errorGroup struct {
+	errors []domain.KKTError
+	terms  map[string]float64 // term frequencies, then the TF-IDF vector
+}
+
+// localCluster is a cluster being built
+type localCluster struct {
+	groups   []*errorGroup
+	centroid map[string]float64 // sum of the group vectors weighted by size
+	size     int
+}
+
+// ClusterErrors clusters errors whose TF-IDF cosine similarity to a cluster
+// reaches the similarity threshold. Clusters of fewer than MinClusterSize
+// errors are dropped; the largest clusters come first.
+func (p *LocalProvider) ClusterErrors(ctx context.Context, errors []domain.KKTError) ([]ErrorCluster, error) {
+	groups := groupErrors(errors)
+	weigh(groups)
+
+	// Large groups seed the clusters
+	sort.SliceStable(groups, func(i, j int) bool { return len(groups[i].errors) > len(groups[j].errors) })
+
+	var clusters []*localCluster
+	for _, g := range groups {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		var best *localCluster
+		bestSimilarity := p.similarityThreshold
+		for _, c := range clusters {
+			if s := c.similarity(g); s >= bestSimilarity {
+				best, bestSimilarity = c, s
+			}
+		}
+		if best == nil {
+			best = &localCluster{centroid: make(map[string]float64)}
+			clusters = append(clusters, best)
+		}
+		best.add(g)
+	}
+
+	sort.SliceStable(clusters, func(i, j int) bool { return clusters[i].size > clusters[j].size })
+	result := []ErrorCluster{}
+	for _, c := range clusters {
+		if c.size < p.minClusterSize {
+			continue
+		}
+		result = append(result, c.result(fmt.Sprintf("cluster-%d", len(result)+1)))
+	}
+	return result, nil
+}
+
+// GenerateAlertRecommendations returns the baseline alert recommendations
+// with thresholds tuned to the metrics samples
+func (p *LocalProvider) GenerateAlertRecommendations(ctx context.Context, metrics []domain.Metrics) ([]AlertRecommendation, error) {
+	return tuneRecommendations(baselineRecommendations(), metrics), nil
+}
+
+// Name returns the provider name
+func (p *LocalProvider) Name() string {
+	return "local"
+}
+
+// groupErrors groups errors by their terms: the words of the message, the
+// error code and the error type
+func groupErrors(errors []domain.KKTError) []*errorGroup {
+	index := make(map[string]*errorGroup)
+	var groups []*errorGroup
+	for _, e := range errors {
+		terms := tokenize(e.Message)
+		if e.ErrorCode != "" {
+			terms = append(terms, "code:"+normalize(e.ErrorCode))
+		}
+		terms = append(terms, "type:"+e.ErrorType.String())
+		sort.Strings(terms)
+
+		key := strings.Join(terms, " ")
+		g, ok := index[key]
+		if !ok {
+			g = &errorGroup{terms: make(map[string]float64)}
+			for _, t := range terms {
+				g.terms[t]++
+			}
+			index[key] = g
+			groups = append(groups, g)
+		}
+		g.errors = append(g.errors, e)
+	}
+	return groups
+}
+
+// weigh turns the term frequencies of the groups into unit TF-IDF vectors.
+// Document frequencies count errors, not groups.
+func weigh(groups []*errorGroup) {
+	total := 0
+	df := make(map[string]int)
+	for _, g := range groups {
+		total += len(g.errors)
+		for t := range g.terms {
+			df[t] += len(g.errors)
+		}
+	}
+
+	for _, g := range groups {
+		var norm float64
+		for t, tf := range g.terms {
+			w := tf * (math.Log(float64(1+total)/float64(1+df[t])) + 1)
+			g.terms[t] = w
+			norm += w * w
+		}
+		norm = math.Sqrt(norm)
+		for t := range g.terms {
+			g.terms[t] /= norm
+		}
+	}
+}
+
+// similarity returns the cosine similarity of a group to the centroid of
+// the cluster
+func (c *localCluster) similarity(g *errorGroup) float64 {
+	var dot, norm float64
+	for t, w := range g.terms {
+		dot += w * c.centroid[t]
+	}
+	for _, w := range c.centroid {
+		norm += w * w
+	}
+	if norm == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(norm)
+}
+
+// add adds a group to the cluster
+func (c *localCluster) add(g *errorGroup) {
+	c.groups = append(c.groups, g)
+	c.size += len(g.errors)
+	for t, w := range g.terms {
+		c.centroid[t] += w * float64(len(g.errors))
+	}
+}
+
+// result returns the cluster as an ErrorCluster
+func (c *localCluster) result(id string) ErrorCluster {
+	var errs []domain.KKTError
+	for _, g := range c.groups {
+		errs = append(errs, g.errors...)
+	}
+	sort.SliceStable(errs, func(i, j int) bool { return errs[i].Timestamp.Before(errs[j].Timestamp) })
+
+	severity := errs[0].Severity
+	types := make(map[domain.ErrorType]int)
+	for _, e := range errs {
+		severity = max(severity, e.Severity)
+		types[e.ErrorType]++
+	}
+	dominant := errs[0].ErrorType
+	for t, n := range types {
+		if n > types[dominant] || (n == types[dominant] && t < dominant) {
+			dominant = t
+		}
+	}
+
+	return ErrorCluster{
+		ID:         id,
+		Errors:     errs,
+		Pattern:    pattern(errs),
+		Severity:   severity,
+		Count:      len(errs),
+		FirstSeen:  errs[0].Timestamp.Format("2006-01-02 15:04:05"),
+		LastSeen:   errs[len(errs)-1].Timestamp.Format("2006-01-02 15:04:05"),
+		Suggestion: suggestionFor(dominant),
+	}
+}
+
+// pattern derives a readable template of the errors: the most frequent
+// message with numbers and identifiers masked, and words that differ
+// between messages of the same length replaced by <*>. The error code is
+// prepended when all errors share it.
+func pattern(errs []domain.KKTError) string {
+	counts := make(map[string]int)
+	code := errs[0].ErrorCode
+	for _, e := range errs {
+		counts[strings.Join(template(e.Message), " ")]++
+		if e.ErrorCode != code {
+			code = ""
+		}
+	}
+
+	var base string
+	for msg, n := range counts {
+		if n > counts[base] || (n == counts[base] && msg < base) {
+			base = msg
+		}
+	}
+
+	words := strings.Fields(base)
+	for msg := range counts {
+		other := strings.Fields(msg)
+		if len(other) != len(words) {
+			continue
+		}
+		for i := range words {
+			if other[i] != words[i] {
+				words[i] = maskVariable
+			}
+		}
+	}
+
+	text := strings.Join(words, " ")
+	switch {
+	case code != "" && text != "":
+		return code + ": " + text
+	case code != "":
+		return code
+	case text != "":
+		return text
+	default:
+		return fmt.Sprintf("Errors of type: %v", errs[0].ErrorType)
+	}
+}
@@ -0,0 +1,164 @@
+package ai
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/config"
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/domain"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		want    []string
+	}{
+		{
+			name:    "russian",
+			message: "Ошибка ФН: переполнение памяти ФН 9960440300123456",
+			want:    []string{"ошибк", "фн", "переполнен", "памят", "фн"},
+		},
+		{
+			name:    "inflected russian",
+			message: "Ошибки соединения с ОФД",
+			want:    []string{"ошибк", "соединен", "офд"},
+		},
+		{
+			name:    "english with identifiers",
+			message: "Connection to ofd.example.ru timed out after 30s (kkt-001)",
+			want:    []string{"connection", "ofd", "example", "ru", "timed", "out", "after"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tokenize(tt.message); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestLocalProvider_ClusterErrors(t *testing.T) {
+	provider := NewLocalProvider(config.ErrorClusteringConfig{MinClusterSize: 2, SimilarityThreshold: 0.5})
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	errors := []domain.KKTError{
+		{KKTID: "kkt-001", ErrorCode: "235", ErrorType: domain.ErrorTypeFiscalDrive, Severity: domain.ErrorSeverityError,
+			Message: "Ресурс ФН 9960440300123456 исчерпан", Timestamp: now},
+		{KKTID: "kkt-002", ErrorCode: "235", ErrorType: domain.ErrorTypeFiscalDrive, Severity: domain.ErrorSeverityCritical,
+			Message: "Ресурс ФН 9960440300654321 исчерпан", Timestamp: now.Add(time.Minute)},
+		{KKTID: "kkt-003", ErrorCode: "235", ErrorType: domain.ErrorTypeFiscalDrive, Severity: domain.ErrorSeverityError,
+			Message: "Ресурс ФН 9960440300000001 исчерпан", Timestamp: now.Add(2 * time.Minute)},
+		{KKTID: "kkt-001", ErrorType: domain.ErrorTypeOFD, Severity: domain.ErrorSeverityWarning,
+			Message: "Connection to OFD timed out after 30s", Timestamp: now.Add(3 * time.Minute)},
+		{KKTID: "kkt-004", ErrorType: domain.ErrorTypeOFD, Severity: domain.ErrorSeverityWarning,
+			Message: "Connection to OFD timed out after 60s", Timestamp: now.Add(4 * time.Minute)},
+		{KKTID: "kkt-005", ErrorType: domain.ErrorTypeOFD, Severity: domain.ErrorSeverityWarning,
+			Message: "Connection to OFD refused", Timestamp: now.Add(5 * time.Minute)},
+		// Below the minimum cluster size
+		{KKTID: "kkt-002", ErrorType: domain.ErrorTypePrinter, Severity: domain.ErrorSeverityWarning,
+			Message: "Нет бумаги", Timestamp: now},
+	}
+
+	clusters, err := provider.ClusterErrors(context.Background(), errors)
+	if err != nil {
+		t.Fatalf("ClusterErrors failed: %v", err)
+	}
+	if len(clusters) != 2 {
+		t.Fatalf("Expected 2 clusters, got %+v", clusters)
+	}
+
+	fn := clusters[0]
+	if fn.Count != 3 || fn.Pattern != "235: Ресурс ФН <num> исчерпан" {
+		t.Errorf("Expected 3 fiscal drive errors with a masked pattern, got %d %q", fn.Count, fn.Pattern)
+	}
+	if fn.Severity != domain.ErrorSeverityCritical {
+		t.Errorf("Expected the highest severity, got %v", fn.Severity)
+	}
+	if fn.FirstSeen != "2024-05-01 12:00:00" || fn.LastSeen != "2024-05-01 12:02:00" {
+		t.Errorf("Expected first and last seen of the cluster, got %s and %s", fn.FirstSeen, fn.LastSeen)
+	}
+
+	ofd := clusters[1]
+	if ofd.Count != 3 || ofd.Pattern != "Connection to OFD timed out after <num>" {
+		t.Errorf("Expected 3 OFD errors, got %d %q", ofd.Count, ofd.Pattern)
+	}
+
+	// A strict threshold separates the refused connection
+	strict := NewLocalProvider(config.ErrorClusteringConfig{MinClusterSize: 2, SimilarityThreshold: 0.95})
+	clusters, err = strict.ClusterErrors(context.Background(), errors)
+	if err != nil {
+		t.Fatalf("ClusterErrors failed: %v", err)
+	}
+	if len(clusters) != 2 || clusters[1].Count != 2 {
+		t.Errorf("Expected the OFD cluster to shrink to 2 errors, got %+v", clusters)
+	}
+}
+
+func TestLocalProvider_Pattern(t *testing.T) {
+	errs := []domain.KKTError{
+		{Message: "Document 12 not sent to OFD Taxcom"},
+		{Message: "Document 13 not sent to OFD Platforma"},
+		{Message: "Document 14 not sent to OFD Taxcom"},
+	}
+	if got := pattern(errs); got != "Document <num> not sent to OFD <*>" {
+		t.Errorf("Expected variable words masked, got %q", got)
+	}
+
+	errs = []domain.KKTError{{ErrorType: domain.ErrorTypeNetwork}, {ErrorType: domain.ErrorTypeNetwork}}
+	if got := pattern(errs); got != "Errors of type: network" {
+		t.Errorf("Expected pattern of the error type, got %q", got)
+	}
+}
+
+func TestLocalProvider_AlertRecommendations(t *testing.T) {
+	p := NewLocalProvider(config.ErrorClusteringConfig{})
+	threshold := func(recs []AlertRecommendation) float64 {
+		for _, rec := range recs {
+			if rec.Type == "low_document_rate" {
+				return rec.Threshold
+			}
+		}
+		t.Fatal("Expected a low_document_rate recommendation")
+		return 0
+	}
+
+	// Too few samples keep the baseline threshold
+	recs, err := p.GenerateAlertRecommendations(context.Background(), []domain.Metrics{{DocumentsPerHour: 200}})
+	if err != nil {
+		t.Fatalf("Failed to generate recommendations: %v", err)
+	}
+	if got := threshold(recs); got != 10 {
+		t.Errorf("Expected baseline threshold 10, got %v", got)
+	}
+
+	var metrics []domain.Metrics
+	for i := 0; i < minTuningSamples; i++ {
+		metrics = append(metrics, domain.Metrics{DocumentsPerHour: 200}, domain.Metrics{})
+	}
+	recs, err = p.GenerateAlertRecommendations(context.Background(), metrics)
+	if err != nil {
+		t.Fatalf("Failed to generate recommendations: %v", err)
+	}
+	if got := threshold(recs); got != 50 {
+		t.Errorf("Expected a quarter of the working rate, got %v", got)
+	}
+}
+
+func TestNewProvider(t *testing.T) {
+	provider, err := NewProvider(config.AIConfig{Provider: "local"})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	if provider.Name() != "local" {
+		t.Errorf("Expected provider local, got %s", provider.Name())
+	}
+
+	if _, err := NewProvider(config.AIConfig{Provider: "openai"}); err == nil {
+		t.Error("Expected error for an unknown provider")
+	}
+}
@@ -42,7 +42,7 @@ func (m *MockProvider) ClusterErrors(ctx context.Context, errors []domain.KKTErr
 			Count:      len(errs),
 			FirstSeen:  errs[0].Timestamp.Format("2006-01-02 15:04:05"),
 			LastSeen:   errs[len(errs)-1].Timestamp.Format("2006-01-02 15:04:05"),
-			Suggestion: generateMockSuggestion(errType),
+			Suggestion: suggestionFor(errType),
 		}
 		result = append(result, cluster)
 		clusterID++
@@ -53,7 +53,18 @@ func (m *MockProvider) ClusterErrors(ctx context.Context, errors []domain.KKTErr
 
 // GenerateAlertRecommendations generates alert recommendations (mock implementation)
 func (m *MockProvider) GenerateAlertRecommendations(ctx context.Context, metrics []domain.Metrics) ([]AlertRecommendation, error) {
-	recommendations := []AlertRecommendation{
+	return tuneRecommendations(baselineRecommendations(), metrics), nil
+}
+
+// Name returns the provider name
+func (m *MockProvider) Name() string {
+	return "mock"
+}
+
+// baselineRecommendations returns the alert recommendations that apply to
+// every fleet
+func baselineRecommendations() []AlertRecommendation {
+	return []AlertRecommendation{
 		{
 			ID:          "rec-1",
 			Type:        "kkt_unavailable",
@@ -91,17 +102,10 @@ func (m *MockProvider) GenerateAlertRecommendations(ctx context.Context, metrics
 			Rationale:   "Low document rate may indicate KKT malfunction or business operation issues.",
 		},
 	}
-
-	return recommendations, nil
-}
-
-// Name returns the provider name
-func (m *MockProvider) Name() string {
-	return "mock"
 }
 
-// generateMockSuggestion generates a mock suggestion based on error type
-func generateMockSuggestion(errType domain.ErrorType) string {
+// suggestionFor returns a remediation suggestion for an error type
+func suggestionFor(errType domain.ErrorType) string {
 	switch errType {
 	case domain.ErrorTypeNetwork:
 		return "Check network connectivity and firewall settings"
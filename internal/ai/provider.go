@@ -2,7 +2,9 @@ package ai
 
 import (
 	"context"
+	"fmt"
 
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/config"
 	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/domain"
 )
 
@@ -18,16 +20,28 @@ type AIProvider interface {
 	Name() string
 }
 
+// NewProvider creates the configured AI provider
+func NewProvider(cfg config.AIConfig) (AIProvider, error) {
+	switch cfg.Provider {
+	case "", "mock":
+		return NewMockProvider(), nil
+	case "local":
+		return NewLocalProvider(cfg.ErrorClustering), nil
+	default:
+		return nil, fmt.Errorf("unknown AI provider: %s", cfg.Provider)
+	}
+}
+
 // ErrorCluster represents a cluster of similar errors
 type ErrorCluster struct {
-	ID          string            `json:"id"`
-	Errors      []domain.KKTError `json:"errors"`
-	Pattern     string            `json:"pattern"`
-	Severity    domain.ErrorSeverity `json:"severity"`
-	Count       int               `json:"count"`
-	FirstSeen   string            `json:"first_seen"`
-	LastSeen    string            `json:"last_seen"`
-	Suggestion  string            `json:"suggestion"`
+	ID         string               `json:"id"`
+	Errors     []domain.KKTError    `json:"errors"`
+	Pattern    string               `json:"pattern"`
+	Severity   domain.ErrorSeverity `json:"severity"`
+	Count      int                  `json:"count"`
+	FirstSeen  string               `json:"first_seen"`
+	LastSeen   string               `json:"last_seen"`
+	Suggestion string               `json:"suggestion"`
 }
 
 // AlertRecommendation represents an alert recommendation
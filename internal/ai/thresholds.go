@@ -0,0 +1,55 @@
+package ai
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/domain"
+)
+
+// minTuningSamples is the number of samples below which a baseline
+// threshold is kept
+const minTuningSamples = 24
+
+// lowRateShare is the share of the usual document rate below which a
+// working register is suspicious
+const lowRateShare = 0.25
+
+// tuneRecommendations adjusts the thresholds of recommendations to the
+// metrics history of the fleet. Thresholds without enough history keep
+// their baseline values.
+func tuneRecommendations(recs []AlertRecommendation, metrics []domain.Metrics) []AlertRecommendation {
+	var rates []float64
+	for _, m := range metrics {
+		// Closed registers do not tell the usual rate
+		if m.DocumentsPerHour > 0 {
+			rates = append(rates, m.DocumentsPerHour)
+		}
+	}
+
+	for i := range recs {
+		rec := &recs[i]
+		if rec.Type != "low_document_rate" || len(rates) < minTuningSamples {
+			continue
+		}
+
+		typical := median(rates)
+		rec.Threshold = math.Max(1, math.Round(typical*lowRateShare))
+		rec.Condition = fmt.Sprintf("kkt_documents_per_hour < %g", rec.Threshold)
+		rec.Description = fmt.Sprintf("Alert when a register issues fewer than %g documents per hour", rec.Threshold)
+		rec.Rationale = fmt.Sprintf("Working registers issued a median of %.1f documents per hour over %d samples; "+
+			"a quarter of it may indicate a KKT malfunction or business operation issues.", typical, len(rates))
+	}
+	return recs
+}
+
+// median returns the median of values, reordering them
+func median(values []float64) float64 {
+	sort.Float64s(values)
+	n := len(values)
+	if n%2 == 1 {
+		return values[n/2]
+	}
+	return (values[n/2-1] + values[n/2]) / 2
+}
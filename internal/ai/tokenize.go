@@ -0,0 +1,129 @@
+package ai
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Placeholders of masked tokens in error patterns
+const (
+	maskNumber   = "<num>"
+	maskID       = "<id>"
+	maskVariable = "<*>"
+)
+
+// stopWords are frequent Russian and English words that carry no meaning
+// for clustering
+var stopWords = map[string]bool{
+	"и": true, "в": true, "во": true, "не": true, "на": true, "с": true, "со": true,
+	"по": true, "к": true, "о": true, "об": true, "от": true, "до": true, "из": true,
+	"за": true, "для": true, "при": true, "что": true, "это": true, "как": true,
+	"а": true, "но": true, "или": true, "у": true, "же": true, "ли": true, "бы": true,
+	"the": true, "a": true, "an": true, "of": true, "to": true, "in": true, "on": true,
+	"for": true, "is": true, "are": true, "was": true, "be": true, "at": true, "by": true,
+	"with": true, "and": true, "or": true, "not": true, "from": true, "has": true, "have": true,
+}
+
+// russianEndings are inflectional endings stripped from Russian words,
+// longest first
+var russianEndings = []string{
+	"иями", "ями", "ами", "ого", "его", "ому", "ему", "ыми", "ими",
+	"ией", "ия", "ие", "ий", "ая", "яя", "ое", "ее", "ые", "ой", "ей",
+	"ом", "ем", "ам", "ям", "ах", "ях", "ую", "юю", "ов", "ев",
+	"ы", "и", "а", "я", "о", "е", "у", "ю", "ь", "й",
+}
+
+// englishEndings are endings stripped from English words, longest first
+var englishEndings = []string{"ing", "ed", "s"}
+
+// minStemLength is the shortest stem left by stripping an ending
+const minStemLength = 4
+
+// tokenize splits an error message into normalized words for similarity:
+// lower case, without stop words, numbers and identifiers, with endings
+// stripped so that inflected forms match
+func tokenize(message string) []string {
+	var tokens []string
+	for _, field := range strings.Fields(normalize(message)) {
+		if mask(trimPunct(field)) != "" {
+			continue
+		}
+		for _, word := range strings.FieldsFunc(field, func(r rune) bool { return !unicode.IsLetter(r) }) {
+			if utf8.RuneCountInString(word) < 2 || stopWords[word] {
+				continue
+			}
+			tokens = append(tokens, stem(word))
+		}
+	}
+	return tokens
+}
+
+// template returns a message with numbers and identifiers masked, for
+// error patterns
+func template(message string) []string {
+	fields := strings.Fields(message)
+	for i, field := range fields {
+		core := trimPunct(field)
+		if m := mask(core); m != "" {
+			fields[i] = strings.Replace(field, core, m, 1)
+		}
+	}
+	return fields
+}
+
+// maxUnitLength is the longest unit after a number, e.g. 30s or 512kb
+const maxUnitLength = 3
+
+// mask returns the placeholder of a token holding a number or an
+// identifier, or "" for other tokens. Numbers are digits with separators
+// and an optional unit, e.g. 235, 1.5, 12:30 or 30s; identifiers mix digits
+// and letters otherwise, e.g. kkt-001 or a UUID.
+func mask(token string) string {
+	number := strings.TrimRightFunc(token, unicode.IsLetter)
+	if utf8.RuneCountInString(token)-utf8.RuneCountInString(number) > maxUnitLength {
+		number = token
+	}
+
+	digits, letters := false, false
+	for _, r := range number {
+		switch {
+		case unicode.IsDigit(r):
+			digits = true
+		case unicode.IsLetter(r):
+			letters = true
+		}
+	}
+	switch {
+	case !digits:
+		return ""
+	case letters:
+		return maskID
+	default:
+		return maskNumber
+	}
+}
+
+// normalize lowers the case of text and folds ё into е
+func normalize(text string) string {
+	return strings.ReplaceAll(strings.ToLower(text), "ё", "е")
+}
+
+// trimPunct trims punctuation around a token
+func trimPunct(token string) string {
+	return strings.TrimFunc(token, func(r rune) bool { return unicode.IsPunct(r) || unicode.IsSymbol(r) })
+}
+
+// stem strips an inflectional ending from a Russian or English word
+func stem(word string) string {
+	endings := englishEndings
+	if r, _ := utf8.DecodeRuneInString(word); unicode.Is(unicode.Cyrillic, r) {
+		endings = russianEndings
+	}
+	for _, ending := range endings {
+		if s, ok := strings.CutSuffix(word, ending); ok && utf8.RuneCountInString(s) >= minStemLength {
+			return s
+		}
+	}
+	return word
+}
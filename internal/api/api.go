@@ -0,0 +1,381 @@
+package api
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/ai"
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/domain"
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/pkg/logger"
+)
+
+// Limits of the number of errors returned by the errors endpoints
+const (
+	defaultErrorsLimit = 100
+	maxErrorsLimit     = 1000
+)
+
+// historyDefaultRange is the time range of history requests without since
+const historyDefaultRange = 24 * time.Hour
+
+// historyMetricsLimit is the number of the latest samples returned by the
+// device history endpoint
+const historyMetricsLimit = 10000
+
+//go:embed openapi.yaml
+var openAPISpec []byte
+
+// Fleet provides the current state of the KKT devices
+type Fleet interface {
+	// Devices returns all known devices
+	Devices() []domain.KKTDevice
+	// Device returns a device by ID
+	Device(id string) (domain.KKTDevice, bool)
+	// DeviceMetrics returns the latest metrics of a device, one per collector
+	DeviceMetrics(id string) []domain.Metrics
+}
+
+// ErrorSource returns the recent KKT errors, oldest first
+type ErrorSource func() []domain.KKTError
+
+// History provides stored metrics samples and errors
+type History interface {
+	// Metrics returns at most limit of the latest samples of a device in
+	// [from, to) ordered by time
+	Metrics(kktID string, from, to time.Time, limit int) ([]domain.Metrics, error)
+	// Errors returns at most limit of the latest errors in [from, to) that
+	// match, ordered by time
+	Errors(from, to time.Time, match func(domain.KKTError) bool, limit int) ([]domain.KKTError, error)
+}
+
+// Server serves the JSON API
+type Server struct {
+	fleet     Fleet
+	errors    ErrorSource
+	insights  *ai.Insights
+	history   History
+	lifecycle Lifecycle
+	token     string
+	log       *logger.Logger
+	now       func() time.Time
+}
+
+// New creates an API server over the given sources
+func New(fleet Fleet, errors ErrorSource, insights *ai.Insights, log *logger.Logger) *Server {
+	return &Server{
+		fleet:    fleet,
+		errors:   errors,
+		insights: insights,
+		log:      log,
+		now:      time.Now,
+	}
+}
+
+// SetHistory enables the history endpoints
+func (s *Server) SetHistory(history History) {
+	s.history = history
+}
+
+// Handler returns the HTTP handler of the API with all routes under basePath
+func (s *Server) Handler(basePath string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+basePath+"/devices", s.handleDevices)
+	mux.HandleFunc("GET "+basePath+"/devices/{id}", s.handleDevice)
+	mux.HandleFunc("GET "+basePath+"/devices/{id}/metrics", s.handleDeviceMetrics)
+	mux.HandleFunc("GET "+basePath+"/devices/{id}/history", s.handleDeviceHistory)
+	mux.HandleFunc("GET "+basePath+"/errors", s.handleErrors)
+	mux.HandleFunc("GET "+basePath+"/history/errors", s.handleHistoryErrors)
+	mux.HandleFunc("GET "+basePath+"/tracked-errors", s.handleTrackedErrors)
+	mux.HandleFunc("GET "+basePath+"/tracked-errors/{id}", s.handleTrackedError)
+	mux.HandleFunc("POST "+basePath+"/tracked-errors/{id}/acknowledge", s.handleAcknowledge)
+	mux.HandleFunc("POST "+basePath+"/tracked-errors/{id}/resolve", s.handleResolve)
+	mux.HandleFunc("GET "+basePath+"/ai/error-clusters", s.handleClusters)
+	mux.HandleFunc("GET "+basePath+"/ai/alert-recommendations", s.handleRecommendations)
+	mux.HandleFunc("GET "+basePath+"/openapi.yaml", s.handleOpenAPI)
+	mux.HandleFunc(basePath+"/", func(w http.ResponseWriter, r *http.Request) {
+		s.writeError(w, http.StatusNotFound, "not found")
+	})
+	return mux
+}
+
+// devicesResponse is the body of the device list
+type devicesResponse struct {
+	Devices []domain.KKTDevice `json:"devices"`
+}
+
+// metricsResponse is the body of the device metrics
+type metricsResponse struct {
+	Metrics []domain.Metrics `json:"metrics"`
+}
+
+// errorsResponse is the body of the error list
+type errorsResponse struct {
+	Errors []domain.KKTError `json:"errors"`
+}
+
+// clustersResponse is the body of the error clusters
+type clustersResponse struct {
+	Clusters  []ai.ErrorCluster `json:"clusters"`
+	UpdatedAt *time.Time        `json:"updated_at,omitempty"`
+}
+
+// recommendationsResponse is the body of the alert recommendations
+type recommendationsResponse struct {
+	Recommendations []ai.AlertRecommendation `json:"recommendations"`
+	UpdatedAt       *time.Time               `json:"updated_at,omitempty"`
+}
+
+// errorResponse is the body of failed requests
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func (s *Server) handleDevices(w http.ResponseWriter, r *http.Request) {
+	devices := s.fleet.Devices()
+	sort.Slice(devices, func(i, j int) bool { return devices[i].ID < devices[j].ID })
+	if devices == nil {
+		devices = []domain.KKTDevice{}
+	}
+	s.writeJSON(w, http.StatusOK, devicesResponse{Devices: devices})
+}
+
+func (s *Server) handleDevice(w http.ResponseWriter, r *http.Request) {
+	device, ok := s.fleet.Device(r.PathValue("id"))
+	if !ok {
+		s.writeError(w, http.StatusNotFound, "device not found")
+		return
+	}
+	s.writeJSON(w, http.StatusOK, device)
+}
+
+func (s *Server) handleDeviceMetrics(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if _, ok := s.fleet.Device(id); !ok {
+		s.writeError(w, http.StatusNotFound, "device not found")
+		return
+	}
+	metrics := s.fleet.DeviceMetrics(id)
+	if metrics == nil {
+		metrics = []domain.Metrics{}
+	}
+	s.writeJSON(w, http.StatusOK, metricsResponse{Metrics: metrics})
+}
+
+func (s *Server) handleErrors(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseErrorFilter(r)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var all []domain.KKTError
+	if s.errors != nil {
+		all = s.errors()
+	}
+	s.writeJSON(w, http.StatusOK, errorsResponse{Errors: filter.newest(all)})
+}
+
+func (s *Server) handleDeviceHistory(w http.ResponseWriter, r *http.Request) {
+	if s.history == nil {
+		s.writeError(w, http.StatusNotFound, "history is disabled")
+		return
+	}
+	since, until, err := parseTimeRange(r)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	from, to := s.historyRange(since, until)
+
+	metrics, err := s.history.Metrics(r.PathValue("id"), from, to, historyMetricsLimit)
+	if err != nil {
+		s.log.Error("Failed to read metrics history", "error", err)
+		s.writeError(w, http.StatusInternalServerError, "failed to read history")
+		return
+	}
+	if metrics == nil {
+		metrics = []domain.Metrics{}
+	}
+	s.writeJSON(w, http.StatusOK, metricsResponse{Metrics: metrics})
+}
+
+func (s *Server) handleHistoryErrors(w http.ResponseWriter, r *http.Request) {
+	if s.history == nil {
+		s.writeError(w, http.StatusNotFound, "history is disabled")
+		return
+	}
+	filter, err := parseErrorFilter(r)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	from, to := s.historyRange(filter.since, filter.until)
+
+	all, err := s.history.Errors(from, to, filter.match, filter.limit)
+	if err != nil {
+		s.log.Error("Failed to read error history", "error", err)
+		s.writeError(w, http.StatusInternalServerError, "failed to read history")
+		return
+	}
+	s.writeJSON(w, http.StatusOK, errorsResponse{Errors: filter.newest(all)})
+}
+
+// historyRange returns the time range of a history request: until
+// defaults to now, since to a day before until
+func (s *Server) historyRange(since, until time.Time) (time.Time, time.Time) {
+	if until.IsZero() {
+		until = s.now()
+	}
+	if since.IsZero() {
+		since = until.Add(-historyDefaultRange)
+	}
+	return since, until
+}
+
+func (s *Server) handleClusters(w http.ResponseWriter, r *http.Request) {
+	resp := clustersResponse{Clusters: []ai.ErrorCluster{}}
+	if s.insights != nil {
+		clusters, at := s.insights.Clusters()
+		if clusters != nil {
+			resp.Clusters = clusters
+		}
+		resp.UpdatedAt = timePtr(at)
+	}
+	s.writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) handleRecommendations(w http.ResponseWriter, r *http.Request) {
+	resp := recommendationsResponse{Recommendations: []ai.AlertRecommendation{}}
+	if s.insights != nil {
+		recommendations, at := s.insights.Recommendations()
+		if recommendations != nil {
+			resp.Recommendations = recommendations
+		}
+		resp.UpdatedAt = timePtr(at)
+	}
+	s.writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/yaml")
+	if _, err := w.Write(openAPISpec); err != nil {
+		s.log.Error("Failed to write API response", "error", err)
+	}
+}
+
+// errorFilter selects errors of the errors endpoint
+type errorFilter struct {
+	kktID     string
+	errorType *domain.ErrorType
+	severity  *domain.ErrorSeverity
+	since     time.Time
+	until     time.Time
+	limit     int
+}
+
+// parseErrorFilter parses the query parameters of the errors endpoint
+func parseErrorFilter(r *http.Request) (errorFilter, error) {
+	q := r.URL.Query()
+	filter := errorFilter{kktID: q.Get("kkt_id"), limit: defaultErrorsLimit}
+
+	if v := q.Get("type"); v != "" {
+		var t domain.ErrorType
+		if err := t.UnmarshalText([]byte(v)); err != nil {
+			return filter, fmt.Errorf("invalid type: %w", err)
+		}
+		filter.errorType = &t
+	}
+	if v := q.Get("severity"); v != "" {
+		var s domain.ErrorSeverity
+		if err := s.UnmarshalText([]byte(v)); err != nil {
+			return filter, fmt.Errorf("invalid severity: %w", err)
+		}
+		filter.severity = &s
+	}
+	var err error
+	if filter.since, filter.until, err = parseTimeRange(r); err != nil {
+		return filter, err
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxErrorsLimit {
+			return filter, fmt.Errorf("invalid limit: %q (must be between 1 and %d)", v, maxErrorsLimit)
+		}
+		filter.limit = n
+	}
+
+	return filter, nil
+}
+
+// parseTimeRange parses the since and until query parameters, zero when
+// missing
+func parseTimeRange(r *http.Request) (since, until time.Time, err error) {
+	q := r.URL.Query()
+	if v := q.Get("since"); v != "" {
+		if since, err = time.Parse(time.RFC3339, v); err != nil {
+			return since, until, fmt.Errorf("invalid since: %w", err)
+		}
+	}
+	if v := q.Get("until"); v != "" {
+		if until, err = time.Parse(time.RFC3339, v); err != nil {
+			return since, until, fmt.Errorf("invalid until: %w", err)
+		}
+	}
+	return since, until, nil
+}
+
+// match reports whether an error passes the filter
+func (f errorFilter) match(kktErr domain.KKTError) bool {
+	switch {
+	case f.kktID != "" && kktErr.KKTID != f.kktID:
+		return false
+	case f.errorType != nil && kktErr.ErrorType != *f.errorType:
+		return false
+	case f.severity != nil && kktErr.Severity != *f.severity:
+		return false
+	case !f.since.IsZero() && kktErr.Timestamp.Before(f.since):
+		return false
+	case !f.until.IsZero() && !kktErr.Timestamp.Before(f.until):
+		return false
+	}
+	return true
+}
+
+// newest returns up to limit matching errors, newest first, of errors
+// ordered oldest first
+func (f errorFilter) newest(errs []domain.KKTError) []domain.KKTError {
+	out := []domain.KKTError{}
+	for i := len(errs) - 1; i >= 0 && len(out) < f.limit; i-- {
+		if f.match(errs[i]) {
+			out = append(out, errs[i])
+		}
+	}
+	return out
+}
+
+// writeJSON writes v as a JSON response
+func (s *Server) writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		s.log.Error("Failed to write API response", "error", err)
+	}
+}
+
+// writeError writes an error response
+func (s *Server) writeError(w http.ResponseWriter, code int, message string) {
+	s.writeJSON(w, code, errorResponse{Error: message})
+}
+
+// timePtr returns a pointer to t, or nil for the zero time
+func timePtr(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
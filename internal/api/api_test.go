@@ -0,0 +1,346 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/ai"
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/config"
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/domain"
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/state"
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/pkg/logger"
+)
+
+// testFleet is a fleet of fixed devices and metrics
+type testFleet struct {
+	devices []domain.KKTDevice
+	metrics map[string][]domain.Metrics
+}
+
+func (f *testFleet) Devices() []domain.KKTDevice {
+	return append([]domain.KKTDevice(nil), f.devices...)
+}
+
+func (f *testFleet) Device(id string) (domain.KKTDevice, bool) {
+	for _, d := range f.devices {
+		if d.ID == id {
+			return d, true
+		}
+	}
+	return domain.KKTDevice{}, false
+}
+
+func (f *testFleet) DeviceMetrics(id string) []domain.Metrics {
+	return f.metrics[id]
+}
+
+func newTestServer() *Server {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	fleet := &testFleet{
+		devices: []domain.KKTDevice{
+			{ID: "kkt-002", Status: domain.KKTStatusError, LastSeen: now},
+			{ID: "kkt-001", Status: domain.KKTStatusRunning, LastSeen: now, ShiftStatus: domain.ShiftStatusOpen},
+		},
+		metrics: map[string][]domain.Metrics{
+			"kkt-001": {{KKTID: "kkt-001", Collector: "store-1", Timestamp: now, DocumentsTotal: 42}},
+		},
+	}
+	errs := []domain.KKTError{
+		{ID: "e1", KKTID: "kkt-001", ErrorType: domain.ErrorTypeNetwork, Severity: domain.ErrorSeverityWarning, Timestamp: now.Add(-3 * time.Hour)},
+		{ID: "e2", KKTID: "kkt-002", ErrorType: domain.ErrorTypeOFD, Severity: domain.ErrorSeverityError, Timestamp: now.Add(-2 * time.Hour)},
+		{ID: "e3", KKTID: "kkt-001", ErrorType: domain.ErrorTypeOFD, Severity: domain.ErrorSeverityCritical, Timestamp: now.Add(-time.Hour)},
+	}
+	insights := ai.NewInsights()
+	insights.SetClusters([]ai.ErrorCluster{{ID: "cluster-1", Count: 5}}, now)
+
+	return New(fleet, func() []domain.KKTError { return errs }, insights, logger.New("error", "json"))
+}
+
+func get(t *testing.T, s *Server, target string) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	s.Handler("/api/v1").ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	return rec
+}
+
+func decode(t *testing.T, rec *httptest.ResponseRecorder, v any) {
+	t.Helper()
+	if err := json.NewDecoder(rec.Body).Decode(v); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+}
+
+func TestServer_Devices(t *testing.T) {
+	s := newTestServer()
+
+	rec := get(t, s, "/api/v1/devices")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status code 200, got %d", rec.Code)
+	}
+	var list devicesResponse
+	decode(t, rec, &list)
+	if len(list.Devices) != 2 || list.Devices[0].ID != "kkt-001" {
+		t.Fatalf("Expected devices ordered by ID, got %+v", list.Devices)
+	}
+
+	rec = get(t, s, "/api/v1/devices/kkt-001")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status code 200, got %d", rec.Code)
+	}
+	var raw map[string]any
+	decode(t, rec, &raw)
+	if raw["status"] != "running" || raw["shift_status"] != "open" {
+		t.Errorf("Expected status running and shift open, got %v and %v", raw["status"], raw["shift_status"])
+	}
+
+	rec = get(t, s, "/api/v1/devices/kkt-001/metrics")
+	var metrics metricsResponse
+	decode(t, rec, &metrics)
+	if len(metrics.Metrics) != 1 || metrics.Metrics[0].DocumentsTotal != 42 {
+		t.Errorf("Expected metrics of store-1, got %+v", metrics.Metrics)
+	}
+
+	rec = get(t, s, "/api/v1/devices/kkt-002/metrics")
+	decode(t, rec, &metrics)
+	if metrics.Metrics == nil || len(metrics.Metrics) != 0 {
+		t.Errorf("Expected empty metrics list, got %+v", metrics.Metrics)
+	}
+
+	for _, target := range []string{"/api/v1/devices/kkt-404", "/api/v1/devices/kkt-404/metrics", "/api/v1/unknown"} {
+		rec = get(t, s, target)
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("%s: expected status code 404, got %d", target, rec.Code)
+		}
+		var resp errorResponse
+		decode(t, rec, &resp)
+		if resp.Error == "" {
+			t.Errorf("%s: expected error message", target)
+		}
+	}
+}
+
+func TestServer_Errors(t *testing.T) {
+	s := newTestServer()
+
+	tests := []struct {
+		name     string
+		query    string
+		wantCode int
+		wantIDs  []string
+	}{
+		{name: "all newest first", query: "", wantCode: http.StatusOK, wantIDs: []string{"e3", "e2", "e1"}},
+		{name: "by device", query: "?kkt_id=kkt-001", wantCode: http.StatusOK, wantIDs: []string{"e3", "e1"}},
+		{name: "by type name", query: "?type=ofd", wantCode: http.StatusOK, wantIDs: []string{"e3", "e2"}},
+		{name: "by severity number", query: "?severity=2", wantCode: http.StatusOK, wantIDs: []string{"e1"}},
+		{name: "by time range", query: "?since=2024-05-01T09:00:00Z&until=2024-05-01T11:00:00Z", wantCode: http.StatusOK, wantIDs: []string{"e2", "e1"}},
+		{name: "limit", query: "?limit=1", wantCode: http.StatusOK, wantIDs: []string{"e3"}},
+		{name: "combined", query: "?kkt_id=kkt-001&type=network&severity=warning", wantCode: http.StatusOK, wantIDs: []string{"e1"}},
+		{name: "invalid type", query: "?type=disk", wantCode: http.StatusBadRequest},
+		{name: "invalid since", query: "?since=yesterday", wantCode: http.StatusBadRequest},
+		{name: "invalid limit", query: "?limit=0", wantCode: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := get(t, s, "/api/v1/errors"+tt.query)
+			if rec.Code != tt.wantCode {
+				t.Fatalf("Expected status code %d, got %d", tt.wantCode, rec.Code)
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+
+			var resp errorsResponse
+			decode(t, rec, &resp)
+			var ids []string
+			for _, e := range resp.Errors {
+				ids = append(ids, e.ID)
+			}
+			if strings.Join(ids, ",") != strings.Join(tt.wantIDs, ",") {
+				t.Errorf("Expected errors %v, got %v", tt.wantIDs, ids)
+			}
+		})
+	}
+}
+
+func TestServer_AI(t *testing.T) {
+	s := newTestServer()
+
+	rec := get(t, s, "/api/v1/ai/error-clusters")
+	var clusters clustersResponse
+	decode(t, rec, &clusters)
+	if len(clusters.Clusters) != 1 || clusters.UpdatedAt == nil {
+		t.Errorf("Expected 1 cluster with update time, got %+v", clusters)
+	}
+
+	rec = get(t, s, "/api/v1/ai/alert-recommendations")
+	var raw map[string]any
+	decode(t, rec, &raw)
+	if list, ok := raw["recommendations"].([]any); !ok || len(list) != 0 {
+		t.Errorf("Expected empty recommendations list, got %v", raw["recommendations"])
+	}
+	if _, ok := raw["updated_at"]; ok {
+		t.Error("Expected no update time before the first advisor run")
+	}
+
+	rec = get(t, s, "/api/v1/openapi.yaml")
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Body.String(), "openapi:") {
+		t.Errorf("Expected OpenAPI spec, got %d", rec.Code)
+	}
+}
+
+// testHistory is a history of fixed samples and errors
+type testHistory struct {
+	metrics []domain.Metrics
+	errors  []domain.KKTError
+}
+
+func (h *testHistory) Metrics(kktID string, from, to time.Time, limit int) ([]domain.Metrics, error) {
+	var out []domain.Metrics
+	for _, m := range h.metrics {
+		if m.KKTID == kktID && !m.Timestamp.Before(from) && m.Timestamp.Before(to) {
+			out = append(out, m)
+		}
+	}
+	return out, nil
+}
+
+func (h *testHistory) Errors(from, to time.Time, match func(domain.KKTError) bool, limit int) ([]domain.KKTError, error) {
+	var out []domain.KKTError
+	for _, e := range h.errors {
+		if !e.Timestamp.Before(from) && e.Timestamp.Before(to) && match(e) {
+			out = append(out, e)
+		}
+	}
+	return out, nil
+}
+
+func TestServer_History(t *testing.T) {
+	now := time.Date(2024, 5, 8, 12, 0, 0, 0, time.UTC)
+	s := newTestServer()
+
+	if rec := get(t, s, "/api/v1/history/errors"); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected status code 404 without history, got %d", rec.Code)
+	}
+
+	s.now = func() time.Time { return now }
+	s.SetHistory(&testHistory{
+		metrics: []domain.Metrics{
+			{KKTID: "kkt-001", Timestamp: now.Add(-48 * time.Hour)},
+			{KKTID: "kkt-001", Timestamp: now.Add(-time.Hour)},
+		},
+		errors: []domain.KKTError{
+			{ID: "h1", KKTID: "kkt-001", ErrorType: domain.ErrorTypeOFD, Timestamp: now.Add(-72 * time.Hour)},
+			{ID: "h2", KKTID: "kkt-002", ErrorType: domain.ErrorTypeOFD, Timestamp: now.Add(-2 * time.Hour)},
+			{ID: "h3", KKTID: "kkt-001", ErrorType: domain.ErrorTypeNetwork, Timestamp: now.Add(-time.Hour)},
+		},
+	})
+
+	var metrics metricsResponse
+	decode(t, get(t, s, "/api/v1/devices/kkt-001/history"), &metrics)
+	if len(metrics.Metrics) != 1 {
+		t.Errorf("Expected 1 sample of the last day, got %d", len(metrics.Metrics))
+	}
+	decode(t, get(t, s, "/api/v1/devices/kkt-001/history?since=2024-05-01T00:00:00Z"), &metrics)
+	if len(metrics.Metrics) != 2 {
+		t.Errorf("Expected 2 samples since May 1, got %d", len(metrics.Metrics))
+	}
+
+	var errs errorsResponse
+	decode(t, get(t, s, "/api/v1/history/errors?since=2024-05-01T00:00:00Z&type=ofd"), &errs)
+	if len(errs.Errors) != 2 || errs.Errors[0].ID != "h2" {
+		t.Errorf("Expected OFD errors h2, h1, got %+v", errs.Errors)
+	}
+
+	if rec := get(t, s, "/api/v1/devices/kkt-001/history?until=never"); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code 400, got %d", rec.Code)
+	}
+}
+
+func TestServer_TrackedErrors(t *testing.T) {
+	s := newTestServer()
+	if rec := get(t, s, "/api/v1/tracked-errors"); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected status code 404 without error tracking, got %d", rec.Code)
+	}
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	store := state.New(config.StateConfig{ErrorsLimit: 10, AutoResolveCycles: 3})
+	store.ApplyError(domain.KKTError{KKTID: "kkt-001", ErrorCode: "E-1", ErrorType: domain.ErrorTypeOFD, Timestamp: now})
+	store.ApplyError(domain.KKTError{KKTID: "kkt-002", ErrorCode: "E-2", ErrorType: domain.ErrorTypeNetwork, Timestamp: now.Add(time.Minute)})
+	s.SetLifecycle(store)
+
+	postAs := func(token, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		s.Handler("/api/v1").ServeHTTP(rec, req)
+		return rec
+	}
+	post := func(target, body string) *httptest.ResponseRecorder {
+		return postAs("secret", target, body)
+	}
+
+	// Actions are disabled without a token
+	if rec := post("/api/v1/tracked-errors/err-1/acknowledge", `{"operator":"ivanov"}`); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected status code 403 without a token, got %d", rec.Code)
+	}
+	s.SetToken("secret")
+	if rec := postAs("", "/api/v1/tracked-errors/err-1/acknowledge", `{"operator":"ivanov"}`); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status code 401 without credentials, got %d", rec.Code)
+	}
+	if rec := postAs("wrong", "/api/v1/tracked-errors/err-1/acknowledge", `{"operator":"ivanov"}`); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status code 401 with a wrong token, got %d", rec.Code)
+	}
+
+	rec := post("/api/v1/tracked-errors/err-1/acknowledge", `{"operator":"ivanov","note":"on site"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status code 200, got %d: %s", rec.Code, rec.Body)
+	}
+	var te domain.TrackedError
+	decode(t, rec, &te)
+	if te.State != domain.ErrorStateAcknowledged || te.AcknowledgedBy != "ivanov" || te.Note != "on site" {
+		t.Errorf("Expected acknowledged error, got %+v", te)
+	}
+
+	tests := []struct {
+		name   string
+		target string
+		body   string
+		want   int
+	}{
+		{"missing operator", "/api/v1/tracked-errors/err-2/resolve", `{"note":"done"}`, http.StatusBadRequest},
+		{"invalid body", "/api/v1/tracked-errors/err-2/resolve", `{`, http.StatusBadRequest},
+		{"unknown error", "/api/v1/tracked-errors/err-9/resolve", `{"operator":"ivanov"}`, http.StatusNotFound},
+		{"resolve", "/api/v1/tracked-errors/err-2/resolve", `{"operator":"ivanov"}`, http.StatusOK},
+		{"already resolved", "/api/v1/tracked-errors/err-2/acknowledge", `{"operator":"ivanov"}`, http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := post(tt.target, tt.body); rec.Code != tt.want {
+				t.Errorf("Expected status code %d, got %d: %s", tt.want, rec.Code, rec.Body)
+			}
+		})
+	}
+
+	var resp trackedErrorsResponse
+	decode(t, get(t, s, "/api/v1/tracked-errors?state=resolved"), &resp)
+	if len(resp.TrackedErrors) != 1 || resp.TrackedErrors[0].ID != "err-2" || resp.TrackedErrors[0].ResolvedBy != "ivanov" {
+		t.Errorf("Expected resolved err-2, got %+v", resp.TrackedErrors)
+	}
+	decode(t, get(t, s, "/api/v1/tracked-errors?kkt_id=kkt-001"), &resp)
+	if len(resp.TrackedErrors) != 1 || resp.TrackedErrors[0].ID != "err-1" {
+		t.Errorf("Expected err-1 of kkt-001, got %+v", resp.TrackedErrors)
+	}
+	if rec := get(t, s, "/api/v1/tracked-errors?state=closed"); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code 400 for an invalid state, got %d", rec.Code)
+	}
+	if rec := get(t, s, "/api/v1/tracked-errors/err-1"); rec.Code != http.StatusOK {
+		t.Errorf("Expected status code 200, got %d", rec.Code)
+	}
+}
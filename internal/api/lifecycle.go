@@ -0,0 +1,160 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/domain"
+)
+
+// maxActionBodySize is the largest accepted body of lifecycle actions
+const maxActionBodySize = 64 << 10
+
+// Lifecycle tracks KKT errors from occurrence to resolution. Acknowledge
+// and Resolve fail with domain.ErrTrackedErrorNotFound for unknown IDs and
+// domain.ErrTrackedErrorResolved for resolved errors.
+type Lifecycle interface {
+	// TrackedErrors returns the tracked errors ordered by first occurrence
+	TrackedErrors() []domain.TrackedError
+	// TrackedError returns a tracked error by ID
+	TrackedError(id string) (domain.TrackedError, bool)
+	// Acknowledge marks an unresolved error as being handled by an operator
+	Acknowledge(id, operator, note string) (domain.TrackedError, error)
+	// Resolve resolves an unresolved error on behalf of an operator
+	Resolve(id, operator, note string) (domain.TrackedError, error)
+}
+
+// SetLifecycle enables the tracked errors endpoints
+func (s *Server) SetLifecycle(lifecycle Lifecycle) {
+	s.lifecycle = lifecycle
+}
+
+// SetToken sets the bearer token required by the tracked error actions;
+// without a token they are rejected
+func (s *Server) SetToken(token string) {
+	s.token = token
+}
+
+// trackedErrorsResponse is the body of the tracked error list
+type trackedErrorsResponse struct {
+	TrackedErrors []domain.TrackedError `json:"tracked_errors"`
+}
+
+// actionRequest is the body of acknowledge and resolve requests
+type actionRequest struct {
+	Operator string `json:"operator"`
+	Note     string `json:"note"`
+}
+
+func (s *Server) handleTrackedErrors(w http.ResponseWriter, r *http.Request) {
+	if s.lifecycle == nil {
+		s.writeError(w, http.StatusNotFound, "error tracking is disabled")
+		return
+	}
+	filter, err := parseErrorFilter(r)
+	if err != nil {
+		s.writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var errState *domain.ErrorState
+	if v := r.URL.Query().Get("state"); v != "" {
+		var st domain.ErrorState
+		if err := st.UnmarshalText([]byte(v)); err != nil {
+			s.writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid state: %v", err))
+			return
+		}
+		errState = &st
+	}
+
+	all := s.lifecycle.TrackedErrors()
+	out := []domain.TrackedError{}
+	for i := len(all) - 1; i >= 0 && len(out) < filter.limit; i-- {
+		if filter.match(all[i].KKTError) && (errState == nil || all[i].State == *errState) {
+			out = append(out, all[i])
+		}
+	}
+	s.writeJSON(w, http.StatusOK, trackedErrorsResponse{TrackedErrors: out})
+}
+
+func (s *Server) handleTrackedError(w http.ResponseWriter, r *http.Request) {
+	if s.lifecycle == nil {
+		s.writeError(w, http.StatusNotFound, "error tracking is disabled")
+		return
+	}
+	te, ok := s.lifecycle.TrackedError(r.PathValue("id"))
+	if !ok {
+		s.writeError(w, http.StatusNotFound, domain.ErrTrackedErrorNotFound.Error())
+		return
+	}
+	s.writeJSON(w, http.StatusOK, te)
+}
+
+func (s *Server) handleAcknowledge(w http.ResponseWriter, r *http.Request) {
+	s.handleAction(w, r, func(l Lifecycle, id string, req actionRequest) (domain.TrackedError, error) {
+		return l.Acknowledge(id, req.Operator, req.Note)
+	})
+}
+
+func (s *Server) handleResolve(w http.ResponseWriter, r *http.Request) {
+	s.handleAction(w, r, func(l Lifecycle, id string, req actionRequest) (domain.TrackedError, error) {
+		return l.Resolve(id, req.Operator, req.Note)
+	})
+}
+
+// handleAction decodes the body of a lifecycle action, applies it to the
+// tracked error of the path and responds with the updated error
+func (s *Server) handleAction(w http.ResponseWriter, r *http.Request,
+	action func(l Lifecycle, id string, req actionRequest) (domain.TrackedError, error)) {
+	if s.lifecycle == nil {
+		s.writeError(w, http.StatusNotFound, "error tracking is disabled")
+		return
+	}
+	if !s.authorize(w, r) {
+		return
+	}
+
+	var req actionRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxActionBodySize)).Decode(&req); err != nil {
+		s.writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid request body: %v", err))
+		return
+	}
+	req.Operator = strings.TrimSpace(req.Operator)
+	if req.Operator == "" {
+		s.writeError(w, http.StatusBadRequest, "operator is required")
+		return
+	}
+
+	te, err := action(s.lifecycle, r.PathValue("id"), req)
+	switch {
+	case errors.Is(err, domain.ErrTrackedErrorNotFound):
+		s.writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, domain.ErrTrackedErrorResolved):
+		s.writeError(w, http.StatusConflict, err.Error())
+	case err != nil:
+		s.log.Error("Failed to update tracked error", "id", r.PathValue("id"), "error", err)
+		s.writeError(w, http.StatusInternalServerError, "failed to update tracked error")
+	default:
+		s.log.Info("Tracked error updated", "id", te.ID, "state", te.State, "operator", req.Operator)
+		s.writeJSON(w, http.StatusOK, te)
+	}
+}
+
+// authorize checks the bearer token of a request that changes state and
+// writes the error response when it is missing or wrong
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request) bool {
+	if s.token == "" {
+		s.writeError(w, http.StatusForbidden, "tracked error actions are disabled without server.api_token")
+		return false
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		s.writeError(w, http.StatusUnauthorized, "invalid or missing bearer token")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,233 @@
+package collector
+
+import (
+	"time"
+
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/domain"
+)
+
+// maxUnsentDocuments bounds the documents tracked per device until they are
+// acknowledged; the oldest are dropped first
+const maxUnsentDocuments = 10000
+
+// deviceState holds aggregated metrics of a single device
+type deviceState struct {
+	metrics       domain.Metrics
+	documentTimes []time.Time
+	// unsent holds the documents not acknowledged by the OFD with their
+	// first send time
+	unsent *unsentQueue
+	// ackLatencies are the acknowledgement latencies since the last flush
+	ackLatencies []float64
+	dirty        bool
+}
+
+// aggregator turns a stream of log events into per-device metrics
+type aggregator struct {
+	devices map[string]*deviceState
+}
+
+// newAggregator creates a new aggregator
+func newAggregator() *aggregator {
+	return &aggregator{
+		devices: make(map[string]*deviceState),
+	}
+}
+
+// device returns the state of a device, creating it on first use
+func (a *aggregator) device(kktID string) *deviceState {
+	d, ok := a.devices[kktID]
+	if !ok {
+		d = &deviceState{
+			metrics: domain.Metrics{
+				KKTID:        kktID,
+				Status:       domain.KKTStatusRunning,
+				ErrorsByType: make(map[domain.ErrorType]int64),
+			},
+			unsent: newUnsentQueue(),
+		}
+		a.devices[kktID] = d
+	}
+	return d
+}
+
+// Apply applies a single event to the device state
+func (a *aggregator) Apply(ev logEvent) {
+	d := a.device(ev.KKTID)
+	d.dirty = true
+	if ev.Time.After(d.metrics.LastEventTime) {
+		d.metrics.LastEventTime = ev.Time
+	}
+
+	switch {
+	case ev.Document != nil:
+		d.applyDocument(ev.Document)
+	case ev.Error != nil:
+		d.applyError(ev.Error)
+	case ev.Status != nil:
+		d.applyStatus(ev.Status)
+	case ev.OFD != nil:
+		d.applyOFD(ev.OFD)
+	}
+}
+
+// applyDocument accounts a fiscal document
+func (d *deviceState) applyDocument(doc *domain.FiscalDocument) {
+	d.metrics.DocumentsTotal++
+	if doc.DateTime.After(d.metrics.LastDocumentTime) {
+		d.metrics.LastDocumentTime = doc.DateTime
+		if doc.DocumentNumber > 0 {
+			d.metrics.LastDocumentNumber = int64(doc.DocumentNumber)
+		}
+	}
+	d.documentTimes = append(d.documentTimes, doc.DateTime)
+	d.metrics.ApplyShiftDocument(*doc)
+	d.metrics.AddReceipt(*doc)
+}
+
+// applyError accounts a device error
+func (d *deviceState) applyError(kktErr *domain.KKTError) {
+	d.metrics.ErrorsByType[kktErr.ErrorType]++
+	if kktErr.Severity >= domain.ErrorSeverityError {
+		d.metrics.Status = domain.KKTStatusError
+	}
+}
+
+// applyStatus applies a partial status update
+func (d *deviceState) applyStatus(st *statusEvent) {
+	if st.Status != nil {
+		d.metrics.Status = *st.Status
+	}
+	if st.ShiftStatus != nil {
+		d.metrics.ShiftStatus = *st.ShiftStatus
+		if d.metrics.ShiftStatus == domain.ShiftStatusClosed {
+			d.metrics.ShiftOpenedAt = time.Time{}
+		}
+	}
+	if st.OFDSyncStatus != nil {
+		d.metrics.OFDSyncStatus = *st.OFDSyncStatus
+	}
+	if st.FDMemoryUsage != nil {
+		d.metrics.FDMemoryUsage = *st.FDMemoryUsage
+	}
+	if st.AverageSyncTime != nil {
+		d.metrics.AverageSyncTime = *st.AverageSyncTime
+	}
+	if st.UnsentDocuments != nil {
+		d.metrics.UnsentDocuments = *st.UnsentDocuments
+		if d.metrics.UnsentDocuments == 0 {
+			// Everything was acknowledged, including documents whose
+			// acknowledgement was not logged
+			d.metrics.OldestUnsentTime = time.Time{}
+			d.unsent.Clear()
+		}
+	}
+	if st.OldestUnsentTime != nil {
+		d.metrics.OldestUnsentTime = *st.OldestUnsentTime
+	}
+	if fd := st.FiscalDrive; fd != nil {
+		d.applyFiscalDrive(*fd)
+		if st.FDMemoryUsage == nil && d.metrics.FiscalDrive.MemoryUsage > 0 {
+			d.metrics.FDMemoryUsage = d.metrics.FiscalDrive.MemoryUsage
+		}
+	}
+}
+
+// applyOFD tracks documents sent to the OFD until they are acknowledged
+func (d *deviceState) applyOFD(tx *domain.OFDTransaction) {
+	if tx.AcknowledgedAt != nil || tx.Status == domain.OFDSyncStatusSynced {
+		sentAt, ok := d.unsent.SentAt(tx.DocumentID)
+		if !ok {
+			sentAt = tx.SentAt
+		}
+		if tx.AcknowledgedAt != nil && !sentAt.IsZero() && !tx.AcknowledgedAt.Before(sentAt) {
+			d.ackLatencies = append(d.ackLatencies, tx.AcknowledgedAt.Sub(sentAt).Seconds())
+		}
+		d.unsent.Remove(tx.DocumentID)
+	} else if _, ok := d.unsent.SentAt(tx.DocumentID); !ok {
+		// Retries keep the time of the first attempt
+		if d.unsent.Len() >= maxUnsentDocuments {
+			d.unsent.DropOldest()
+		}
+		d.unsent.Add(tx.DocumentID, tx.SentAt)
+	}
+
+	d.metrics.UnsentDocuments = int64(d.unsent.Len())
+	d.metrics.OldestUnsentTime = d.unsent.Oldest()
+
+	switch {
+	case tx.Status == domain.OFDSyncStatusError:
+		d.metrics.OFDSyncStatus = domain.OFDSyncStatusError
+	case d.unsent.Len() > 0:
+		d.metrics.OFDSyncStatus = domain.OFDSyncStatusPending
+	default:
+		d.metrics.OFDSyncStatus = domain.OFDSyncStatusSynced
+	}
+}
+
+// applyFiscalDrive merges the known fiscal drive fields. A new drive number
+// means the drive was replaced, so the fields of the old drive are dropped.
+func (d *deviceState) applyFiscalDrive(fd domain.FiscalDrive) {
+	cur := &d.metrics.FiscalDrive
+	if fd.Number != "" && fd.Number != cur.Number {
+		*cur = domain.FiscalDrive{Number: fd.Number}
+	}
+	if !fd.ExpiryDate.IsZero() {
+		cur.ExpiryDate = fd.ExpiryDate
+	}
+	if fd.DocumentsMax > 0 {
+		cur.DocumentsMax = fd.DocumentsMax
+	}
+	if fd.DocumentsUsed > 0 {
+		cur.DocumentsUsed = fd.DocumentsUsed
+	}
+	if fd.MemoryUsage > 0 {
+		cur.MemoryUsage = fd.MemoryUsage
+	} else if cur.DocumentsMax > 0 {
+		cur.MemoryUsage = memoryUsage(*cur)
+	}
+}
+
+// Flush returns metrics of every device updated since the previous flush,
+// and of devices whose documents per hour dropped as documents aged out,
+// ordered by KKT ID. Idle devices are not reported, so that the exporter
+// expires the devices whose logs went silent.
+func (a *aggregator) Flush(now time.Time) []domain.Metrics {
+	var result []domain.Metrics
+
+	// Keep only documents from the last hour for the rate
+	cutoff := now.Add(-time.Hour)
+	for _, kktID := range sortedKeys(a.devices) {
+		d := a.devices[kktID]
+		kept := d.documentTimes[:0]
+		for _, t := range d.documentTimes {
+			if t.After(cutoff) {
+				kept = append(kept, t)
+			}
+		}
+		if len(kept) != len(d.documentTimes) {
+			d.dirty = true
+		}
+		d.documentTimes = kept
+
+		if !d.dirty {
+			continue
+		}
+		d.dirty = false
+
+		m := d.metrics
+		m.Timestamp = now
+		m.DocumentsPerHour = float64(len(d.documentTimes))
+		m.ErrorsByType = make(map[domain.ErrorType]int64, len(d.metrics.ErrorsByType))
+		for errType, count := range d.metrics.ErrorsByType {
+			m.ErrorsByType[errType] = count
+		}
+		m.Receipts = append([]domain.ReceiptTotal(nil), d.metrics.Receipts...)
+		m.ReceiptVAT = append([]domain.VATTotal(nil), d.metrics.ReceiptVAT...)
+		m.OFDAckLatencies = d.ackLatencies
+		d.ackLatencies = nil
+		result = append(result, m)
+	}
+
+	return result
+}
@@ -2,6 +2,7 @@ package collector
 
 import (
 	"context"
+	"time"
 
 	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/domain"
 )
@@ -23,3 +24,35 @@ type Collector interface {
 	// Errors returns the errors channel
 	Errors() <-chan domain.KKTError
 }
+
+// DeviceLister is implemented by collectors that know device details
+// in addition to metrics
+type DeviceLister interface {
+	// Devices returns the devices seen in the last collection cycle
+	Devices() []domain.KKTDevice
+
+	// Device returns a device seen in the last collection cycle. The
+	// devices of a cycle are known before its metrics are sent.
+	Device(id string) (domain.KKTDevice, bool)
+}
+
+// DocumentSource is implemented by collectors that see individual fiscal
+// documents in addition to metrics
+type DocumentSource interface {
+	// Documents returns the fiscal documents channel
+	Documents() <-chan domain.FiscalDocument
+}
+
+// StaleWindow is implemented by collectors whose devices may stay silent
+// for longer than exporter.stale_after while available
+type StaleWindow interface {
+	// StaleAfter returns the silence after which a device of the collector
+	// is unavailable, zero for the exporter default
+	StaleAfter() time.Duration
+}
+
+// HealthReporter is implemented by collectors that track their own health
+type HealthReporter interface {
+	// Health returns the current health state
+	Health() Health
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package collector
+
+import "os"
+
+// getFileID is not supported on this platform; persisted offsets are then
+// matched by path only
+func getFileID(info os.FileInfo) (fileID, bool) {
+	return fileID{}, false
+}
@@ -0,0 +1,18 @@
+//go:build unix
+
+package collector
+
+import (
+	"os"
+	"syscall"
+)
+
+// getFileID returns the device and inode of a file
+func getFileID(info os.FileInfo) (fileID, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileID{}, false
+	}
+	//nolint:unconvert // field types differ between platforms
+	return fileID{Dev: uint64(st.Dev), Ino: uint64(st.Ino)}, true
+}
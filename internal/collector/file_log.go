@@ -1,8 +1,16 @@
 package collector
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
 	"time"
 
 	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/config"
@@ -10,30 +18,76 @@ import (
 	"github.com/ranas-mukminov/kkt-54fz-monitoring/pkg/logger"
 )
 
+func init() {
+	Register("file_log", newFileLogFromConfig)
+}
+
+var (
+	_ HealthReporter = (*FileLogCollector)(nil)
+	_ DocumentSource = (*FileLogCollector)(nil)
+)
+
 // FileLogCollector collects data from file logs
 type FileLogCollector struct {
-	cfg          config.FileLogConfig
-	log          *logger.Logger
-	metricsChan  chan domain.Metrics
-	errorsChan   chan domain.KKTError
-	stopChan     chan struct{}
+	cfg         config.FileLogConfig
+	log         *logger.Logger
+	metricsChan chan domain.Metrics
+	errorsChan  chan domain.KKTError
+	docsChan    chan domain.FiscalDocument
+	stopChan    chan struct{}
+	health      *healthTracker
+	parser      lineParser
+	aggregator  *aggregator
+	tails       map[string]*fileTail
+	saved       map[string]fileOffset
 }
 
 // NewFileLogCollector creates a new file log collector
 func NewFileLogCollector(cfg config.FileLogConfig, log *logger.Logger) *FileLogCollector {
+	if cfg.Name == "" {
+		cfg.Name = "file_log"
+	}
+
 	return &FileLogCollector{
 		cfg:         cfg,
-		log:         log,
+		log:         log.With("collector", cfg.Name),
 		metricsChan: make(chan domain.Metrics, 100),
 		errorsChan:  make(chan domain.KKTError, 100),
+		docsChan:    make(chan domain.FiscalDocument, 100),
 		stopChan:    make(chan struct{}),
+		health:      newHealthTracker(cfg.Name, cfg.PollInterval),
+		parser:      jsonLineParser{},
+		aggregator:  newAggregator(),
+		tails:       make(map[string]*fileTail),
 	}
 }
 
+// newFileLogFromConfig is the factory of file log collectors
+func newFileLogFromConfig(cfg config.CollectorConfig, log *logger.Logger) (Collector, error) {
+	var fl config.FileLogConfig
+	if err := cfg.DecodeFileLog(&fl); err != nil {
+		return nil, err
+	}
+	return NewFileLogCollector(fl, log), nil
+}
+
 // Start begins collecting data
 func (c *FileLogCollector) Start(ctx context.Context) error {
 	c.log.Info("Starting file log collector", "path", c.cfg.Path)
 
+	parser, err := newLineParser(c.cfg)
+	if err != nil {
+		return err
+	}
+	c.parser = parser
+
+	saved, err := loadOffsets(c.cfg.StateFile)
+	if err != nil {
+		return err
+	}
+	c.saved = saved
+
+	c.health.Start()
 	go c.collect(ctx)
 
 	return nil
@@ -46,9 +100,20 @@ func (c *FileLogCollector) Stop() error {
 	return nil
 }
 
-// Name returns the collector name
+// Name returns the collector instance name
 func (c *FileLogCollector) Name() string {
-	return "file_log"
+	return c.cfg.Name
+}
+
+// Health returns the collector health
+func (c *FileLogCollector) Health() Health {
+	return c.health.Health()
+}
+
+// StaleAfter returns the silence after which a device of the collector is
+// unavailable, zero for the exporter default
+func (c *FileLogCollector) StaleAfter() time.Duration {
+	return c.cfg.StaleAfter
 }
 
 // Metrics returns the metrics channel
@@ -61,10 +126,16 @@ func (c *FileLogCollector) Errors() <-chan domain.KKTError {
 	return c.errorsChan
 }
 
+// Documents returns the fiscal documents channel
+func (c *FileLogCollector) Documents() <-chan domain.FiscalDocument {
+	return c.docsChan
+}
+
 // collect is the main collection loop
 func (c *FileLogCollector) collect(ctx context.Context) {
 	ticker := time.NewTicker(c.cfg.PollInterval)
 	defer ticker.Stop()
+	defer c.closeTails()
 
 	for {
 		select {
@@ -73,39 +144,269 @@ func (c *FileLogCollector) collect(ctx context.Context) {
 		case <-c.stopChan:
 			return
 		case <-ticker.C:
-			if err := c.collectOnce(); err != nil {
+			start := time.Now()
+			err := c.collectOnce(ctx)
+			c.observeCycle(start, err)
+			if err != nil {
 				c.log.Error("Failed to collect from file logs", "error", err)
 			}
 		}
 	}
 }
 
-// collectOnce performs one collection cycle
-func (c *FileLogCollector) collectOnce() error {
-	// TODO: Implement actual file log parsing
-	// This is a stub implementation
+// observeCycle records the cycle outcome and the channel fill levels
+func (c *FileLogCollector) observeCycle(start time.Time, err error) {
+	c.health.ObserveCycle(start, err)
+	c.health.ChannelFill("metrics", len(c.metricsChan), cap(c.metricsChan))
+	c.health.ChannelFill("errors", len(c.errorsChan), cap(c.errorsChan))
+	c.health.ChannelFill("documents", len(c.docsChan), cap(c.docsChan))
+}
+
+// collectOnce performs one collection cycle. Files that cannot be opened or
+// read, or no file matching the path, fail the cycle after the readable
+// files are processed. When ctx is canceled while documents wait to be
+// delivered, the read positions are saved without flushing metrics.
+func (c *FileLogCollector) collectOnce(ctx context.Context) error {
 	c.log.Debug("Collecting from file logs", "path", c.cfg.Path)
 
-	// Stub: generate sample metrics
-	metrics := domain.Metrics{
-		KKTID:            "stub-kkt-001",
-		Timestamp:        time.Now(),
-		Status:           domain.KKTStatusRunning,
-		DocumentsTotal:   1000,
-		ErrorsByType:     make(map[domain.ErrorType]int64),
-		OFDSyncStatus:    domain.OFDSyncStatusSynced,
-		ShiftStatus:      domain.ShiftStatusOpen,
-		LastDocumentTime: time.Now().Add(-5 * time.Minute),
-		FDMemoryUsage:    45.5,
-		DocumentsPerHour: 50.0,
-		AverageSyncTime:  2.5,
+	paths, err := filepath.Glob(c.cfg.Path)
+	if err != nil {
+		return fmt.Errorf("invalid log path pattern %q: %w", c.cfg.Path, err)
+	}
+	sort.Strings(paths)
+
+	var errs []error
+
+	// Files already open are finished first, so that lines of a rotated
+	// file are read before the lines of its replacement
+	for _, path := range sortedKeys(c.tails) {
+		if err := c.readTail(ctx, c.tails[path]); err != nil && ctx.Err() == nil {
+			errs = append(errs, fmt.Errorf("failed to read log file %s: %w", path, err))
+		}
+	}
+	if ctx.Err() != nil {
+		return c.saveOffsets()
+	}
+
+	for _, path := range paths {
+		if _, ok := c.tails[path]; ok {
+			continue
+		}
+		if err := c.openTail(ctx, path); err != nil && ctx.Err() == nil {
+			errs = append(errs, fmt.Errorf("failed to open log file %s: %w", path, err))
+		}
+	}
+	if ctx.Err() != nil {
+		return c.saveOffsets()
+	}
+	if len(paths) == 0 {
+		errs = append(errs, fmt.Errorf("no log files match %q", c.cfg.Path))
+	}
+
+	for _, metrics := range c.aggregator.Flush(time.Now()) {
+		metrics.Collector = c.Name()
+		select {
+		case c.metricsChan <- metrics:
+		default:
+			c.health.Dropped("metrics")
+			c.log.Warn("Metrics channel full, dropping metrics", "kkt_id", metrics.KKTID)
+		}
+	}
+
+	if err := c.saveOffsets(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// openTail starts tailing a newly discovered file, resuming from the
+// persisted offset when the file is the one recorded in the state
+func (c *FileLogCollector) openTail(ctx context.Context, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	tail := &fileTail{path: path, file: f, info: info}
+
+	if saved, ok := c.saved[path]; ok {
+		id, hasID := getFileID(info)
+		switch {
+		case hasID && id != saved.FileID:
+			// Rotated while we were not running: finish the old file first
+			if old, found := findFileByID(filepath.Dir(path), saved.FileID); found {
+				c.log.Info("Finishing log file rotated while stopped", "file", old, "offset", saved.Offset)
+				if err := c.readRemainder(ctx, old, saved.Offset); err != nil {
+					c.log.Error("Failed to read rotated log file", "file", old, "error", err)
+				}
+			}
+		case saved.Offset <= info.Size() && tail.startsWith(saved.Fingerprint):
+			tail.offset = saved.Offset
+			tail.fingerprint = saved.Fingerprint
+		}
+		delete(c.saved, path)
+	}
+
+	c.tails[path] = tail
+	if err := c.readLines(ctx, tail, false); err != nil {
+		return err
+	}
+	tail.updateFingerprint()
+	return nil
+}
+
+// readTail reads new lines from an open file, handling rotation and truncation
+func (c *FileLogCollector) readTail(ctx context.Context, tail *fileTail) error {
+	info, err := os.Stat(tail.path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist) || (err == nil && !os.SameFile(info, tail.info)):
+		// Rotated by rename (or removed): finish the old file and forget it,
+		// the replacement is picked up as a new file
+		c.log.Info("Log file rotated", "file", tail.path)
+		readErr := c.readLines(ctx, tail, true)
+		if ctx.Err() != nil {
+			// Kept with its offset, the rest is read after a restart
+			return readErr
+		}
+		tail.file.Close()
+		delete(c.tails, tail.path)
+		return readErr
+	case err != nil:
+		return err
+	}
+
+	if info.Size() < tail.offset || !tail.startsWith(tail.fingerprint) {
+		// Truncated in place (copytruncate), maybe already grown past the
+		// old offset: the lines written since the last cycle are in the
+		// copy, read them from there
+		c.log.Info("Log file truncated", "file", tail.path, "offset", tail.offset)
+		if rotated, ok := findRotatedCopy(tail.path, tail.offset); ok {
+			if err := c.readRemainder(ctx, rotated, tail.offset); err != nil {
+				c.log.Error("Failed to read rotated log copy", "file", rotated, "error", err)
+			}
+		}
+		tail.offset = 0
+		tail.fingerprint = nil
+	}
+
+	tail.info = info
+	if err := c.readLines(ctx, tail, false); err != nil {
+		return err
+	}
+	tail.updateFingerprint()
+	return nil
+}
+
+// readRemainder reads an already rotated file from offset to its end
+func (c *FileLogCollector) readRemainder(ctx context.Context, path string, offset int64) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return c.readLines(ctx, &fileTail{path: path, file: f, offset: offset}, true)
+}
+
+// readLines reads complete lines from the tail offset to the end of file.
+// A trailing line without newline is left for the next cycle unless final
+// is set, which is used for files that will not grow anymore. Reading stops
+// at the line whose document could not be delivered before ctx was canceled.
+func (c *FileLogCollector) readLines(ctx context.Context, tail *fileTail, final bool) error {
+	if _, err := tail.file.Seek(tail.offset, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek to offset %d: %w", tail.offset, err)
+	}
+
+	reader := bufio.NewReader(tail.file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) && (!final || len(line) == 0) {
+			return nil
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+
+		lineOffset := tail.offset
+		if err := c.processLine(ctx, tail.path, lineOffset, bytes.TrimSpace(line)); err != nil {
+			return err
+		}
+		tail.offset += int64(len(line))
+	}
+}
+
+// saveOffsets persists the read positions of all open files
+func (c *FileLogCollector) saveOffsets() error {
+	if c.cfg.StateFile == "" {
+		return nil
 	}
 
-	select {
-	case c.metricsChan <- metrics:
-	default:
-		return fmt.Errorf("metrics channel full")
+	offsets := make(map[string]fileOffset, len(c.tails))
+	for path, tail := range c.tails {
+		id, _ := getFileID(tail.info)
+		offsets[path] = fileOffset{FileID: id, Offset: tail.offset, Fingerprint: tail.fingerprint}
 	}
 
+	return saveOffsets(c.cfg.StateFile, offsets)
+}
+
+// closeTails closes all open files
+func (c *FileLogCollector) closeTails() {
+	for path, tail := range c.tails {
+		tail.file.Close()
+		delete(c.tails, path)
+	}
+}
+
+// sortedKeys returns the map keys in sorted order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// processLine parses a line and applies the resulting event. Documents
+// are delivered reliably, as the device state is derived from them; only
+// a canceled ctx stops the delivery, with its error.
+func (c *FileLogCollector) processLine(ctx context.Context, path string, offset int64, line []byte) error {
+	if len(line) == 0 {
+		return nil
+	}
+
+	ev, ok, err := c.parser.Parse(line)
+	if err != nil {
+		c.log.Warn("Skipping malformed log line", "file", path, "offset", offset, "error", err)
+		return nil
+	}
+	if !ok {
+		return nil
+	}
+
+	c.aggregator.Apply(ev)
+
+	if ev.Error != nil {
+		select {
+		case c.errorsChan <- *ev.Error:
+		default:
+			c.health.Dropped("errors")
+			c.log.Warn("Errors channel full, dropping KKT error", "kkt_id", ev.KKTID)
+		}
+	}
+	if ev.Document != nil {
+		select {
+		case c.docsChan <- *ev.Document:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
 	return nil
 }
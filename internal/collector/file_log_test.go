@@ -0,0 +1,773 @@
+package collector
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/config"
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/domain"
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/pkg/logger"
+)
+
+// copyFixture copies a testdata file into dir
+func copyFixture(t *testing.T, name, dir string) string {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("Failed to read fixture: %v", err)
+	}
+
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("Failed to write fixture: %v", err)
+	}
+	return path
+}
+
+// appendLine appends raw data to a log file
+func appendLine(t *testing.T, path, data string) {
+	t.Helper()
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("Failed to open log file: %v", err)
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString(data); err != nil {
+		t.Fatalf("Failed to append to log file: %v", err)
+	}
+}
+
+// drainMetrics returns all metrics currently buffered, keyed by KKT ID
+func drainMetrics(c *FileLogCollector) map[string]domain.Metrics {
+	result := make(map[string]domain.Metrics)
+	for {
+		select {
+		case m := <-c.metricsChan:
+			result[m.KKTID] = m
+		default:
+			return result
+		}
+	}
+}
+
+// drainErrors returns all errors currently buffered
+func drainErrors(c *FileLogCollector) []domain.KKTError {
+	var result []domain.KKTError
+	for {
+		select {
+		case e := <-c.errorsChan:
+			result = append(result, e)
+		default:
+			return result
+		}
+	}
+}
+
+func newTestFileLogCollector(t *testing.T, dir string) *FileLogCollector {
+	t.Helper()
+
+	cfg := config.FileLogConfig{
+		Enabled:      true,
+		Path:         filepath.Join(dir, "*.log"),
+		Format:       "json",
+		PollInterval: time.Second,
+	}
+	return NewFileLogCollector(cfg, logger.New("error", "json"))
+}
+
+func TestJSONLineParser(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		wantOK  bool
+		wantErr bool
+		kind    string
+	}{
+		{
+			name:   "document",
+			line:   `{"kkt_id":"kkt-001","event":"document","document":{"type":1,"document_number":1}}`,
+			wantOK: true,
+			kind:   logEventDocument,
+		},
+		{
+			name:   "error",
+			line:   `{"kkt_id":"kkt-001","event":"error","error":{"error_code":"E1","error_type":1,"severity":2}}`,
+			wantOK: true,
+			kind:   logEventError,
+		},
+		{
+			name:   "status",
+			line:   `{"kkt_id":"kkt-001","event":"status","status":{"status":2}}`,
+			wantOK: true,
+			kind:   logEventStatus,
+		},
+		{
+			name:   "ofd",
+			line:   `{"kkt_id":"kkt-001","event":"ofd","ofd":{"document_id":"kkt-001-101","status":2}}`,
+			wantOK: true,
+			kind:   logEventOFD,
+		},
+		{
+			name:    "ofd without document_id",
+			line:    `{"kkt_id":"kkt-001","event":"ofd","ofd":{"status":2}}`,
+			wantErr: true,
+		},
+		{
+			name: "unknown event is ignored",
+			line: `{"kkt_id":"kkt-001","event":"heartbeat"}`,
+		},
+		{
+			name:    "missing kkt_id",
+			line:    `{"event":"status","status":{"status":1}}`,
+			wantErr: true,
+		},
+		{
+			name:    "missing payload",
+			line:    `{"kkt_id":"kkt-001","event":"document"}`,
+			wantErr: true,
+		},
+		{
+			name:    "not json",
+			line:    `ERROR something happened`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ev, ok, err := jsonLineParser{}.Parse([]byte(tt.line))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if ok != tt.wantOK {
+				t.Fatalf("Parse() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if ok && ev.Kind != tt.kind {
+				t.Errorf("Expected kind %s, got %s", tt.kind, ev.Kind)
+			}
+		})
+	}
+}
+
+func TestFileLogCollector_CollectOnce(t *testing.T) {
+	dir := t.TempDir()
+	copyFixture(t, "atol.log", dir)
+	copyFixture(t, "shtrih.log", dir)
+
+	c := newTestFileLogCollector(t, dir)
+	if err := c.collectOnce(context.Background()); err != nil {
+		t.Fatalf("collectOnce failed: %v", err)
+	}
+
+	metrics := drainMetrics(c)
+	if len(metrics) != 2 {
+		t.Fatalf("Expected metrics for 2 devices, got %d", len(metrics))
+	}
+
+	kkt1 := metrics["kkt-001"]
+	if kkt1.Collector != "file_log" {
+		t.Errorf("Expected metrics labelled with collector file_log, got %q", kkt1.Collector)
+	}
+	if kkt1.DocumentsTotal != 2 {
+		t.Errorf("Expected 2 documents for kkt-001, got %d", kkt1.DocumentsTotal)
+	}
+	if kkt1.ShiftStatus != domain.ShiftStatusOpen {
+		t.Errorf("Expected open shift for kkt-001, got %d", kkt1.ShiftStatus)
+	}
+	if kkt1.OFDSyncStatus != domain.OFDSyncStatusPending {
+		t.Errorf("Expected pending OFD sync for kkt-001, got %d", kkt1.OFDSyncStatus)
+	}
+	if kkt1.FDMemoryUsage != 45.5 {
+		t.Errorf("Expected FD memory usage 45.5, got %v", kkt1.FDMemoryUsage)
+	}
+	if kkt1.ErrorsByType[domain.ErrorTypeOFD] != 1 {
+		t.Errorf("Expected 1 OFD error for kkt-001, got %d", kkt1.ErrorsByType[domain.ErrorTypeOFD])
+	}
+	if kkt1.Status != domain.KKTStatusRunning {
+		t.Errorf("Expected kkt-001 running after a warning, got %d", kkt1.Status)
+	}
+	wantLast := time.Date(2024, 5, 1, 6, 5, 0, 0, time.UTC)
+	if !kkt1.LastDocumentTime.Equal(wantLast) {
+		t.Errorf("Expected last document time %v, got %v", wantLast, kkt1.LastDocumentTime)
+	}
+
+	kkt2 := metrics["kkt-002"]
+	if kkt2.Status != domain.KKTStatusError {
+		t.Errorf("Expected kkt-002 in error state after a critical error, got %d", kkt2.Status)
+	}
+
+	errs := drainErrors(c)
+	if len(errs) != 2 {
+		t.Fatalf("Expected 2 errors, got %d", len(errs))
+	}
+	for _, e := range errs {
+		if e.KKTID == "" || e.ID == "" || e.Timestamp.IsZero() {
+			t.Errorf("Expected error fields filled from envelope, got %+v", e)
+		}
+	}
+
+	if got := len(c.docsChan); got != 3 {
+		t.Fatalf("Expected 3 documents, got %d", got)
+	}
+	if doc := <-c.Documents(); doc.KKTID == "" || doc.DateTime.IsZero() {
+		t.Errorf("Expected document fields filled from envelope, got %+v", doc)
+	}
+}
+
+func TestFileLogCollector_Tail(t *testing.T) {
+	dir := t.TempDir()
+	path := copyFixture(t, "shtrih.log", dir)
+
+	c := newTestFileLogCollector(t, dir)
+	if err := c.collectOnce(context.Background()); err != nil {
+		t.Fatalf("collectOnce failed: %v", err)
+	}
+	drainMetrics(c)
+	drainErrors(c)
+
+	// Nothing new: no metrics are emitted
+	if err := c.collectOnce(context.Background()); err != nil {
+		t.Fatalf("collectOnce failed: %v", err)
+	}
+	if got := drainMetrics(c); len(got) != 0 {
+		t.Fatalf("Expected no metrics without new lines, got %d", len(got))
+	}
+
+	// A complete line followed by a partially written one
+	appendLine(t, path, `{"kkt_id":"kkt-002","event":"document","document":{"type":1,"document_number":5002}}`+"\n")
+	appendLine(t, path, `{"kkt_id":"kkt-002","event":"document","docu`)
+
+	if err := c.collectOnce(context.Background()); err != nil {
+		t.Fatalf("collectOnce failed: %v", err)
+	}
+	if got := drainMetrics(c)["kkt-002"].DocumentsTotal; got != 2 {
+		t.Errorf("Expected 2 documents after append, got %d", got)
+	}
+
+	// Completing the partial line makes it visible
+	appendLine(t, path, `ment":{"type":1,"document_number":5003}}`+"\n")
+
+	if err := c.collectOnce(context.Background()); err != nil {
+		t.Fatalf("collectOnce failed: %v", err)
+	}
+	if got := drainMetrics(c)["kkt-002"].DocumentsTotal; got != 3 {
+		t.Errorf("Expected 3 documents after completing the line, got %d", got)
+	}
+}
+
+func TestFileLogCollector_MissingFiles(t *testing.T) {
+	dir := t.TempDir()
+	path := copyFixture(t, "shtrih.log", dir)
+
+	c := newTestFileLogCollector(t, dir)
+	defer c.closeTails()
+	c.health.Start()
+	if err := c.collectOnce(context.Background()); err != nil {
+		t.Fatalf("collectOnce failed: %v", err)
+	}
+
+	// The log file is gone and nothing replaces it
+	if err := os.Remove(path); err != nil {
+		t.Fatalf("Failed to remove log file: %v", err)
+	}
+	for i := 0; i < staleCycles; i++ {
+		err := c.collectOnce(context.Background())
+		if err == nil {
+			t.Fatal("Expected an error without log files")
+		}
+		c.observeCycle(time.Now(), err)
+	}
+	if got := c.Health().Status; got != HealthUnhealthy {
+		t.Errorf("Expected unhealthy without log files, got %s", got)
+	}
+}
+
+func TestFileLogCollector_DocumentBackpressure(t *testing.T) {
+	dir := t.TempDir()
+	line := `{"kkt_id":"kkt-002","event":"document","document":{"type":1,"document_number":5002}}` + "\n"
+	path := filepath.Join(dir, "kkt.log")
+	if err := os.WriteFile(path, []byte(line+line+line), 0644); err != nil {
+		t.Fatalf("Failed to write log file: %v", err)
+	}
+
+	c := newTestFileLogCollector(t, dir)
+	defer c.closeTails()
+	c.docsChan = make(chan domain.FiscalDocument, 1)
+
+	// A slow consumer gets every document
+	done := make(chan error, 1)
+	go func() { done <- c.collectOnce(context.Background()) }()
+	for i := 0; i < 3; i++ {
+		<-c.Documents()
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("collectOnce failed: %v", err)
+	}
+
+	// Canceled while the consumer is gone: reading stops at the
+	// undelivered document
+	appendLine(t, path, line+line+line)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() { done <- c.collectOnce(ctx) }()
+	<-c.Documents()
+	for len(c.docsChan) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("collectOnce failed: %v", err)
+	}
+	if got, want := c.tails[path].offset, int64(5*len(line)); got != want {
+		t.Errorf("Expected offset %d at the undelivered document, got %d", want, got)
+	}
+}
+
+func TestFileLogCollector_RenameRotation(t *testing.T) {
+	dir := t.TempDir()
+	path := copyFixture(t, "shtrih.log", dir)
+
+	c := newTestFileLogCollector(t, dir)
+	defer c.closeTails()
+	if err := c.collectOnce(context.Background()); err != nil {
+		t.Fatalf("collectOnce failed: %v", err)
+	}
+	drainMetrics(c)
+
+	// Written after the last poll, right before logrotate renames the file
+	appendLine(t, path, `{"kkt_id":"kkt-002","event":"document","document":{"type":1,"document_number":5002}}`+"\n")
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatalf("Failed to rotate: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(`{"kkt_id":"kkt-002","event":"document","document":{"type":1,"document_number":5003}}`+"\n"), 0644); err != nil {
+		t.Fatalf("Failed to create new log file: %v", err)
+	}
+
+	if err := c.collectOnce(context.Background()); err != nil {
+		t.Fatalf("collectOnce failed: %v", err)
+	}
+	if got := drainMetrics(c)["kkt-002"].DocumentsTotal; got != 3 {
+		t.Errorf("Expected 3 documents after rotation, got %d", got)
+	}
+
+	// The new file is tailed from where we stopped
+	if err := c.collectOnce(context.Background()); err != nil {
+		t.Fatalf("collectOnce failed: %v", err)
+	}
+	if got := drainMetrics(c); len(got) != 0 {
+		t.Errorf("Expected no duplicated lines after rotation, got %d updates", len(got))
+	}
+}
+
+func TestFileLogCollector_CopyTruncate(t *testing.T) {
+	dir := t.TempDir()
+	path := copyFixture(t, "shtrih.log", dir)
+
+	c := newTestFileLogCollector(t, dir)
+	defer c.closeTails()
+	if err := c.collectOnce(context.Background()); err != nil {
+		t.Fatalf("collectOnce failed: %v", err)
+	}
+	drainMetrics(c)
+
+	appendLine(t, path, `{"kkt_id":"kkt-002","event":"document","document":{"type":1,"document_number":5002}}`+"\n")
+
+	// logrotate copytruncate: copy the file, then truncate it in place
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read log file: %v", err)
+	}
+	if err := os.WriteFile(path+".1", data, 0644); err != nil {
+		t.Fatalf("Failed to write rotated copy: %v", err)
+	}
+	if err := os.Truncate(path, 0); err != nil {
+		t.Fatalf("Failed to truncate: %v", err)
+	}
+	appendLine(t, path, `{"kkt_id":"kkt-002","event":"document","document":{"type":1,"document_number":5003}}`+"\n")
+
+	if err := c.collectOnce(context.Background()); err != nil {
+		t.Fatalf("collectOnce failed: %v", err)
+	}
+	if got := drainMetrics(c)["kkt-002"].DocumentsTotal; got != 3 {
+		t.Errorf("Expected 3 documents after copytruncate, got %d", got)
+	}
+}
+
+func TestFileLogCollector_CopyTruncateRegrown(t *testing.T) {
+	dir := t.TempDir()
+	path := copyFixture(t, "shtrih.log", dir)
+
+	c := newTestFileLogCollector(t, dir)
+	defer c.closeTails()
+	if err := c.collectOnce(context.Background()); err != nil {
+		t.Fatalf("collectOnce failed: %v", err)
+	}
+	drainMetrics(c)
+
+	appendLine(t, path, `{"kkt_id":"kkt-002","event":"document","document":{"type":1,"document_number":5002}}`+"\n")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read log file: %v", err)
+	}
+	if err := os.WriteFile(path+".1", data, 0644); err != nil {
+		t.Fatalf("Failed to write rotated copy: %v", err)
+	}
+	if err := os.Truncate(path, 0); err != nil {
+		t.Fatalf("Failed to truncate: %v", err)
+	}
+
+	// The file grows past the old offset before the next poll
+	for n := 5003; n <= 5008; n++ {
+		appendLine(t, path, `{"kkt_id":"kkt-002","event":"document","document":{"type":1,"document_number":`+strconv.Itoa(n)+`}}`+"\n")
+	}
+	if info, err := os.Stat(path); err != nil || info.Size() <= int64(len(data)) {
+		t.Fatalf("Expected the log file to grow past %d bytes, got %v", len(data), err)
+	}
+
+	if err := c.collectOnce(context.Background()); err != nil {
+		t.Fatalf("collectOnce failed: %v", err)
+	}
+	if got := drainMetrics(c)["kkt-002"].DocumentsTotal; got != 8 {
+		t.Errorf("Expected 8 documents after copytruncate, got %d", got)
+	}
+}
+
+func TestFileLogCollector_PersistedOffsets(t *testing.T) {
+	dir := t.TempDir()
+	logDir := filepath.Join(dir, "logs")
+	if err := os.Mkdir(logDir, 0755); err != nil {
+		t.Fatalf("Failed to create log dir: %v", err)
+	}
+	path := copyFixture(t, "shtrih.log", logDir)
+	stateFile := filepath.Join(dir, "state", "offsets.json")
+
+	newCollector := func() *FileLogCollector {
+		c := newTestFileLogCollector(t, logDir)
+		c.cfg.StateFile = stateFile
+		saved, err := loadOffsets(stateFile)
+		if err != nil {
+			t.Fatalf("loadOffsets failed: %v", err)
+		}
+		c.saved = saved
+		return c
+	}
+
+	first := newCollector()
+	if err := first.collectOnce(context.Background()); err != nil {
+		t.Fatalf("collectOnce failed: %v", err)
+	}
+	first.closeTails()
+
+	// Restart: old lines are not counted again
+	appendLine(t, path, `{"kkt_id":"kkt-002","event":"document","document":{"type":1,"document_number":5002}}`+"\n")
+
+	second := newCollector()
+	if err := second.collectOnce(context.Background()); err != nil {
+		t.Fatalf("collectOnce failed: %v", err)
+	}
+	if got := drainMetrics(second)["kkt-002"].DocumentsTotal; got != 1 {
+		t.Errorf("Expected only the new document after restart, got %d", got)
+	}
+	second.closeTails()
+
+	// Rotated while stopped: the rest of the old file is read, then the new one
+	appendLine(t, path, `{"kkt_id":"kkt-002","event":"document","document":{"type":1,"document_number":5003}}`+"\n")
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatalf("Failed to rotate: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(`{"kkt_id":"kkt-002","event":"document","document":{"type":1,"document_number":5004}}`+"\n"), 0644); err != nil {
+		t.Fatalf("Failed to create new log file: %v", err)
+	}
+
+	third := newCollector()
+	defer third.closeTails()
+	if err := third.collectOnce(context.Background()); err != nil {
+		t.Fatalf("collectOnce failed: %v", err)
+	}
+	if got := drainMetrics(third)["kkt-002"].DocumentsTotal; got != 2 {
+		t.Errorf("Expected 2 documents after rotation while stopped, got %d", got)
+	}
+}
+
+// textTestConfig returns a text format configuration for testdata/atol.txt
+func textTestConfig(dir string) config.FileLogConfig {
+	return config.FileLogConfig{
+		Enabled:      true,
+		Path:         filepath.Join(dir, "*.txt"),
+		Format:       "text",
+		PollInterval: time.Second,
+		Patterns: []config.LogPattern{
+			{
+				Name:     "open_shift",
+				Event:    "document",
+				Regex:    `^(?P<time>\S+ \S+) \[\w+\] KKT (?P<kkt_id>\d+) shift (?P<shift_number>\d+) opened, FD (?P<document_number>\d+)`,
+				Defaults: map[string]string{"document_type": "open_shift"},
+			},
+			{
+				Name:  "receipt",
+				Event: "document",
+				Regex: `^(?P<time>\S+ \S+) \[\w+\] KKT (?P<kkt_id>\d+) receipt FD (?P<document_number>\S+) shift (?P<shift_number>\d+) sum (?P<amount>[\d.,]+)`,
+			},
+			{
+				Name:  "error",
+				Event: "error",
+				Regex: `^(?P<time>\S+ \S+) \[(?P<severity>\w+)\] KKT (?P<kkt_id>\d+) code (?P<error_code>\S+) (?P<error_type>\w+): (?P<message>.*)$`,
+			},
+		},
+	}
+}
+
+func TestAggregator_FiscalDrive(t *testing.T) {
+	agg := newAggregator()
+	parse := func(line string) logEvent {
+		ev, ok, err := jsonLineParser{}.Parse([]byte(line))
+		if err != nil || !ok {
+			t.Fatalf("Expected line to parse, ok=%v err=%v", ok, err)
+		}
+		return ev
+	}
+
+	agg.Apply(parse(`{"kkt_id":"kkt-001","event":"status","status":{"fiscal_drive":{"number":"9960440300000001","expiry_date":"2025-03-01T00:00:00+03:00","documents_max":250000,"documents_used":50000}}}`))
+	agg.Apply(parse(`{"kkt_id":"kkt-001","event":"status","status":{"fiscal_drive":{"documents_used":100000}}}`))
+
+	m := agg.Flush(time.Now())[0]
+	fd := m.FiscalDrive
+	if fd.Number != "9960440300000001" || fd.ExpiryDate.IsZero() || fd.DocumentsMax != 250000 {
+		t.Errorf("Expected first update to be kept, got %+v", fd)
+	}
+	if fd.DocumentsUsed != 100000 || m.FDMemoryUsage != 40 {
+		t.Errorf("Expected 100000 documents used and 40%% memory usage, got %d and %v", fd.DocumentsUsed, m.FDMemoryUsage)
+	}
+
+	// A new drive replaces all fields of the old one
+	agg.Apply(parse(`{"kkt_id":"kkt-001","event":"status","status":{"fiscal_drive":{"number":"9960440300000002"}}}`))
+	fd = agg.Flush(time.Now())[0].FiscalDrive
+	if fd.Number != "9960440300000002" || !fd.ExpiryDate.IsZero() || fd.DocumentsUsed != 0 {
+		t.Errorf("Expected replaced drive to reset fields, got %+v", fd)
+	}
+}
+
+func TestAggregator_UnsentDocuments(t *testing.T) {
+	agg := newAggregator()
+	apply := func(line string) domain.Metrics {
+		ev, ok, err := jsonLineParser{}.Parse([]byte(line))
+		if err != nil || !ok {
+			t.Fatalf("Expected line to parse, ok=%v err=%v", ok, err)
+		}
+		agg.Apply(ev)
+		return agg.Flush(time.Now())[0]
+	}
+
+	apply(`{"time":"2024-05-01T09:00:00+03:00","kkt_id":"kkt-001","event":"ofd","ofd":{"document_id":"101","status":2}}`)
+	m := apply(`{"time":"2024-05-01T09:05:00+03:00","kkt_id":"kkt-001","event":"ofd","ofd":{"document_id":"102","status":2}}`)
+	if m.UnsentDocuments != 2 || m.OFDSyncStatus != domain.OFDSyncStatusPending {
+		t.Errorf("Expected 2 pending documents, got %d with status %d", m.UnsentDocuments, m.OFDSyncStatus)
+	}
+
+	// A retry keeps the time of the first attempt
+	m = apply(`{"time":"2024-05-01T09:10:00+03:00","kkt_id":"kkt-001","event":"ofd","ofd":{"document_id":"101","status":3,"retry_count":1}}`)
+	want := time.Date(2024, 5, 1, 6, 0, 0, 0, time.UTC)
+	if !m.OldestUnsentTime.Equal(want) || m.OFDSyncStatus != domain.OFDSyncStatusError {
+		t.Errorf("Expected oldest unsent %v with error status, got %v with status %d", want, m.OldestUnsentTime, m.OFDSyncStatus)
+	}
+
+	m = apply(`{"time":"2024-05-01T09:11:00+03:00","kkt_id":"kkt-001","event":"ofd","ofd":{"document_id":"101","acknowledged_at":"2024-05-01T09:11:00+03:00","status":1}}`)
+	if m.UnsentDocuments != 1 || !m.OldestUnsentTime.Equal(want.Add(5*time.Minute)) {
+		t.Errorf("Expected 1 unsent document since 06:05 UTC, got %d since %v", m.UnsentDocuments, m.OldestUnsentTime)
+	}
+	// Latency is measured from the first attempt
+	if len(m.OFDAckLatencies) != 1 || m.OFDAckLatencies[0] != 660 {
+		t.Errorf("Expected ack latency [660], got %v", m.OFDAckLatencies)
+	}
+
+	m = apply(`{"time":"2024-05-01T09:12:00+03:00","kkt_id":"kkt-001","event":"ofd","ofd":{"document_id":"102","status":1}}`)
+	if m.UnsentDocuments != 0 || !m.OldestUnsentTime.IsZero() || m.OFDSyncStatus != domain.OFDSyncStatusSynced {
+		t.Errorf("Expected all documents acknowledged, got %d since %v", m.UnsentDocuments, m.OldestUnsentTime)
+	}
+	// Without acknowledged_at the latency is unknown
+	if len(m.OFDAckLatencies) != 0 {
+		t.Errorf("Expected no ack latencies, got %v", m.OFDAckLatencies)
+	}
+}
+
+func TestAggregator_DocumentsPerHour(t *testing.T) {
+	agg := newAggregator()
+	start := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+
+	agg.Apply(logEvent{KKTID: "kkt-001", Document: &domain.FiscalDocument{DateTime: start}})
+	agg.Apply(logEvent{KKTID: "kkt-002", Document: &domain.FiscalDocument{DateTime: start.Add(30 * time.Minute)}})
+	if got := agg.Flush(start.Add(31 * time.Minute)); len(got) != 2 {
+		t.Fatalf("Expected 2 updated devices, got %d", len(got))
+	}
+
+	// kkt-001 stays quiet and its document ages out of the last hour
+	got := agg.Flush(start.Add(61 * time.Minute))
+	if len(got) != 1 || got[0].KKTID != "kkt-001" || got[0].DocumentsPerHour != 0 {
+		t.Fatalf("Expected kkt-001 with 0 documents per hour, got %+v", got)
+	}
+
+	if got := agg.Flush(start.Add(62 * time.Minute)); len(got) != 0 {
+		t.Errorf("Expected no updates without changes, got %+v", got)
+	}
+}
+
+func TestAggregator_IdleDevice(t *testing.T) {
+	agg := newAggregator()
+	start := time.Date(2024, 5, 1, 22, 0, 0, 0, time.UTC)
+
+	agg.Apply(logEvent{KKTID: "kkt-001", Time: start, Document: &domain.FiscalDocument{DateTime: start, DocumentNumber: 42}})
+	agg.Flush(start)
+
+	// The document ages out of the rate window once
+	m := agg.Flush(start.Add(2 * time.Hour))
+	if len(m) != 1 || m[0].DocumentsPerHour != 0 {
+		t.Fatalf("Expected the aged out rate to be reported, got %+v", m)
+	}
+	if !m[0].LastEventTime.Equal(start) {
+		t.Errorf("Expected the time of the last event, got %v", m[0].LastEventTime)
+	}
+
+	// Afterwards the silent device is left to expire in the exporter
+	if m := agg.Flush(start.Add(10 * time.Hour)); len(m) != 0 {
+		t.Errorf("Expected the idle device not to be reported, got %+v", m)
+	}
+
+	// It is reported again with its state when it logs
+	agg.Apply(logEvent{KKTID: "kkt-001", Document: &domain.FiscalDocument{DateTime: start.Add(11 * time.Hour), DocumentNumber: 43}})
+	m = agg.Flush(start.Add(11 * time.Hour))
+	if len(m) != 1 || m[0].DocumentsTotal != 2 || m[0].LastDocumentNumber != 43 || m[0].DocumentsPerHour != 1 {
+		t.Errorf("Expected the device with its documents, got %+v", m)
+	}
+}
+
+func TestAggregator_UnsentDocumentsBounded(t *testing.T) {
+	agg := newAggregator()
+	start := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+
+	for i := 0; i <= maxUnsentDocuments; i++ {
+		agg.Apply(logEvent{KKTID: "kkt-001", OFD: &domain.OFDTransaction{
+			DocumentID: strconv.Itoa(i), SentAt: start.Add(time.Duration(i) * time.Second), Status: domain.OFDSyncStatusPending}})
+	}
+	d := agg.devices["kkt-001"]
+	if d.unsent.Len() != maxUnsentDocuments {
+		t.Fatalf("Expected %d tracked documents, got %d", maxUnsentDocuments, d.unsent.Len())
+	}
+	if _, ok := d.unsent.SentAt("0"); ok {
+		t.Error("Expected the oldest document to be dropped")
+	}
+
+	// The device reports nothing left to send
+	zero := int64(0)
+	agg.Apply(logEvent{KKTID: "kkt-001", Status: &statusEvent{UnsentDocuments: &zero}})
+	if d.unsent.Len() != 0 {
+		t.Errorf("Expected no tracked documents, got %d", d.unsent.Len())
+	}
+}
+
+func TestUnsentQueue(t *testing.T) {
+	q := newUnsentQueue()
+	start := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+	for _, i := range []int{3, 1, 4, 2} {
+		q.Add(strconv.Itoa(i), start.Add(time.Duration(i)*time.Minute))
+	}
+
+	if got := q.Oldest(); !got.Equal(start.Add(time.Minute)) {
+		t.Fatalf("Expected the oldest at 09:01, got %v", got)
+	}
+	q.Remove("1")
+	if got := q.Oldest(); !got.Equal(start.Add(2 * time.Minute)) {
+		t.Errorf("Expected the oldest at 09:02 after acknowledgement, got %v", got)
+	}
+	q.DropOldest()
+	if _, ok := q.SentAt("2"); ok || q.Len() != 2 {
+		t.Errorf("Expected 2 documents without 2, got %d", q.Len())
+	}
+	if got := q.Oldest(); !got.Equal(start.Add(3 * time.Minute)) {
+		t.Errorf("Expected the oldest at 09:03, got %v", got)
+	}
+
+	q.Clear()
+	if q.Len() != 0 || !q.Oldest().IsZero() {
+		t.Errorf("Expected an empty queue, got %d documents", q.Len())
+	}
+}
+
+func TestTextLineParser(t *testing.T) {
+	parser, err := newTextLineParser(textTestConfig(""))
+	if err != nil {
+		t.Fatalf("newTextLineParser failed: %v", err)
+	}
+
+	ev, ok, err := parser.Parse([]byte("2024-05-01 09:05:00 [INFO] KKT 0001234567 receipt FD 101 shift 12 sum 1250,50"))
+	if err != nil || !ok {
+		t.Fatalf("Expected receipt to parse, ok=%v err=%v", ok, err)
+	}
+	if ev.Document == nil || ev.Document.DocumentNumber != 101 || ev.Document.Amount != 1250.5 {
+		t.Errorf("Unexpected document: %+v", ev.Document)
+	}
+	if ev.Document.Type != domain.DocumentTypeReceipt {
+		t.Errorf("Expected receipt document type, got %d", ev.Document.Type)
+	}
+
+	ev, ok, err = parser.Parse([]byte("2024-05-01 09:08:00 [CRIT] KKT 0001234567 code 0xD1 fn: fiscal drive exhausted"))
+	if err != nil || !ok {
+		t.Fatalf("Expected error to parse, ok=%v err=%v", ok, err)
+	}
+	if ev.Error.ErrorType != domain.ErrorTypeFiscalDrive || ev.Error.Severity != domain.ErrorSeverityCritical {
+		t.Errorf("Unexpected error mapping: %+v", ev.Error)
+	}
+	if ev.Error.ErrorCode != "0xD1" || ev.Error.Message != "fiscal drive exhausted" {
+		t.Errorf("Unexpected error fields: %+v", ev.Error)
+	}
+
+	if _, ok, err := parser.Parse([]byte("2024-05-01 09:06:00 [DEBUG] printer heartbeat ok")); ok || err != nil {
+		t.Errorf("Expected unmatched line to be ignored, ok=%v err=%v", ok, err)
+	}
+
+	if _, _, err := parser.Parse([]byte("2024-05-01 09:09:00 [INFO] KKT 0001234567 receipt FD abc shift 12 sum 10")); err == nil {
+		t.Error("Expected error for non-numeric document number")
+	}
+}
+
+func TestFileLogCollector_TextFormat(t *testing.T) {
+	dir := t.TempDir()
+	copyFixture(t, "atol.txt", dir)
+
+	c := NewFileLogCollector(textTestConfig(dir), logger.New("error", "json"))
+	parser, err := newLineParser(c.cfg)
+	if err != nil {
+		t.Fatalf("newLineParser failed: %v", err)
+	}
+	c.parser = parser
+
+	if err := c.collectOnce(context.Background()); err != nil {
+		t.Fatalf("collectOnce failed: %v", err)
+	}
+
+	m, ok := drainMetrics(c)["0001234567"]
+	if !ok {
+		t.Fatal("Expected metrics for KKT 0001234567")
+	}
+	if m.DocumentsTotal != 2 {
+		t.Errorf("Expected 2 documents, got %d", m.DocumentsTotal)
+	}
+	if m.ShiftStatus != domain.ShiftStatusOpen {
+		t.Errorf("Expected open shift, got %d", m.ShiftStatus)
+	}
+	if m.ErrorsByType[domain.ErrorTypeOFD] != 1 || m.ErrorsByType[domain.ErrorTypeFiscalDrive] != 1 {
+		t.Errorf("Unexpected errors by type: %v", m.ErrorsByType)
+	}
+	if m.Status != domain.KKTStatusError {
+		t.Errorf("Expected error status, got %d", m.Status)
+	}
+
+	if errs := drainErrors(c); len(errs) != 2 {
+		t.Errorf("Expected 2 KKT errors, got %d", len(errs))
+	}
+}
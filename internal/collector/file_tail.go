@@ -0,0 +1,188 @@
+package collector
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// fingerprintSize is the number of leading bytes that identify the content
+// of a log file, to detect truncation the file size does not reveal
+const fingerprintSize = 256
+
+// fileTail tracks the read position in an open log file
+type fileTail struct {
+	path   string
+	file   *os.File
+	info   os.FileInfo
+	offset int64
+	// fingerprint holds the first bytes already read from the file
+	fingerprint []byte
+}
+
+// startsWith reports whether the file still begins with fingerprint. A file
+// truncated in place and written again past the old offset does not.
+func (t *fileTail) startsWith(fingerprint []byte) bool {
+	if len(fingerprint) == 0 {
+		return true
+	}
+	buf := make([]byte, len(fingerprint))
+	if _, err := t.file.ReadAt(buf, 0); err != nil {
+		return false
+	}
+	return bytes.Equal(buf, fingerprint)
+}
+
+// updateFingerprint records the first bytes read until it holds
+// fingerprintSize of them
+func (t *fileTail) updateFingerprint() {
+	size := min(t.offset, fingerprintSize)
+	if int64(len(t.fingerprint)) >= size {
+		return
+	}
+	buf := make([]byte, size)
+	if _, err := t.file.ReadAt(buf, 0); err != nil {
+		return
+	}
+	t.fingerprint = buf
+}
+
+// fileID identifies a file independently of its path
+type fileID struct {
+	Dev uint64 `json:"dev"`
+	Ino uint64 `json:"ino"`
+}
+
+// fileOffset is the persisted read position of a log file
+type fileOffset struct {
+	FileID      fileID `json:"file_id"`
+	Offset      int64  `json:"offset"`
+	Fingerprint []byte `json:"fingerprint,omitempty"`
+}
+
+// offsetState is the content of the offsets state file
+type offsetState struct {
+	Files map[string]fileOffset `json:"files"`
+}
+
+// loadOffsets reads persisted offsets; a missing file yields an empty state
+func loadOffsets(path string) (map[string]fileOffset, error) {
+	offsets := make(map[string]fileOffset)
+	if path == "" {
+		return offsets, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return offsets, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read offsets state: %w", err)
+	}
+
+	var state offsetState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse offsets state: %w", err)
+	}
+	for p, off := range state.Files {
+		offsets[p] = off
+	}
+
+	return offsets, nil
+}
+
+// saveOffsets atomically writes offsets to the state file
+func saveOffsets(path string, offsets map[string]fileOffset) error {
+	data, err := json.MarshalIndent(offsetState{Files: offsets}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode offsets state: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write offsets state: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to replace offsets state: %w", err)
+	}
+
+	return nil
+}
+
+// findFileByID looks for a file with the given identity in dir
+func findFileByID(dir string, id fileID) (string, bool) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", false
+	}
+
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if got, ok := getFileID(info); ok && got == id {
+			return filepath.Join(dir, entry.Name()), true
+		}
+	}
+
+	return "", false
+}
+
+// compressedSuffixes are extensions of rotated files that cannot be tailed
+var compressedSuffixes = []string{".gz", ".bz2", ".xz", ".zst", ".zip"}
+
+// findRotatedCopy returns the most recent copy made by logrotate copytruncate
+// that is at least minSize bytes long. Both numbered (app.log.1) and dated
+// (app.log-20240501) names are recognized.
+func findRotatedCopy(path string, minSize int64) (string, bool) {
+	var candidates []string
+	for _, pattern := range []string{path + ".*", path + "-*"} {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			continue
+		}
+		candidates = append(candidates, matches...)
+	}
+
+	var (
+		best     string
+		bestInfo os.FileInfo
+	)
+	for _, candidate := range candidates {
+		if hasCompressedSuffix(candidate) {
+			continue
+		}
+		info, err := os.Stat(candidate)
+		if err != nil || !info.Mode().IsRegular() || info.Size() < minSize {
+			continue
+		}
+		if bestInfo == nil || info.ModTime().After(bestInfo.ModTime()) {
+			best, bestInfo = candidate, info
+		}
+	}
+
+	return best, bestInfo != nil
+}
+
+// hasCompressedSuffix reports whether the path names a compressed file
+func hasCompressedSuffix(path string) bool {
+	for _, suffix := range compressedSuffixes {
+		if strings.HasSuffix(path, suffix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,130 @@
+package collector
+
+import (
+	"sync"
+	"time"
+)
+
+// HealthStatus is the health state of a collector
+type HealthStatus string
+
+const (
+	// HealthStarting means no collection cycle has completed yet
+	HealthStarting HealthStatus = "starting"
+	// HealthHealthy means the last cycle succeeded recently
+	HealthHealthy HealthStatus = "healthy"
+	// HealthUnhealthy means several cycles in a row failed or no cycle
+	// succeeded recently
+	HealthUnhealthy HealthStatus = "unhealthy"
+)
+
+// staleCycles is the number of poll intervals without a successful cycle,
+// and the number of failed cycles in a row, after which a collector is
+// unhealthy. A single transient failure does not make it unhealthy.
+const staleCycles = 3
+
+// Health is the health of a collector
+type Health struct {
+	Collector           string       `json:"collector"`
+	Status              HealthStatus `json:"status"`
+	LastSuccess         time.Time    `json:"last_success"`
+	LastError           string       `json:"last_error,omitempty"`
+	LastErrorTime       time.Time    `json:"last_error_time"`
+	ConsecutiveFailures int          `json:"consecutive_failures"`
+}
+
+// healthTracker records collection cycles of a collector and exports them
+// as self-monitoring metrics
+type healthTracker struct {
+	name     string
+	interval time.Duration
+
+	mu            sync.Mutex
+	started       time.Time
+	lastSuccess   time.Time
+	lastError     string
+	lastErrorTime time.Time
+	failures      int
+	now           func() time.Time
+}
+
+// newHealthTracker creates a tracker for a collector polling at interval
+func newHealthTracker(name string, interval time.Duration) *healthTracker {
+	return &healthTracker{
+		name:     name,
+		interval: interval,
+		now:      time.Now,
+	}
+}
+
+// Start marks the collector as started
+func (h *healthTracker) Start() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.started = h.now()
+}
+
+// ObserveCycle records the outcome of a collection cycle started at start
+func (h *healthTracker) ObserveCycle(start time.Time, err error) {
+	now := h.now()
+	collectorCycleDuration.WithLabelValues(h.name).Observe(now.Sub(start).Seconds())
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err != nil {
+		h.failures++
+		h.lastError = err.Error()
+		h.lastErrorTime = now
+		collectorErrorsTotal.WithLabelValues(h.name).Inc()
+		collectorUp.WithLabelValues(h.name).Set(0)
+		return
+	}
+
+	h.failures = 0
+	h.lastSuccess = now
+	collectorUp.WithLabelValues(h.name).Set(1)
+	collectorLastSuccess.WithLabelValues(h.name).Set(float64(now.Unix()))
+}
+
+// Dropped counts a value dropped because the channel was full
+func (h *healthTracker) Dropped(channel string) {
+	collectorDroppedTotal.WithLabelValues(h.name, channel).Inc()
+}
+
+// ChannelFill records the fill level of an output channel
+func (h *healthTracker) ChannelFill(channel string, length, capacity int) {
+	if capacity == 0 {
+		return
+	}
+	collectorChannelFill.WithLabelValues(h.name, channel).Set(float64(length) / float64(capacity))
+}
+
+// Health returns the current health state
+func (h *healthTracker) Health() Health {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	health := Health{
+		Collector:           h.name,
+		LastSuccess:         h.lastSuccess,
+		LastError:           h.lastError,
+		LastErrorTime:       h.lastErrorTime,
+		ConsecutiveFailures: h.failures,
+	}
+
+	deadline := h.now().Add(-staleCycles * h.interval)
+	switch {
+	case h.failures >= staleCycles:
+		health.Status = HealthUnhealthy
+	case !h.lastSuccess.IsZero() && h.lastSuccess.After(deadline):
+		health.Status = HealthHealthy
+	case h.lastSuccess.IsZero() && h.started.After(deadline):
+		health.Status = HealthStarting
+	default:
+		health.Status = HealthUnhealthy
+	}
+
+	return health
+}
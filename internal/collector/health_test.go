@@ -0,0 +1,51 @@
+package collector
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestHealthTracker(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	h := newHealthTracker("test-health", 10*time.Second)
+	h.now = func() time.Time { return now }
+
+	if got := h.Health().Status; got != HealthUnhealthy {
+		t.Errorf("Expected unhealthy before start, got %s", got)
+	}
+
+	h.Start()
+	if got := h.Health().Status; got != HealthStarting {
+		t.Errorf("Expected starting, got %s", got)
+	}
+
+	h.ObserveCycle(now, nil)
+	if got := h.Health().Status; got != HealthHealthy {
+		t.Errorf("Expected healthy after a successful cycle, got %s", got)
+	}
+
+	h.ObserveCycle(now, errors.New("connection refused"))
+	health := h.Health()
+	if health.Status != HealthHealthy || health.ConsecutiveFailures != 1 || health.LastError != "connection refused" {
+		t.Errorf("Expected healthy after a transient failure: %+v", health)
+	}
+
+	for i := 1; i < staleCycles; i++ {
+		h.ObserveCycle(now, errors.New("connection refused"))
+	}
+	if health := h.Health(); health.Status != HealthUnhealthy || health.ConsecutiveFailures != staleCycles {
+		t.Errorf("Unexpected health after %d failed cycles: %+v", staleCycles, health)
+	}
+
+	h.ObserveCycle(now, nil)
+	if got := h.Health().Status; got != HealthHealthy {
+		t.Errorf("Expected healthy after recovery, got %s", got)
+	}
+
+	// No cycle completes for longer than staleCycles intervals
+	now = now.Add(staleCycles*10*time.Second + time.Second)
+	if got := h.Health().Status; got != HealthUnhealthy {
+		t.Errorf("Expected unhealthy when stale, got %s", got)
+	}
+}
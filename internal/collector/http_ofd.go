@@ -3,6 +3,8 @@ package collector
 import (
 	"context"
 	"fmt"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/config"
@@ -10,30 +12,91 @@ import (
 	"github.com/ranas-mukminov/kkt-54fz-monitoring/pkg/logger"
 )
 
+func init() {
+	Register("http_ofd", newHTTPOFDFromConfig)
+}
+
 // HTTPOFDCollector collects data from OFD HTTP API
 type HTTPOFDCollector struct {
-	cfg          config.HTTPOFDConfig
-	log          *logger.Logger
-	metricsChan  chan domain.Metrics
-	errorsChan   chan domain.KKTError
-	stopChan     chan struct{}
+	cfg         config.HTTPOFDConfig
+	log         *logger.Logger
+	metricsChan chan domain.Metrics
+	errorsChan  chan domain.KKTError
+	stopChan    chan struct{}
+	health      *healthTracker
+	adapter     OFDAdapter
+	// docSamples are the document samples of the last hour by KKT ID
+	docSamples map[string][]documentSample
+	// unsentSince is when unsent documents were first seen on a KKT whose
+	// OFD does not report the time of the oldest one
+	unsentSince map[string]time.Time
+	// shifts holds the open shifts of KKTs whose OFD does not report the
+	// shift opening time
+	shifts map[string]shiftSample
+
+	mu      sync.RWMutex
+	devices map[string]domain.KKTDevice
+}
+
+// documentSample is the last document number seen at a point in time and
+// the number of documents issued since the first sample
+type documentSample struct {
+	number int
+	at     time.Time
+	total  int64
 }
 
+// shiftSample is an open shift and when it was first seen open
+type shiftSample struct {
+	number   int
+	openedAt time.Time
+}
+
+var (
+	_ DeviceLister   = (*HTTPOFDCollector)(nil)
+	_ HealthReporter = (*HTTPOFDCollector)(nil)
+)
+
 // NewHTTPOFDCollector creates a new HTTP OFD collector
 func NewHTTPOFDCollector(cfg config.HTTPOFDConfig, log *logger.Logger) *HTTPOFDCollector {
+	if cfg.Name == "" {
+		cfg.Name = "http_ofd"
+	}
+
 	return &HTTPOFDCollector{
 		cfg:         cfg,
-		log:         log,
+		log:         log.With("collector", cfg.Name),
 		metricsChan: make(chan domain.Metrics, 100),
 		errorsChan:  make(chan domain.KKTError, 100),
 		stopChan:    make(chan struct{}),
+		health:      newHealthTracker(cfg.Name, cfg.PollInterval),
+		docSamples:  make(map[string][]documentSample),
+		unsentSince: make(map[string]time.Time),
+		shifts:      make(map[string]shiftSample),
+		devices:     make(map[string]domain.KKTDevice),
 	}
 }
 
+// newHTTPOFDFromConfig is the factory of HTTP OFD collectors
+func newHTTPOFDFromConfig(cfg config.CollectorConfig, log *logger.Logger) (Collector, error) {
+	var ofd config.HTTPOFDConfig
+	if err := cfg.DecodeHTTPOFD(&ofd); err != nil {
+		return nil, err
+	}
+	return NewHTTPOFDCollector(ofd, log), nil
+}
+
 // Start begins collecting data
 func (c *HTTPOFDCollector) Start(ctx context.Context) error {
-	c.log.Info("Starting HTTP OFD collector", "url", c.cfg.URL)
+	adapter, err := newOFDAdapter(c.cfg)
+	if err != nil {
+		return err
+	}
+	c.adapter = adapter
 
+	c.log.Info("Starting HTTP OFD collector", "provider", adapter.Name(), "url", c.cfg.URL)
+
+	c.health.Start()
 	go c.collect(ctx)
 
 	return nil
@@ -46,9 +109,14 @@ func (c *HTTPOFDCollector) Stop() error {
 	return nil
 }
 
-// Name returns the collector name
+// Name returns the collector instance name
 func (c *HTTPOFDCollector) Name() string {
-	return "http_ofd"
+	return c.cfg.Name
+}
+
+// Health returns the collector health
+func (c *HTTPOFDCollector) Health() Health {
+	return c.health.Health()
 }
 
 // Metrics returns the metrics channel
@@ -61,6 +129,28 @@ func (c *HTTPOFDCollector) Errors() <-chan domain.KKTError {
 	return c.errorsChan
 }
 
+// Devices returns the devices seen in the last collection cycle
+func (c *HTTPOFDCollector) Devices() []domain.KKTDevice {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	devices := make([]domain.KKTDevice, 0, len(c.devices))
+	for _, d := range c.devices {
+		devices = append(devices, d)
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].ID < devices[j].ID })
+	return devices
+}
+
+// Device returns a device seen in the last collection cycle
+func (c *HTTPOFDCollector) Device(id string) (domain.KKTDevice, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	d, ok := c.devices[id]
+	return d, ok
+}
+
 // collect is the main collection loop
 func (c *HTTPOFDCollector) collect(ctx context.Context) {
 	ticker := time.NewTicker(c.cfg.PollInterval)
@@ -73,39 +163,204 @@ func (c *HTTPOFDCollector) collect(ctx context.Context) {
 		case <-c.stopChan:
 			return
 		case <-ticker.C:
-			if err := c.collectOnce(); err != nil {
+			start := time.Now()
+			err := c.collectOnce(ctx)
+			c.observeCycle(start, err)
+			if err != nil {
 				c.log.Error("Failed to collect from HTTP OFD", "error", err)
 			}
 		}
 	}
 }
 
+// observeCycle records the cycle outcome and the channel fill levels
+func (c *HTTPOFDCollector) observeCycle(start time.Time, err error) {
+	c.health.ObserveCycle(start, err)
+	c.health.ChannelFill("metrics", len(c.metricsChan), cap(c.metricsChan))
+	c.health.ChannelFill("errors", len(c.errorsChan), cap(c.errorsChan))
+}
+
 // collectOnce performs one collection cycle
-func (c *HTTPOFDCollector) collectOnce() error {
-	// TODO: Implement actual HTTP OFD API calls
-	// This is a stub implementation
-	c.log.Debug("Collecting from HTTP OFD", "url", c.cfg.URL)
+func (c *HTTPOFDCollector) collectOnce(ctx context.Context) error {
+	c.log.Debug("Collecting from HTTP OFD", "provider", c.adapter.Name(), "url", c.cfg.URL)
 
-	// Stub: generate sample metrics
-	metrics := domain.Metrics{
-		KKTID:            "stub-kkt-002",
-		Timestamp:        time.Now(),
-		Status:           domain.KKTStatusRunning,
-		DocumentsTotal:   2500,
-		ErrorsByType:     make(map[domain.ErrorType]int64),
-		OFDSyncStatus:    domain.OFDSyncStatusSynced,
-		ShiftStatus:      domain.ShiftStatusOpen,
-		LastDocumentTime: time.Now().Add(-2 * time.Minute),
-		FDMemoryUsage:    62.3,
-		DocumentsPerHour: 75.0,
-		AverageSyncTime:  1.8,
+	states, err := c.adapter.FetchKKTs(ctx)
+	if err != nil && len(states) == 0 {
+		return fmt.Errorf("failed to fetch KKTs: %w", err)
+	}
+	if err != nil {
+		c.log.Error("Failed to collect some KKTs from HTTP OFD", "provider", c.adapter.Name(), "error", err)
 	}
 
-	select {
-	case c.metricsChan <- metrics:
-	default:
-		return fmt.Errorf("metrics channel full")
+	now := time.Now()
+	devices := make(map[string]domain.KKTDevice, len(states))
+	metrics := make([]domain.Metrics, 0, len(states))
+	for i := range states {
+		device, m := c.convert(&states[i], now)
+		devices[device.ID] = device
+		metrics = append(metrics, m)
+	}
+
+	// KKTs missing from a partial response may still exist
+	if err == nil {
+		c.forget(devices)
+	}
+
+	// Devices are published first, so that a consumer of the metrics
+	// finds the details of the same cycle
+	c.mu.Lock()
+	c.devices = devices
+	c.mu.Unlock()
+
+	for _, m := range metrics {
+		select {
+		case c.metricsChan <- m:
+		default:
+			c.health.Dropped("metrics")
+			c.log.Warn("Metrics channel full, dropping metrics", "kkt_id", m.KKTID)
+		}
 	}
 
 	return nil
 }
+
+// forget drops the tracking state of KKTs no longer reported by the OFD
+func (c *HTTPOFDCollector) forget(devices map[string]domain.KKTDevice) {
+	for id := range c.docSamples {
+		if _, ok := devices[id]; !ok {
+			delete(c.docSamples, id)
+		}
+	}
+	for id := range c.unsentSince {
+		if _, ok := devices[id]; !ok {
+			delete(c.unsentSince, id)
+		}
+	}
+	for id := range c.shifts {
+		if _, ok := devices[id]; !ok {
+			delete(c.shifts, id)
+		}
+	}
+}
+
+// convert maps an OFD KKT state to device and metrics
+func (c *HTTPOFDCollector) convert(state *OFDKKTState, now time.Time) (domain.KKTDevice, domain.Metrics) {
+	fiscalDrive := state.FiscalDrive
+	if fiscalDrive.MemoryUsage == 0 {
+		fiscalDrive.MemoryUsage = memoryUsage(fiscalDrive)
+	}
+
+	syncStatus := state.OFDSyncStatus
+	if syncStatus == domain.OFDSyncStatusUnknown {
+		syncStatus = domain.OFDSyncStatusSynced
+		if state.UnsentDocuments > 0 {
+			syncStatus = domain.OFDSyncStatusPending
+		}
+	}
+
+	device := domain.KKTDevice{
+		ID:              state.ID,
+		FactoryNumber:   state.FactoryNumber,
+		RegNumber:       state.RegNumber,
+		FiscalDriveNum:  fiscalDrive.Number,
+		Status:          state.Status,
+		LastSeen:        state.LastSeen,
+		ShiftStatus:     state.ShiftStatus,
+		OFDSyncStatus:   syncStatus,
+		FiscalDriveInfo: fiscalDrive,
+	}
+
+	metrics := domain.Metrics{
+		KKTID:              state.ID,
+		Collector:          c.Name(),
+		Timestamp:          now,
+		Status:             state.Status,
+		ErrorsByType:       make(map[domain.ErrorType]int64),
+		OFDSyncStatus:      syncStatus,
+		ShiftStatus:        state.ShiftStatus,
+		LastDocumentTime:   state.LastDocumentTime,
+		FDMemoryUsage:      fiscalDrive.MemoryUsage,
+		UnsentDocuments:    state.UnsentDocuments,
+		LastDocumentNumber: int64(state.LastDocumentNumber),
+		FiscalDrive:        fiscalDrive,
+		OldestUnsentTime:   c.oldestUnsent(state, now),
+		ShiftNumber:        state.ShiftNumber,
+		ShiftOpenedAt:      c.shiftOpened(state, now),
+		OFD:                c.adapter.Name(),
+		LastEventTime:      state.LastSeen,
+	}
+	if metrics.LastEventTime.IsZero() {
+		metrics.LastEventTime = state.LastDocumentTime
+	}
+	metrics.DocumentsTotal, metrics.DocumentsPerHour = c.trackDocuments(state.ID, state.LastDocumentNumber, now)
+
+	return device, metrics
+}
+
+// oldestUnsent returns the time of the oldest unsent document. When the OFD
+// does not report it, the time unsent documents were first seen is used,
+// which underestimates the age after a restart of the monitor.
+func (c *HTTPOFDCollector) oldestUnsent(state *OFDKKTState, now time.Time) time.Time {
+	if state.UnsentDocuments == 0 {
+		delete(c.unsentSince, state.ID)
+		return time.Time{}
+	}
+	if !state.OldestUnsentTime.IsZero() {
+		return state.OldestUnsentTime
+	}
+
+	since, ok := c.unsentSince[state.ID]
+	if !ok {
+		since = now
+		c.unsentSince[state.ID] = since
+	}
+	return since
+}
+
+// shiftOpened returns when the open shift was opened. When the OFD does not
+// report it, the time the shift was first seen open is used.
+func (c *HTTPOFDCollector) shiftOpened(state *OFDKKTState, now time.Time) time.Time {
+	if state.ShiftStatus != domain.ShiftStatusOpen {
+		delete(c.shifts, state.ID)
+		return time.Time{}
+	}
+	if !state.ShiftOpenedAt.IsZero() {
+		return state.ShiftOpenedAt
+	}
+
+	shift, ok := c.shifts[state.ID]
+	if !ok || shift.number != state.ShiftNumber {
+		shift = shiftSample{number: state.ShiftNumber, openedAt: now}
+		c.shifts[state.ID] = shift
+	}
+	return shift.openedAt
+}
+
+// trackDocuments counts documents issued since the first sample and in
+// the last hour, the window of the file log collector. The samples of the
+// hour are kept, with the last one before it as the base of the window.
+func (c *HTTPOFDCollector) trackDocuments(kktID string, number int, now time.Time) (int64, float64) {
+	samples := c.docSamples[kktID]
+	sample := documentSample{number: number, at: now}
+	if n := len(samples); n > 0 {
+		prev := samples[n-1]
+		sample.total = prev.total
+		// A new fiscal drive restarts numbering
+		if number < prev.number {
+			sample.total += int64(number)
+		} else {
+			sample.total += int64(number - prev.number)
+		}
+	}
+	samples = append(samples, sample)
+
+	cutoff := now.Add(-time.Hour)
+	first := 0
+	for first+1 < len(samples) && !samples[first+1].at.After(cutoff) {
+		first++
+	}
+	samples = append(samples[:0], samples[first:]...)
+	c.docSamples[kktID] = samples
+
+	return sample.total, float64(sample.total - samples[0].total)
+}
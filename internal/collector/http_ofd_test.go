@@ -0,0 +1,539 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/config"
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/domain"
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/pkg/logger"
+)
+
+// jsonObject is a shorthand for JSON fixtures
+type jsonObject = map[string]interface{}
+
+// newFakeOFDServer starts a stand-in OFD API serving fixed JSON bodies by
+// path (including the query string when present). Requests failing check
+// get 401.
+func newFakeOFDServer(t *testing.T, routes map[string]interface{}, check func(r *http.Request) bool) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if check != nil && !check(r) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		key := r.URL.Path
+		if r.URL.RawQuery != "" {
+			key += "?" + r.URL.RawQuery
+		}
+		body, ok := routes[key]
+		if !ok {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(body); err != nil {
+			t.Errorf("Failed to encode response: %v", err)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+// genericRoutes serves two KKTs in the default generic layout, the second
+// of which fails to report its fiscal drive
+var genericRoutes = map[string]interface{}{
+	"/api/v1/kkts": jsonObject{"kkts": []jsonObject{
+		{"id": "0001234567890123", "factory_number": "00106701234567", "reg_number": "0001234567890123", "fn_number": "9999078900001234"},
+		{"id": "0009876543210987", "factory_number": "00106709876543", "reg_number": "0009876543210987"},
+	}},
+	"/api/v1/kkts/0001234567890123/status": jsonObject{
+		"status": "online", "last_seen": "2024-05-01T09:00:00Z", "shift_open": true, "shift_number": 42,
+	},
+	"/api/v1/kkts/0001234567890123/documents/last": jsonObject{"document_number": 1500, "date_time": "2024-05-01T09:00:00Z"},
+	"/api/v1/kkts/0001234567890123/unsent":         jsonObject{"count": 3},
+	"/api/v1/kkts/0001234567890123/fn": jsonObject{
+		"expiry_date": "2025-06-01T00:00:00Z", "documents_max": 250000, "documents_used": 62500,
+	},
+	"/api/v1/kkts/0009876543210987/status":         jsonObject{"status": "offline"},
+	"/api/v1/kkts/0009876543210987/documents/last": jsonObject{"document_number": 10},
+	"/api/v1/kkts/0009876543210987/unsent":         jsonObject{"count": 0},
+}
+
+// bearerAuth accepts requests with the test API key
+func bearerAuth(r *http.Request) bool {
+	return r.Header.Get("Authorization") == "Bearer test-key"
+}
+
+// newTestHTTPOFDCollector creates a collector with its adapter initialized
+func newTestHTTPOFDCollector(t *testing.T, cfg config.HTTPOFDConfig) *HTTPOFDCollector {
+	t.Helper()
+
+	cfg.Enabled = true
+	cfg.PollInterval = time.Second
+	cfg.Timeout = time.Second
+
+	c := NewHTTPOFDCollector(cfg, logger.New("error", "json"))
+	adapter, err := newOFDAdapter(cfg)
+	if err != nil {
+		t.Fatalf("newOFDAdapter failed: %v", err)
+	}
+	c.adapter = adapter
+	return c
+}
+
+// collectMetrics runs one cycle and returns the emitted metrics by KKT ID
+func collectMetrics(t *testing.T, c *HTTPOFDCollector) map[string]domain.Metrics {
+	t.Helper()
+
+	if err := c.collectOnce(context.Background()); err != nil {
+		t.Fatalf("collectOnce failed: %v", err)
+	}
+
+	result := make(map[string]domain.Metrics)
+	for len(c.metricsChan) > 0 {
+		m := <-c.metricsChan
+		result[m.KKTID] = m
+	}
+	return result
+}
+
+func TestHTTPOFDCollector_Generic(t *testing.T) {
+	server := newFakeOFDServer(t, genericRoutes, bearerAuth)
+
+	c := newTestHTTPOFDCollector(t, config.HTTPOFDConfig{URL: server.URL + "/api/v1/", APIKey: "test-key"})
+	metrics := collectMetrics(t, c)
+	if len(metrics) != 1 {
+		t.Fatalf("Expected metrics for 1 KKT (the other one fails), got %d", len(metrics))
+	}
+
+	m, ok := metrics["0001234567890123"]
+	if !ok {
+		t.Fatal("Expected metrics for KKT 0001234567890123")
+	}
+	if m.Status != domain.KKTStatusRunning {
+		t.Errorf("Expected running status, got %d", m.Status)
+	}
+	if m.LastDocumentNumber != 1500 {
+		t.Errorf("Expected last document number 1500, got %d", m.LastDocumentNumber)
+	}
+	if m.DocumentsTotal != 0 {
+		t.Errorf("Expected no documents counted on the first poll, got %d", m.DocumentsTotal)
+	}
+	if m.ShiftStatus != domain.ShiftStatusOpen {
+		t.Errorf("Expected open shift, got %d", m.ShiftStatus)
+	}
+	if m.OFDSyncStatus != domain.OFDSyncStatusPending || m.UnsentDocuments != 3 {
+		t.Errorf("Expected pending sync with 3 unsent documents, got %d/%d", m.OFDSyncStatus, m.UnsentDocuments)
+	}
+	if m.FDMemoryUsage != 25 {
+		t.Errorf("Expected FD memory usage 25%%, got %v", m.FDMemoryUsage)
+	}
+
+	devices := c.Devices()
+	if len(devices) != 1 {
+		t.Fatalf("Expected 1 device, got %d", len(devices))
+	}
+	d := devices[0]
+	if d.FactoryNumber != "00106701234567" || d.FiscalDriveNum != "9999078900001234" {
+		t.Errorf("Unexpected device identity: %+v", d)
+	}
+	if !d.FiscalDriveInfo.ExpiryDate.Equal(time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected FN expiry date %v", d.FiscalDriveInfo.ExpiryDate)
+	}
+	if got, ok := c.Device(m.KKTID); !ok || got.FactoryNumber != d.FactoryNumber {
+		t.Errorf("Expected device %s by ID, got %+v", m.KKTID, got)
+	}
+	if _, ok := c.Device("unknown"); ok {
+		t.Error("Expected no device for an unknown ID")
+	}
+}
+
+func TestHTTPOFDCollector_GenericFieldMapping(t *testing.T) {
+	routes := map[string]interface{}{
+		"/devices": jsonObject{"result": jsonObject{"items": []jsonObject{
+			{"rn": 1234567890123456, "online": true, "fd": jsonObject{"num": 77, "at": "2024-05-01 12:00:00"}},
+		}}},
+	}
+	server := newFakeOFDServer(t, routes, func(r *http.Request) bool {
+		return r.Header.Get("X-Api-Key") == "test-key"
+	})
+
+	c := newTestHTTPOFDCollector(t, config.HTTPOFDConfig{
+		URL:     server.URL,
+		APIKey:  "test-key",
+		Options: map[string]string{"auth_header": "X-Api-Key", "auth_scheme": ""},
+		Endpoints: map[string]string{
+			"list": "/devices", "status": "", "last_document": "", "unsent": "", "fn": "",
+		},
+		Fields: map[string]string{
+			"list_items":      "list.result.items",
+			"id":              "kkt.rn",
+			"status":          "kkt.online",
+			"document_number": "kkt.fd.num",
+			"document_time":   "kkt.fd.at",
+		},
+	})
+
+	m, ok := collectMetrics(t, c)["1234567890123456"]
+	if !ok {
+		t.Fatal("Expected metrics for the numeric 16-digit KKT ID")
+	}
+	if m.Status != domain.KKTStatusRunning || m.LastDocumentNumber != 77 {
+		t.Errorf("Unexpected mapped metrics: %+v", m)
+	}
+	wantTime := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+	if !m.LastDocumentTime.Equal(wantTime) {
+		t.Errorf("Expected Moscow time %v, got %v", wantTime, m.LastDocumentTime)
+	}
+}
+
+func TestHTTPOFDCollector_Unauthorized(t *testing.T) {
+	server := newFakeOFDServer(t, genericRoutes, bearerAuth)
+
+	c := newTestHTTPOFDCollector(t, config.HTTPOFDConfig{URL: server.URL + "/api/v1", APIKey: "wrong-key"})
+	if err := c.collectOnce(context.Background()); err == nil {
+		t.Fatal("Expected error with wrong API key")
+	}
+	if len(c.metricsChan) != 0 {
+		t.Error("Expected no metrics on failure")
+	}
+}
+
+func TestHTTPOFDCollector_TrackDocuments(t *testing.T) {
+	c := newTestHTTPOFDCollector(t, config.HTTPOFDConfig{URL: "http://localhost"})
+	start := time.Now()
+
+	tests := []struct {
+		name      string
+		number    int
+		at        time.Time
+		wantTotal int64
+		wantRate  float64
+	}{
+		{name: "first sample", number: 100, at: start, wantTotal: 0, wantRate: 0},
+		{name: "growth", number: 150, at: start.Add(30 * time.Minute), wantTotal: 50, wantRate: 50},
+		// A new fiscal drive restarts numbering
+		{name: "numbering restart", number: 10, at: start.Add(time.Hour), wantTotal: 60, wantRate: 60},
+		// Documents of the last hour only, not extrapolated from one poll
+		{name: "one document", number: 11, at: start.Add(time.Hour + 30*time.Second), wantTotal: 61, wantRate: 61},
+		{name: "hour window", number: 20, at: start.Add(90 * time.Minute), wantTotal: 70, wantRate: 20},
+		{name: "no documents", number: 20, at: start.Add(150 * time.Minute), wantTotal: 70, wantRate: 0},
+	}
+
+	for _, tt := range tests {
+		total, rate := c.trackDocuments("kkt", tt.number, tt.at)
+		if total != tt.wantTotal || rate != tt.wantRate {
+			t.Errorf("%s: expected total %d and rate %v, got %d and %v", tt.name, tt.wantTotal, tt.wantRate, total, rate)
+		}
+	}
+}
+
+func TestHTTPOFDCollector_OldestUnsent(t *testing.T) {
+	c := newTestHTTPOFDCollector(t, config.HTTPOFDConfig{URL: "http://localhost"})
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	reported := start.Add(-48 * time.Hour)
+
+	tests := []struct {
+		name  string
+		state OFDKKTState
+		now   time.Time
+		want  time.Time
+	}{
+		{name: "nothing unsent", state: OFDKKTState{ID: "kkt"}, now: start},
+		{name: "first seen", state: OFDKKTState{ID: "kkt", UnsentDocuments: 2}, now: start, want: start},
+		{name: "still unsent", state: OFDKKTState{ID: "kkt", UnsentDocuments: 3}, now: start.Add(time.Hour), want: start},
+		{name: "reported by OFD", state: OFDKKTState{ID: "kkt", UnsentDocuments: 3, OldestUnsentTime: reported}, now: start.Add(time.Hour), want: reported},
+		{name: "all sent", state: OFDKKTState{ID: "kkt"}, now: start.Add(2 * time.Hour)},
+		{name: "unsent again", state: OFDKKTState{ID: "kkt", UnsentDocuments: 1}, now: start.Add(3 * time.Hour), want: start.Add(3 * time.Hour)},
+	}
+
+	for _, tt := range tests {
+		if got := c.oldestUnsent(&tt.state, tt.now); !got.Equal(tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestHTTPOFDCollector_ShiftOpened(t *testing.T) {
+	c := newTestHTTPOFDCollector(t, config.HTTPOFDConfig{URL: "http://localhost"})
+	start := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+	open := domain.ShiftStatusOpen
+
+	tests := []struct {
+		name  string
+		state OFDKKTState
+		now   time.Time
+		want  time.Time
+	}{
+		{name: "closed", state: OFDKKTState{ID: "kkt"}, now: start},
+		{name: "first seen open", state: OFDKKTState{ID: "kkt", ShiftStatus: open, ShiftNumber: 12}, now: start, want: start},
+		{name: "same shift", state: OFDKKTState{ID: "kkt", ShiftStatus: open, ShiftNumber: 12}, now: start.Add(time.Hour), want: start},
+		{name: "next shift", state: OFDKKTState{ID: "kkt", ShiftStatus: open, ShiftNumber: 13}, now: start.Add(2 * time.Hour), want: start.Add(2 * time.Hour)},
+		{name: "reported by OFD", state: OFDKKTState{ID: "kkt", ShiftStatus: open, ShiftNumber: 13, ShiftOpenedAt: start}, now: start.Add(3 * time.Hour), want: start},
+	}
+
+	for _, tt := range tests {
+		if got := c.shiftOpened(&tt.state, tt.now); !got.Equal(tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestHTTPOFDCollector_Forget(t *testing.T) {
+	c := newTestHTTPOFDCollector(t, config.HTTPOFDConfig{URL: "http://localhost"})
+	now := time.Now()
+	for _, id := range []string{"kkt-1", "kkt-2"} {
+		c.trackDocuments(id, 10, now)
+		c.oldestUnsent(&OFDKKTState{ID: id, UnsentDocuments: 1}, now)
+		c.shiftOpened(&OFDKKTState{ID: id, ShiftStatus: domain.ShiftStatusOpen, ShiftNumber: 1}, now)
+	}
+
+	c.forget(map[string]domain.KKTDevice{"kkt-1": {ID: "kkt-1"}})
+
+	if len(c.docSamples) != 1 || len(c.unsentSince) != 1 || len(c.shifts) != 1 {
+		t.Errorf("Expected state of kkt-1 only, got %d document, %d unsent and %d shift entries",
+			len(c.docSamples), len(c.unsentSince), len(c.shifts))
+	}
+	if _, ok := c.docSamples["kkt-1"]; !ok {
+		t.Error("Expected kkt-1 to be kept")
+	}
+}
+
+func TestOFDAdapters(t *testing.T) {
+	tests := []struct {
+		name   string
+		cfg    config.HTTPOFDConfig
+		routes map[string]interface{}
+		check  func(r *http.Request) bool
+		want   domain.Metrics
+	}{
+		{
+			name: "taxcom",
+			cfg: config.HTTPOFDConfig{
+				Provider: "taxcom",
+				APIKey:   "secret",
+				Options:  map[string]string{"integrator_id": "integrator", "login": "user"},
+			},
+			routes: map[string]interface{}{
+				"/Login":      jsonObject{"sessionToken": "session"},
+				"/OutletList": jsonObject{"records": []jsonObject{{"id": "outlet-1"}}},
+				"/KKTList?id=outlet-1": jsonObject{"records": []jsonObject{
+					{"kktRegNumber": "0001111111111111", "kktFactoryNumber": "001", "fnFactoryNumber": "9999"},
+				}},
+				"/KKTInfo?fn=9999": jsonObject{
+					"cashdeskState": "Active", "problemIndicator": "OK", "lastDocumentNumber": 321,
+					"lastDocumentDateTime": "2024-05-01T12:00:00", "shiftOpened": true, "notSentDocumentsCount": 2,
+				},
+			},
+			check: func(r *http.Request) bool {
+				if r.URL.Path == "/Login" {
+					return r.Header.Get("Integrator-Id") == "integrator"
+				}
+				return r.Header.Get("Session-Token") == "session"
+			},
+			want: domain.Metrics{
+				KKTID: "0001111111111111", Status: domain.KKTStatusRunning, LastDocumentNumber: 321,
+				ShiftStatus: domain.ShiftStatusOpen, OFDSyncStatus: domain.OFDSyncStatusPending, UnsentDocuments: 2,
+			},
+		},
+		{
+			name: "platforma",
+			cfg:  config.HTTPOFDConfig{Provider: "platforma", APIKey: "test-key"},
+			routes: map[string]interface{}{
+				"/kkt": jsonObject{"items": []jsonObject{
+					{"rnm": "0002222222222222", "zn": "002", "fn": "8888", "status": "ACTIVE", "lastFd": 55, "notSentCount": 0},
+				}},
+			},
+			check: bearerAuth,
+			want: domain.Metrics{
+				KKTID: "0002222222222222", Status: domain.KKTStatusRunning, LastDocumentNumber: 55,
+				OFDSyncStatus: domain.OFDSyncStatusSynced,
+			},
+		},
+		{
+			name: "ofd_ru",
+			cfg: config.HTTPOFDConfig{
+				Provider: "ofd_ru",
+				APIKey:   "token",
+				Options:  map[string]string{"inn": "7700000000"},
+			},
+			routes: map[string]interface{}{
+				"/inn/7700000000/kkts?AuthToken=token": jsonObject{"Status": "Success", "Data": []jsonObject{{
+					"KktRegId": "0003333333333333", "SerialNumber": "003", "FnNumber": "7777", "KktStatus": "Active",
+					"LastDocNumber":           90,
+					"LastDocOnKktDateTime":    "2024-05-01T12:30:00",
+					"LastDocOnOfdDateTimeUtc": "2024-05-01T09:00:00",
+				}}},
+			},
+			want: domain.Metrics{
+				KKTID: "0003333333333333", Status: domain.KKTStatusRunning, LastDocumentNumber: 90,
+				OFDSyncStatus: domain.OFDSyncStatusPending,
+			},
+		},
+		{
+			name: "kontur",
+			cfg: config.HTTPOFDConfig{
+				Provider: "kontur",
+				APIKey:   "test-key",
+				Options:  map[string]string{"organization_id": "org-1"},
+			},
+			routes: map[string]interface{}{
+				"/organizations/org-1/cashboxes": []jsonObject{
+					{"regNumber": "0004444444444444", "serialNumber": "004", "state": "Error", "lastDocumentNumber": 12},
+				},
+			},
+			check: func(r *http.Request) bool {
+				return r.Header.Get("X-Kontur-Apikey") == "test-key"
+			},
+			want: domain.Metrics{
+				KKTID: "0004444444444444", Status: domain.KKTStatusError, LastDocumentNumber: 12,
+				OFDSyncStatus: domain.OFDSyncStatusSynced,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeOFDServer(t, tt.routes, tt.check)
+			tt.cfg.URL = server.URL
+			tt.cfg.Name = "ofd-" + tt.name
+
+			c := newTestHTTPOFDCollector(t, tt.cfg)
+			got, ok := collectMetrics(t, c)[tt.want.KKTID]
+			if !ok {
+				t.Fatalf("Expected metrics for KKT %s", tt.want.KKTID)
+			}
+			if got.Collector != tt.cfg.Name {
+				t.Errorf("Expected metrics labelled with collector %s, got %q", tt.cfg.Name, got.Collector)
+			}
+			if got.Status != tt.want.Status ||
+				got.LastDocumentNumber != tt.want.LastDocumentNumber ||
+				got.ShiftStatus != tt.want.ShiftStatus ||
+				got.OFDSyncStatus != tt.want.OFDSyncStatus ||
+				got.UnsentDocuments != tt.want.UnsentDocuments {
+				t.Errorf("Unexpected metrics:\n got  %+v\n want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewOFDAdapter_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.HTTPOFDConfig
+	}{
+		{name: "unknown provider", cfg: config.HTTPOFDConfig{Provider: "nope", URL: "http://localhost"}},
+		{name: "generic without url", cfg: config.HTTPOFDConfig{Provider: "generic"}},
+		{name: "taxcom without login", cfg: config.HTTPOFDConfig{Provider: "taxcom", APIKey: "secret"}},
+		{name: "ofd_ru without inn", cfg: config.HTTPOFDConfig{Provider: "ofd_ru", APIKey: "token"}},
+		{name: "unknown generic field", cfg: config.HTTPOFDConfig{URL: "http://localhost", Fields: map[string]string{"nope": "x"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newOFDAdapter(tt.cfg); err == nil {
+				t.Error("Expected error")
+			}
+		})
+	}
+}
+
+func TestOFDTransport_Retry(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int
+		retryAfter   string
+		wantErr      bool
+		wantRequests int
+	}{
+		{name: "server errors then success", statuses: []int{503, 502, 200}, wantRequests: 3},
+		{name: "gives up after max attempts", statuses: []int{500, 500, 500, 500}, wantErr: true, wantRequests: 3},
+		{name: "client error is not retried", statuses: []int{400, 200}, wantErr: true, wantRequests: 1},
+		{name: "429 then success", statuses: []int{429, 200}, retryAfter: "0", wantRequests: 2},
+		{name: "429 with long Retry-After", statuses: []int{429, 200}, retryAfter: "3600", wantErr: true, wantRequests: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				status := tt.statuses[requests]
+				requests++
+				if tt.retryAfter != "" {
+					w.Header().Set("Retry-After", tt.retryAfter)
+				}
+				w.WriteHeader(status)
+				_, _ = w.Write([]byte(`{"ok":true}`))
+			}))
+			defer server.Close()
+
+			// Limiters are shared by endpoint, keep a Retry-After pause to this case
+			transport := NewOFDTransport(tt.name, server.URL, config.HTTPOFDConfig{
+				Timeout: time.Second,
+				Retry: config.OFDRetryConfig{
+					MaxAttempts:    3,
+					InitialBackoff: time.Millisecond,
+					MaxBackoff:     10 * time.Millisecond,
+					Jitter:         0.2,
+				},
+			})
+
+			var out jsonObject
+			err := transport.Get(context.Background(), "/", nil, nil, &out)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Get() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if requests != tt.wantRequests {
+				t.Errorf("Expected %d requests, got %d", tt.wantRequests, requests)
+			}
+		})
+	}
+}
+
+func TestOFDTransport_RedactsQuery(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+
+	transport := NewOFDTransport("ofd_ru", server.URL, config.HTTPOFDConfig{
+		Timeout: time.Second,
+		Retry:   config.OFDRetryConfig{MaxAttempts: 1},
+	})
+
+	err := transport.Get(context.Background(), "/inn/7700000000/kkts", url.Values{"AuthToken": {"secret-token"}}, nil, nil)
+	if err == nil {
+		t.Fatal("Expected error from a closed server")
+	}
+	if strings.Contains(err.Error(), "secret-token") {
+		t.Errorf("Expected token to be redacted, got %v", err)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{value: "", want: 0},
+		{value: "120", want: 2 * time.Minute},
+		{value: "Wed, 01 May 2024 12:00:30 GMT", want: 30 * time.Second},
+		{value: "Wed, 01 May 2024 11:00:00 GMT", want: 0},
+		{value: "soon", want: 0},
+	}
+
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.value, now); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
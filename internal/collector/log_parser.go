@@ -0,0 +1,166 @@
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/domain"
+)
+
+// Log event kinds
+const (
+	logEventDocument = "document"
+	logEventError    = "error"
+	logEventStatus   = "status"
+	logEventOFD      = "ofd"
+)
+
+// logEvent is a single event decoded from a KKT log line
+type logEvent struct {
+	Kind     string
+	KKTID    string
+	Time     time.Time
+	Document *domain.FiscalDocument
+	Error    *domain.KKTError
+	Status   *statusEvent
+	OFD      *domain.OFDTransaction
+}
+
+// statusEvent carries a partial device status update; nil fields are left unchanged
+type statusEvent struct {
+	Status          *domain.KKTStatus     `json:"status,omitempty"`
+	ShiftStatus     *domain.ShiftStatus   `json:"shift_status,omitempty"`
+	OFDSyncStatus   *domain.OFDSyncStatus `json:"ofd_sync_status,omitempty"`
+	FDMemoryUsage   *float64              `json:"fd_memory_usage,omitempty"`
+	AverageSyncTime *float64              `json:"average_sync_time,omitempty"`
+	// FiscalDrive updates the non-zero fiscal drive fields
+	FiscalDrive *domain.FiscalDrive `json:"fiscal_drive,omitempty"`
+	// UnsentDocuments and OldestUnsentTime are the unsent document counters
+	// reported by the fiscal drive
+	UnsentDocuments  *int64     `json:"unsent_documents,omitempty"`
+	OldestUnsentTime *time.Time `json:"oldest_unsent_time,omitempty"`
+}
+
+// lineParser decodes a single log line into an event
+type lineParser interface {
+	// Parse decodes the line. It returns ok=false for lines that carry no event.
+	Parse(line []byte) (ev logEvent, ok bool, err error)
+}
+
+// jsonLine is the schema of a JSON log line.
+//
+// Every line is a single JSON object with a common envelope and one payload
+// object selected by "event":
+//
+//	{"time": "2024-05-01T10:00:00+03:00", "kkt_id": "kkt-001", "event": "document", "document": {...}}
+//	{"time": "2024-05-01T10:00:05+03:00", "kkt_id": "kkt-001", "event": "error", "error": {...}}
+//	{"time": "2024-05-01T10:00:10+03:00", "kkt_id": "kkt-001", "event": "status", "status": {...}}
+//	{"time": "2024-05-01T10:00:15+03:00", "kkt_id": "kkt-001", "event": "ofd", "ofd": {...}}
+//
+// The "document" payload uses the domain.FiscalDocument JSON fields, the
+// "error" payload uses the domain.KKTError JSON fields and the "ofd" payload
+// uses the domain.OFDTransaction JSON fields. Missing kkt_id and
+// timestamps in the payload are taken from the envelope. See
+// docs/FILE_LOG_FORMAT.md for the full description.
+type jsonLine struct {
+	Time     time.Time              `json:"time"`
+	KKTID    string                 `json:"kkt_id"`
+	Event    string                 `json:"event"`
+	Document *domain.FiscalDocument `json:"document,omitempty"`
+	Error    *domain.KKTError       `json:"error,omitempty"`
+	Status   *statusEvent           `json:"status,omitempty"`
+	OFD      *domain.OFDTransaction `json:"ofd,omitempty"`
+}
+
+// jsonLineParser parses JSON log lines
+type jsonLineParser struct{}
+
+// Parse decodes a JSON log line
+func (jsonLineParser) Parse(line []byte) (logEvent, bool, error) {
+	var raw jsonLine
+	if err := json.Unmarshal(line, &raw); err != nil {
+		return logEvent{}, false, fmt.Errorf("invalid JSON log line: %w", err)
+	}
+
+	if raw.KKTID == "" {
+		return logEvent{}, false, fmt.Errorf("log line has no kkt_id")
+	}
+
+	ev := logEvent{
+		Kind:  raw.Event,
+		KKTID: raw.KKTID,
+		Time:  raw.Time,
+	}
+
+	switch raw.Event {
+	case logEventDocument:
+		if raw.Document == nil {
+			return logEvent{}, false, fmt.Errorf("document event without document payload")
+		}
+		ev.Document = raw.Document
+	case logEventError:
+		if raw.Error == nil {
+			return logEvent{}, false, fmt.Errorf("error event without error payload")
+		}
+		ev.Error = raw.Error
+	case logEventStatus:
+		if raw.Status == nil {
+			return logEvent{}, false, fmt.Errorf("status event without status payload")
+		}
+		ev.Status = raw.Status
+	case logEventOFD:
+		if raw.OFD == nil {
+			return logEvent{}, false, fmt.Errorf("ofd event without ofd payload")
+		}
+		if raw.OFD.DocumentID == "" {
+			return logEvent{}, false, fmt.Errorf("ofd event without document_id")
+		}
+		ev.OFD = raw.OFD
+	default:
+		return logEvent{}, false, nil
+	}
+
+	ev.normalize()
+	return ev, true, nil
+}
+
+// normalize fills payload fields that were omitted in favour of the envelope
+func (ev *logEvent) normalize() {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+
+	if doc := ev.Document; doc != nil {
+		if doc.KKTID == "" {
+			doc.KKTID = ev.KKTID
+		}
+		if doc.DateTime.IsZero() {
+			doc.DateTime = ev.Time
+		}
+		if doc.ID == "" {
+			doc.ID = fmt.Sprintf("%s-%d", doc.KKTID, doc.DocumentNumber)
+		}
+	}
+
+	if kktErr := ev.Error; kktErr != nil {
+		if kktErr.KKTID == "" {
+			kktErr.KKTID = ev.KKTID
+		}
+		if kktErr.Timestamp.IsZero() {
+			kktErr.Timestamp = ev.Time
+		}
+		if kktErr.ID == "" {
+			kktErr.ID = fmt.Sprintf("%s-%s-%d", kktErr.KKTID, kktErr.ErrorCode, kktErr.Timestamp.UnixNano())
+		}
+	}
+
+	if tx := ev.OFD; tx != nil {
+		if tx.KKTID == "" {
+			tx.KKTID = ev.KKTID
+		}
+		if tx.SentAt.IsZero() && tx.AcknowledgedAt == nil {
+			tx.SentAt = ev.Time
+		}
+	}
+}
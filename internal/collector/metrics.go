@@ -0,0 +1,105 @@
+package collector
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Self-monitoring metrics of the collectors
+var (
+	ofdRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kkt_ofd_requests_total",
+			Help: "Total number of OFD API requests by response code (0=transport error)",
+		},
+		[]string{"collector", "provider", "code"},
+	)
+
+	ofdRetriesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kkt_ofd_request_retries_total",
+			Help: "Total number of retried OFD API requests by reason",
+		},
+		[]string{"collector", "provider", "reason"},
+	)
+
+	ofdThrottledTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kkt_ofd_throttled_requests_total",
+			Help: "Total number of delayed OFD API requests (source=limiter for the local rate limit, server for HTTP 429)",
+		},
+		[]string{"collector", "provider", "source"},
+	)
+
+	ofdThrottleWaitSeconds = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kkt_ofd_throttle_wait_seconds_total",
+			Help: "Total time OFD API requests waited for the rate limiter",
+		},
+		[]string{"collector", "provider"},
+	)
+
+	collectorUp = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "kkt_collector_up",
+			Help: "Whether the last collection cycle succeeded (1) or failed (0)",
+		},
+		[]string{"collector"},
+	)
+
+	collectorLastSuccess = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "kkt_collector_last_success_timestamp_seconds",
+			Help: "Time of the last successful collection cycle (Unix time)",
+		},
+		[]string{"collector"},
+	)
+
+	collectorCycleDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "kkt_collector_cycle_duration_seconds",
+			Help:    "Duration of collection cycles",
+			Buckets: []float64{0.01, 0.05, 0.1, 0.5, 1, 2.5, 5, 10, 30, 60},
+		},
+		[]string{"collector"},
+	)
+
+	collectorErrorsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kkt_collector_errors_total",
+			Help: "Total number of failed collection cycles",
+		},
+		[]string{"collector"},
+	)
+
+	collectorDroppedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kkt_collector_dropped_total",
+			Help: "Total number of values dropped because the channel was full (channel: metrics, errors; documents are never dropped)",
+		},
+		[]string{"collector", "channel"},
+	)
+
+	collectorChannelFill = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "kkt_collector_channel_fill_ratio",
+			Help: "Fill level of the collector output channel at the end of the last cycle, 0-1 (channel: metrics, errors, documents)",
+		},
+		[]string{"collector", "channel"},
+	)
+)
+
+// SelfMetrics returns the collector self-monitoring metrics for registration
+func SelfMetrics() []prometheus.Collector {
+	return []prometheus.Collector{
+		ofdRequestsTotal,
+		ofdRetriesTotal,
+		ofdThrottledTotal,
+		ofdThrottleWaitSeconds,
+		collectorUp,
+		collectorLastSuccess,
+		collectorCycleDuration,
+		collectorErrorsTotal,
+		collectorDroppedTotal,
+		collectorChannelFill,
+	}
+}
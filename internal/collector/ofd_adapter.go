@@ -0,0 +1,379 @@
+package collector
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/config"
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/domain"
+)
+
+// maxOFDResponseSize limits the size of a decoded OFD response body
+const maxOFDResponseSize = 10 << 20
+
+// OFDAdapter fetches KKT state from the API of a specific OFD operator
+type OFDAdapter interface {
+	// Name returns the provider name
+	Name() string
+
+	// FetchKKTs returns the current state of every KKT of the account.
+	// Failures of individual devices are returned as a joined error along
+	// with the states that were fetched successfully.
+	FetchKKTs(ctx context.Context) ([]OFDKKTState, error)
+}
+
+// OFDKKTState is the state of a KKT as reported by an OFD
+type OFDKKTState struct {
+	ID            string
+	FactoryNumber string
+	RegNumber     string
+	Status        domain.KKTStatus
+	LastSeen      time.Time
+	ShiftStatus   domain.ShiftStatus
+	ShiftNumber   int
+	// ShiftOpenedAt is when the open shift was opened, zero when the OFD
+	// does not report it
+	ShiftOpenedAt      time.Time
+	LastDocumentNumber int
+	LastDocumentTime   time.Time
+	UnsentDocuments    int64
+	// OldestUnsentTime is the time of the oldest unsent document, zero when
+	// the OFD does not report it
+	OldestUnsentTime time.Time
+	// OFDSyncStatus is derived from UnsentDocuments when left unknown
+	OFDSyncStatus domain.OFDSyncStatus
+	FiscalDrive   domain.FiscalDrive
+}
+
+// OFDAdapterFactory creates an adapter from collector configuration
+type OFDAdapterFactory func(cfg config.HTTPOFDConfig, transport *OFDTransport) (OFDAdapter, error)
+
+var (
+	ofdAdaptersMu sync.RWMutex
+	ofdAdapters   = make(map[string]OFDAdapterFactory)
+)
+
+// RegisterOFDAdapter registers an OFD adapter factory under a provider name
+func RegisterOFDAdapter(name string, factory OFDAdapterFactory) {
+	ofdAdaptersMu.Lock()
+	defer ofdAdaptersMu.Unlock()
+
+	if _, exists := ofdAdapters[name]; exists {
+		panic(fmt.Sprintf("OFD adapter %q already registered", name))
+	}
+	ofdAdapters[name] = factory
+}
+
+// OFDProviders returns the names of registered OFD adapters
+func OFDProviders() []string {
+	ofdAdaptersMu.RLock()
+	defer ofdAdaptersMu.RUnlock()
+
+	names := make([]string, 0, len(ofdAdapters))
+	for name := range ofdAdapters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// newOFDAdapter creates the adapter for the configured provider
+func newOFDAdapter(cfg config.HTTPOFDConfig) (OFDAdapter, error) {
+	cfg = cfg.Resolved()
+
+	ofdAdaptersMu.RLock()
+	factory, ok := ofdAdapters[cfg.Provider]
+	ofdAdaptersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown OFD provider %q (available: %s)", cfg.Provider, strings.Join(OFDProviders(), ", "))
+	}
+
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("url is required for OFD provider %q", cfg.Provider)
+	}
+
+	return factory(cfg, NewOFDTransport(cfg.Provider, cfg.URL, cfg))
+}
+
+// OFDTransport performs JSON requests against an OFD API base URL. Requests
+// are rate limited with a token bucket and retried with exponential backoff
+// on transport errors, HTTP 429 and 5xx responses.
+type OFDTransport struct {
+	collector string
+	provider  string
+	baseURL   string
+	client    *http.Client
+	retry     config.OFDRetryConfig
+	limiter   *rateLimiter
+}
+
+// NewOFDTransport creates a new transport for the provider
+func NewOFDTransport(provider, baseURL string, cfg config.HTTPOFDConfig) *OFDTransport {
+	account := cfg
+	account.Provider, account.URL = provider, baseURL
+	account = account.Resolved()
+
+	return &OFDTransport{
+		collector: cfg.Name,
+		provider:  account.Provider,
+		baseURL:   account.URL,
+		client:    &http.Client{Timeout: cfg.Timeout},
+		retry:     cfg.Retry,
+		limiter:   sharedRateLimiter(account.Account(), cfg.RateLimit),
+	}
+}
+
+// OFDHTTPError is returned for non-successful HTTP responses
+type OFDHTTPError struct {
+	Path       string
+	StatusCode int
+	Status     string
+	Body       string
+	// RetryAfter is the delay requested by the Retry-After header
+	RetryAfter time.Duration
+}
+
+// Error implements error
+func (e *OFDHTTPError) Error() string {
+	return fmt.Sprintf("request to %s failed: %s: %s", e.Path, e.Status, e.Body)
+}
+
+// Do performs a request with an optional JSON body and decodes the JSON
+// response into out when it is not nil
+func (t *OFDTransport) Do(ctx context.Context, method, path string, query url.Values, header http.Header,
+	body, out interface{}) error {
+	target := t.baseURL + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := t.attempt(ctx, method, target, path, header, data, out)
+		if err == nil {
+			return nil
+		}
+
+		reason, retryable := retryReason(err)
+		if !retryable || ctx.Err() != nil {
+			return err
+		}
+
+		delay := t.backoff(attempt)
+		var httpErr *OFDHTTPError
+		if errors.As(err, &httpErr) && httpErr.StatusCode == http.StatusTooManyRequests {
+			ofdThrottledTotal.WithLabelValues(t.collector, t.provider, "server").Inc()
+			if httpErr.RetryAfter > 0 {
+				// Hold every request of the provider, not only this retry
+				t.limiter.PauseUntil(time.Now().Add(httpErr.RetryAfter))
+				if httpErr.RetryAfter > t.retry.MaxBackoff {
+					return err
+				}
+				delay = max(delay, httpErr.RetryAfter)
+			}
+		}
+		if attempt >= t.retry.MaxAttempts {
+			return err
+		}
+
+		ofdRetriesTotal.WithLabelValues(t.collector, t.provider, reason).Inc()
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// attempt performs a single request once the rate limiter allows it
+func (t *OFDTransport) attempt(ctx context.Context, method, target, path string, header http.Header,
+	data []byte, out interface{}) error {
+	waited, err := t.limiter.Wait(ctx)
+	if waited > 0 {
+		ofdThrottledTotal.WithLabelValues(t.collector, t.provider, "limiter").Inc()
+		ofdThrottleWaitSeconds.WithLabelValues(t.collector, t.provider).Add(waited.Seconds())
+	}
+	if err != nil {
+		return fmt.Errorf("request to %s failed: %w", path, err)
+	}
+
+	var reqBody io.Reader = http.NoBody
+	if data != nil {
+		reqBody = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, target, reqBody)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Accept", "application/json")
+	if data != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		ofdRequestsTotal.WithLabelValues(t.collector, t.provider, "0").Inc()
+		// Some providers take credentials in the query, keep them out of logs
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			urlErr.URL = redactQuery(urlErr.URL)
+		}
+		return fmt.Errorf("request to %s failed: %w", path, err)
+	}
+	defer resp.Body.Close()
+	ofdRequestsTotal.WithLabelValues(t.collector, t.provider, strconv.Itoa(resp.StatusCode)).Inc()
+
+	limited := io.LimitReader(resp.Body, maxOFDResponseSize)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		snippet, _ := io.ReadAll(io.LimitReader(limited, 512))
+		return &OFDHTTPError{
+			Path:       path,
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+			Body:       strings.TrimSpace(string(snippet)),
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+		}
+	}
+
+	if out == nil {
+		return nil
+	}
+	dec := json.NewDecoder(limited)
+	dec.UseNumber() // keep 16-digit registration numbers intact
+	if err := dec.Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response of %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// Get performs a GET request and decodes the JSON response into out
+func (t *OFDTransport) Get(ctx context.Context, path string, query url.Values, header http.Header, out interface{}) error {
+	return t.Do(ctx, http.MethodGet, path, query, header, nil, out)
+}
+
+// redactQuery strips the query string from a request URL
+func redactQuery(target string) string {
+	if i := strings.IndexByte(target, '?'); i >= 0 {
+		return target[:i] + "?REDACTED"
+	}
+	return target
+}
+
+// backoff returns the jittered exponential delay before the next attempt
+func (t *OFDTransport) backoff(attempt int) time.Duration {
+	delay := t.retry.InitialBackoff
+	for i := 1; i < attempt && delay < t.retry.MaxBackoff; i++ {
+		delay *= 2
+	}
+	delay = min(delay, t.retry.MaxBackoff)
+
+	if t.retry.Jitter > 0 {
+		delay = time.Duration(float64(delay) * (1 + t.retry.Jitter*(2*rand.Float64()-1)))
+	}
+	return delay
+}
+
+// retryReason reports whether a request error is worth retrying and the
+// reason label for metrics
+func retryReason(err error) (string, bool) {
+	var httpErr *OFDHTTPError
+	if !errors.As(err, &httpErr) {
+		// Transport errors; canceled contexts are checked by the caller
+		var urlErr *url.Error
+		return "network", errors.As(err, &urlErr)
+	}
+
+	switch httpErr.StatusCode {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return strconv.Itoa(httpErr.StatusCode), true
+	default:
+		return "", false
+	}
+}
+
+// parseRetryAfter parses a Retry-After header given in seconds or as an HTTP date
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0)
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(at.Sub(now), 0)
+	}
+	return 0
+}
+
+// moscow is the time zone of OFD timestamps without offset
+var moscow = time.FixedZone("MSK", 3*60*60)
+
+// parseOFDTime parses RFC 3339 timestamps and local Moscow timestamps
+// without offset, as used by most OFD APIs
+func parseOFDTime(s string) (time.Time, error) {
+	return parseOFDTimeIn(s, moscow)
+}
+
+// parseOFDTimeIn parses RFC 3339 timestamps and timestamps without offset
+// in the given location
+func parseOFDTimeIn(s string, loc *time.Location) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", s)
+}
+
+// ofdKKTStatus maps a textual OFD device status to KKTStatus
+func ofdKKTStatus(status string) domain.KKTStatus {
+	switch strings.ToLower(status) {
+	case "online", "active", "running", "ok":
+		return domain.KKTStatusRunning
+	case "error", "problem", "warning", "expired":
+		return domain.KKTStatusError
+	default:
+		return domain.KKTStatusUnavailable
+	}
+}
+
+// memoryUsage computes the fiscal drive usage percentage
+func memoryUsage(fn domain.FiscalDrive) float64 {
+	if fn.DocumentsMax <= 0 {
+		return 0
+	}
+	return float64(fn.DocumentsUsed) / float64(fn.DocumentsMax) * 100
+}
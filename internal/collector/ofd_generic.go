@@ -0,0 +1,279 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/config"
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/domain"
+)
+
+func init() {
+	RegisterOFDAdapter("generic", newGenericAdapter)
+}
+
+// Default endpoints of the generic adapter. "{id}" is replaced with the KKT ID.
+var genericDefaultEndpoints = map[string]string{
+	"list":          "/kkts",
+	"status":        "/kkts/{id}/status",
+	"last_document": "/kkts/{id}/documents/last",
+	"unsent":        "/kkts/{id}/unsent",
+	"fn":            "/kkts/{id}/fn",
+}
+
+// Default field mapping of the generic adapter. Paths are dot-separated and
+// rooted at the endpoint name ("kkt" is an item of the list response).
+var genericDefaultFields = map[string]string{
+	"list_items":         "list.kkts",
+	"id":                 "kkt.id",
+	"factory_number":     "kkt.factory_number",
+	"reg_number":         "kkt.reg_number",
+	"fn_number":          "kkt.fn_number",
+	"status":             "status.status",
+	"last_seen":          "status.last_seen",
+	"shift_open":         "status.shift_open",
+	"shift_number":       "status.shift_number",
+	"shift_opened_at":    "status.shift_opened_at",
+	"document_number":    "last_document.document_number",
+	"document_time":      "last_document.date_time",
+	"unsent_count":       "unsent.count",
+	"unsent_oldest_time": "unsent.oldest_date_time",
+	"fn_serial":          "fn.number",
+	"fn_expiry_date":     "fn.expiry_date",
+	"fn_documents_max":   "fn.documents_max",
+	"fn_documents_used":  "fn.documents_used",
+}
+
+// genericAdapter reads any JSON OFD API through a configurable mapping of
+// endpoints and fields. Without configuration it expects the following
+// resources, authenticated with "Authorization: Bearer <api_key>":
+//
+//	GET /kkts                       {"kkts": [{"id", "factory_number", "reg_number", "fn_number"}]}
+//	GET /kkts/{id}/status           {"status": "online|offline|error", "last_seen", "shift_open", "shift_number", "shift_opened_at"}
+//	GET /kkts/{id}/documents/last   {"document_number", "date_time"}
+//	GET /kkts/{id}/unsent           {"count", "oldest_date_time"}
+//	GET /kkts/{id}/fn               {"number", "expiry_date", "documents_max", "documents_used"}
+//
+// An endpoint mapped to an empty string is not requested; its fields can
+// then be read from the list item instead (e.g. "status": "kkt.state").
+type genericAdapter struct {
+	transport *OFDTransport
+	header    http.Header
+	endpoints map[string]string
+	fields    map[string]string
+}
+
+// newGenericAdapter creates a generic adapter
+func newGenericAdapter(cfg config.HTTPOFDConfig, transport *OFDTransport) (OFDAdapter, error) {
+	a := &genericAdapter{
+		transport: transport,
+		header:    make(http.Header),
+		endpoints: mergeStringMaps(genericDefaultEndpoints, cfg.Endpoints),
+		fields:    mergeStringMaps(genericDefaultFields, cfg.Fields),
+	}
+
+	for name := range cfg.Endpoints {
+		if _, ok := genericDefaultEndpoints[name]; !ok {
+			return nil, fmt.Errorf("unknown generic OFD endpoint %q", name)
+		}
+	}
+	for name := range cfg.Fields {
+		if _, ok := genericDefaultFields[name]; !ok {
+			return nil, fmt.Errorf("unknown generic OFD field %q", name)
+		}
+	}
+	if a.endpoints["list"] == "" {
+		return nil, fmt.Errorf("generic OFD list endpoint is required")
+	}
+
+	if cfg.APIKey != "" {
+		headerName := cfg.Options["auth_header"]
+		if headerName == "" {
+			headerName = "Authorization"
+		}
+		scheme, ok := cfg.Options["auth_scheme"]
+		if !ok {
+			scheme = "Bearer"
+		}
+		a.header.Set(headerName, strings.TrimSpace(scheme+" "+cfg.APIKey))
+	}
+
+	return a, nil
+}
+
+// Name returns the provider name
+func (a *genericAdapter) Name() string {
+	return "generic"
+}
+
+// FetchKKTs fetches the list and the per-device endpoints
+func (a *genericAdapter) FetchKKTs(ctx context.Context) ([]OFDKKTState, error) {
+	var list interface{}
+	if err := a.transport.Get(ctx, a.endpoints["list"], nil, a.header, &list); err != nil {
+		return nil, err
+	}
+
+	items, ok := lookupPath(map[string]interface{}{"list": list}, a.fields["list_items"]).([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("list response has no %q array", a.fields["list_items"])
+	}
+
+	var (
+		states []OFDKKTState
+		errs   []error
+	)
+	for _, item := range items {
+		state, err := a.fetchKKT(ctx, item)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		states = append(states, state)
+	}
+
+	return states, errors.Join(errs...)
+}
+
+// fetchKKT fetches per-device endpoints and maps the fields
+func (a *genericAdapter) fetchKKT(ctx context.Context, item interface{}) (OFDKKTState, error) {
+	doc := map[string]interface{}{"kkt": item}
+
+	id := jsonString(lookupPath(doc, a.fields["id"]))
+	if id == "" {
+		return OFDKKTState{}, fmt.Errorf("list item has no %q", a.fields["id"])
+	}
+
+	for _, name := range []string{"status", "last_document", "unsent", "fn"} {
+		endpoint := a.endpoints[name]
+		if endpoint == "" {
+			continue
+		}
+		var resp interface{}
+		path := strings.ReplaceAll(endpoint, "{id}", id)
+		if err := a.transport.Get(ctx, path, nil, a.header, &resp); err != nil {
+			return OFDKKTState{}, fmt.Errorf("kkt %s: %w", id, err)
+		}
+		doc[name] = resp
+	}
+
+	field := func(name string) interface{} {
+		return lookupPath(doc, a.fields[name])
+	}
+
+	state := OFDKKTState{
+		ID:                 id,
+		FactoryNumber:      jsonString(field("factory_number")),
+		RegNumber:          jsonString(field("reg_number")),
+		Status:             jsonKKTStatus(field("status")),
+		ShiftNumber:        int(jsonNumber(field("shift_number"))),
+		LastDocumentNumber: int(jsonNumber(field("document_number"))),
+		UnsentDocuments:    int64(jsonNumber(field("unsent_count"))),
+		FiscalDrive: domain.FiscalDrive{
+			Number:        jsonString(field("fn_serial")),
+			DocumentsMax:  int(jsonNumber(field("fn_documents_max"))),
+			DocumentsUsed: int(jsonNumber(field("fn_documents_used"))),
+		},
+	}
+	if state.FiscalDrive.Number == "" {
+		state.FiscalDrive.Number = jsonString(field("fn_number"))
+	}
+	if open, _ := field("shift_open").(bool); open {
+		state.ShiftStatus = domain.ShiftStatusOpen
+	}
+
+	var err error
+	if state.LastSeen, err = jsonTime(field("last_seen")); err != nil {
+		return OFDKKTState{}, fmt.Errorf("kkt %s: last_seen: %w", id, err)
+	}
+	if state.LastDocumentTime, err = jsonTime(field("document_time")); err != nil {
+		return OFDKKTState{}, fmt.Errorf("kkt %s: document_time: %w", id, err)
+	}
+	if state.FiscalDrive.ExpiryDate, err = jsonTime(field("fn_expiry_date")); err != nil {
+		return OFDKKTState{}, fmt.Errorf("kkt %s: fn_expiry_date: %w", id, err)
+	}
+	if state.ShiftOpenedAt, err = jsonTime(field("shift_opened_at")); err != nil {
+		return OFDKKTState{}, fmt.Errorf("kkt %s: shift_opened_at: %w", id, err)
+	}
+	if state.OldestUnsentTime, err = jsonTime(field("unsent_oldest_time")); err != nil {
+		return OFDKKTState{}, fmt.Errorf("kkt %s: unsent_oldest_time: %w", id, err)
+	}
+
+	return state, nil
+}
+
+// lookupPath resolves a dot-separated path in decoded JSON
+func lookupPath(doc interface{}, path string) interface{} {
+	if path == "" {
+		return nil
+	}
+
+	cur := doc
+	for _, part := range strings.Split(path, ".") {
+		obj, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		cur = obj[part]
+	}
+	return cur
+}
+
+// jsonString converts a decoded JSON scalar to string
+func jsonString(v interface{}) string {
+	switch val := v.(type) {
+	case string:
+		return val
+	case json.Number:
+		return val.String()
+	default:
+		return ""
+	}
+}
+
+// jsonNumber converts a decoded JSON number or numeric string to float64
+func jsonNumber(v interface{}) float64 {
+	switch val := v.(type) {
+	case json.Number:
+		f, _ := val.Float64()
+		return f
+	case string:
+		f, _ := strconv.ParseFloat(val, 64)
+		return f
+	default:
+		return 0
+	}
+}
+
+// jsonTime converts a decoded JSON string to time
+func jsonTime(v interface{}) (time.Time, error) {
+	return parseOFDTime(jsonString(v))
+}
+
+// jsonKKTStatus converts a textual or boolean status to KKTStatus
+func jsonKKTStatus(v interface{}) domain.KKTStatus {
+	if online, ok := v.(bool); ok {
+		if online {
+			return domain.KKTStatusRunning
+		}
+		return domain.KKTStatusUnavailable
+	}
+	return ofdKKTStatus(jsonString(v))
+}
+
+// mergeStringMaps returns a copy of base overridden by values of override
+func mergeStringMaps(base, override map[string]string) map[string]string {
+	merged := make(map[string]string, len(base)+len(override))
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range override {
+		merged[k] = v
+	}
+	return merged
+}
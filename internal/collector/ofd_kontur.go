@@ -0,0 +1,99 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/config"
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/domain"
+)
+
+func init() {
+	RegisterOFDAdapter("kontur", newKonturAdapter)
+}
+
+// konturAdapter reads the Kontur.OFD API. Cash boxes of an organization are
+// listed with GET /organizations/{organization_id}/cashboxes, authenticated
+// with the X-Kontur-Apikey header.
+type konturAdapter struct {
+	transport      *OFDTransport
+	header         http.Header
+	organizationID string
+}
+
+type konturCashbox struct {
+	RegNumber          string `json:"regNumber"`
+	SerialNumber       string `json:"serialNumber"`
+	FNSerialNumber     string `json:"fnSerialNumber"`
+	FNExpirationDate   string `json:"fnExpirationDate"`
+	State              string `json:"state"` // Active, Inactive, Error
+	LastConnectionTime string `json:"lastConnectionTime"`
+	LastDocumentNumber int    `json:"lastDocumentNumber"`
+	LastDocumentTime   string `json:"lastDocumentTime"`
+	UnsentDocuments    int64  `json:"unsentDocumentsCount"`
+	ShiftIsOpen        bool   `json:"shiftIsOpen"`
+	ShiftNumber        int    `json:"shiftNumber"`
+}
+
+// newKonturAdapter creates a Kontur.OFD adapter
+func newKonturAdapter(cfg config.HTTPOFDConfig, transport *OFDTransport) (OFDAdapter, error) {
+	a := &konturAdapter{
+		transport:      transport,
+		header:         http.Header{"X-Kontur-Apikey": {cfg.APIKey}},
+		organizationID: cfg.Options["organization_id"],
+	}
+	if a.organizationID == "" || cfg.APIKey == "" {
+		return nil, fmt.Errorf("kontur OFD requires options organization_id and api_key")
+	}
+	return a, nil
+}
+
+// Name returns the provider name
+func (a *konturAdapter) Name() string {
+	return "kontur"
+}
+
+// FetchKKTs fetches the cash boxes of the organization
+func (a *konturAdapter) FetchKKTs(ctx context.Context) ([]OFDKKTState, error) {
+	var cashboxes []konturCashbox
+	path := "/organizations/" + url.PathEscape(a.organizationID) + "/cashboxes"
+	if err := a.transport.Get(ctx, path, nil, a.header, &cashboxes); err != nil {
+		return nil, err
+	}
+
+	var (
+		states []OFDKKTState
+		errs   []error
+	)
+	for _, cb := range cashboxes {
+		state := OFDKKTState{
+			ID:                 cb.RegNumber,
+			FactoryNumber:      cb.SerialNumber,
+			RegNumber:          cb.RegNumber,
+			Status:             ofdKKTStatus(cb.State),
+			ShiftNumber:        cb.ShiftNumber,
+			LastDocumentNumber: cb.LastDocumentNumber,
+			UnsentDocuments:    cb.UnsentDocuments,
+			FiscalDrive:        domain.FiscalDrive{Number: cb.FNSerialNumber},
+		}
+		if cb.ShiftIsOpen {
+			state.ShiftStatus = domain.ShiftStatusOpen
+		}
+
+		var err1, err2, err3 error
+		state.LastSeen, err1 = parseOFDTime(cb.LastConnectionTime)
+		state.LastDocumentTime, err2 = parseOFDTime(cb.LastDocumentTime)
+		state.FiscalDrive.ExpiryDate, err3 = parseOFDTime(cb.FNExpirationDate)
+		if err := errors.Join(err1, err2, err3); err != nil {
+			errs = append(errs, fmt.Errorf("cashbox %s: %w", cb.RegNumber, err))
+			continue
+		}
+
+		states = append(states, state)
+	}
+
+	return states, errors.Join(errs...)
+}
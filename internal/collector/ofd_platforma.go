@@ -0,0 +1,95 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/config"
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/domain"
+)
+
+func init() {
+	RegisterOFDAdapter("platforma", newPlatformaAdapter)
+}
+
+// platformaAdapter reads the Platforma OFD API. The whole device list with
+// state is returned by GET /kkt, authenticated with a bearer API key.
+type platformaAdapter struct {
+	transport *OFDTransport
+	header    http.Header
+}
+
+type platformaKKT struct {
+	RNM          string `json:"rnm"`
+	ZN           string `json:"zn"`
+	FN           string `json:"fn"`
+	FNEndDate    string `json:"fnEndDate"`
+	Status       string `json:"status"` // ACTIVE, INACTIVE, ERROR
+	LastSeen     string `json:"lastConnection"`
+	LastFD       int    `json:"lastFd"`
+	LastFDDate   string `json:"lastFdDate"`
+	NotSentCount int64  `json:"notSentCount"`
+	ShiftOpened  bool   `json:"shiftOpened"`
+	ShiftNumber  int    `json:"shiftNumber"`
+}
+
+// newPlatformaAdapter creates a Platforma OFD adapter
+func newPlatformaAdapter(cfg config.HTTPOFDConfig, transport *OFDTransport) (OFDAdapter, error) {
+	if cfg.APIKey == "" {
+		return nil, fmt.Errorf("platforma OFD requires api_key")
+	}
+	return &platformaAdapter{
+		transport: transport,
+		header:    http.Header{"Authorization": {"Bearer " + cfg.APIKey}},
+	}, nil
+}
+
+// Name returns the provider name
+func (a *platformaAdapter) Name() string {
+	return "platforma"
+}
+
+// FetchKKTs fetches the device list
+func (a *platformaAdapter) FetchKKTs(ctx context.Context) ([]OFDKKTState, error) {
+	var resp struct {
+		Items []platformaKKT `json:"items"`
+	}
+	if err := a.transport.Get(ctx, "/kkt", nil, a.header, &resp); err != nil {
+		return nil, err
+	}
+
+	var (
+		states []OFDKKTState
+		errs   []error
+	)
+	for _, kkt := range resp.Items {
+		state := OFDKKTState{
+			ID:                 kkt.RNM,
+			FactoryNumber:      kkt.ZN,
+			RegNumber:          kkt.RNM,
+			Status:             ofdKKTStatus(kkt.Status),
+			ShiftNumber:        kkt.ShiftNumber,
+			LastDocumentNumber: kkt.LastFD,
+			UnsentDocuments:    kkt.NotSentCount,
+			FiscalDrive:        domain.FiscalDrive{Number: kkt.FN},
+		}
+		if kkt.ShiftOpened {
+			state.ShiftStatus = domain.ShiftStatusOpen
+		}
+
+		var err1, err2, err3 error
+		state.LastSeen, err1 = parseOFDTime(kkt.LastSeen)
+		state.LastDocumentTime, err2 = parseOFDTime(kkt.LastFDDate)
+		state.FiscalDrive.ExpiryDate, err3 = parseOFDTime(kkt.FNEndDate)
+		if err := errors.Join(err1, err2, err3); err != nil {
+			errs = append(errs, fmt.Errorf("kkt %s: %w", kkt.RNM, err))
+			continue
+		}
+
+		states = append(states, state)
+	}
+
+	return states, errors.Join(errs...)
+}
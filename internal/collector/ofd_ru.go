@@ -0,0 +1,106 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/config"
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/domain"
+)
+
+func init() {
+	RegisterOFDAdapter("ofd_ru", newOFDRuAdapter)
+}
+
+// ofdRuAdapter reads the OFD.ru integration API. Devices of an organization
+// are listed with GET /inn/{inn}/kkts; the API key is passed as AuthToken.
+//
+// The API does not report the number of unsent documents, so the sync
+// status is derived from the last document time on the KKT and on the OFD.
+type ofdRuAdapter struct {
+	transport *OFDTransport
+	inn       string
+	token     string
+}
+
+type ofdRuKKT struct {
+	KktRegID                string `json:"KktRegId"`
+	SerialNumber            string `json:"SerialNumber"`
+	FnNumber                string `json:"FnNumber"`
+	FnEndDate               string `json:"FnEndDate"`
+	KktStatus               string `json:"KktStatus"`
+	LastDocOnKktDateTime    string `json:"LastDocOnKktDateTime"`
+	LastDocOnOfdDateTimeUtc string `json:"LastDocOnOfdDateTimeUtc"`
+	LastDocNumber           int    `json:"LastDocNumber"`
+}
+
+// newOFDRuAdapter creates an OFD.ru adapter
+func newOFDRuAdapter(cfg config.HTTPOFDConfig, transport *OFDTransport) (OFDAdapter, error) {
+	a := &ofdRuAdapter{
+		transport: transport,
+		inn:       cfg.Options["inn"],
+		token:     cfg.APIKey,
+	}
+	if a.inn == "" || a.token == "" {
+		return nil, fmt.Errorf("ofd_ru OFD requires options inn and api_key")
+	}
+	return a, nil
+}
+
+// Name returns the provider name
+func (a *ofdRuAdapter) Name() string {
+	return "ofd_ru"
+}
+
+// FetchKKTs fetches the device list of the organization
+func (a *ofdRuAdapter) FetchKKTs(ctx context.Context) ([]OFDKKTState, error) {
+	var resp struct {
+		Status string     `json:"Status"`
+		Data   []ofdRuKKT `json:"Data"`
+	}
+	path := "/inn/" + url.PathEscape(a.inn) + "/kkts"
+	if err := a.transport.Get(ctx, path, url.Values{"AuthToken": {a.token}}, nil, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Status != "" && resp.Status != "Success" {
+		return nil, fmt.Errorf("ofd_ru returned status %s", resp.Status)
+	}
+
+	var (
+		states []OFDKKTState
+		errs   []error
+	)
+	for _, kkt := range resp.Data {
+		state := OFDKKTState{
+			ID:                 kkt.KktRegID,
+			FactoryNumber:      kkt.SerialNumber,
+			RegNumber:          kkt.KktRegID,
+			Status:             ofdKKTStatus(kkt.KktStatus),
+			LastDocumentNumber: kkt.LastDocNumber,
+			FiscalDrive:        domain.FiscalDrive{Number: kkt.FnNumber},
+		}
+
+		onKKT, err1 := parseOFDTime(kkt.LastDocOnKktDateTime)
+		onOFD, err2 := parseOFDTimeIn(kkt.LastDocOnOfdDateTimeUtc, time.UTC)
+		expiry, err3 := parseOFDTime(kkt.FnEndDate)
+		if err := errors.Join(err1, err2, err3); err != nil {
+			errs = append(errs, fmt.Errorf("kkt %s: %w", kkt.KktRegID, err))
+			continue
+		}
+
+		state.LastDocumentTime = onKKT
+		state.LastSeen = onOFD
+		state.FiscalDrive.ExpiryDate = expiry
+		state.OFDSyncStatus = domain.OFDSyncStatusSynced
+		if onKKT.After(onOFD) {
+			state.OFDSyncStatus = domain.OFDSyncStatusPending
+		}
+
+		states = append(states, state)
+	}
+
+	return states, errors.Join(errs...)
+}
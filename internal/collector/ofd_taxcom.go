@@ -0,0 +1,203 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/config"
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/domain"
+)
+
+func init() {
+	RegisterOFDAdapter("taxcom", newTaxcomAdapter)
+}
+
+// taxcomAdapter reads the Taxcom OFD integrator API (v2).
+//
+// A session token is obtained with POST /Login using the integrator ID
+// header and the account login; the configured API key is the password.
+// Devices are listed per outlet (GET /OutletList, GET /KKTList) and
+// detailed with GET /KKTInfo by fiscal drive number.
+type taxcomAdapter struct {
+	transport    *OFDTransport
+	integratorID string
+	login        string
+	password     string
+
+	mu    sync.Mutex
+	token string
+}
+
+type taxcomRecords[T any] struct {
+	Records []T `json:"records"`
+}
+
+type taxcomOutlet struct {
+	ID string `json:"id"`
+}
+
+type taxcomKKT struct {
+	KKTRegNumber     string `json:"kktRegNumber"`
+	KKTFactoryNumber string `json:"kktFactoryNumber"`
+	FNFactoryNumber  string `json:"fnFactoryNumber"`
+}
+
+type taxcomKKTInfo struct {
+	KKTRegNumber          string `json:"kktRegNumber"`
+	CashdeskState         string `json:"cashdeskState"`    // Active, Expires, Expired, Inactive
+	ProblemIndicator      string `json:"problemIndicator"` // OK, Warning, Problem
+	LastDocumentNumber    int    `json:"lastDocumentNumber"`
+	LastDocumentDateTime  string `json:"lastDocumentDateTime"`
+	LastShiftNumber       int    `json:"lastShiftNumber"`
+	ShiftOpened           bool   `json:"shiftOpened"`
+	NotSentDocumentsCount int64  `json:"notSentDocumentsCount"`
+	FNEndDateTime         string `json:"fnEndDateTime"`
+	FNDocumentsMax        int    `json:"fnDocumentsMax"`
+	FNDocumentsUsed       int    `json:"fnDocumentsUsed"`
+}
+
+// newTaxcomAdapter creates a Taxcom adapter
+func newTaxcomAdapter(cfg config.HTTPOFDConfig, transport *OFDTransport) (OFDAdapter, error) {
+	a := &taxcomAdapter{
+		transport:    transport,
+		integratorID: cfg.Options["integrator_id"],
+		login:        cfg.Options["login"],
+		password:     cfg.APIKey,
+	}
+	if a.integratorID == "" || a.login == "" {
+		return nil, fmt.Errorf("taxcom OFD requires options integrator_id and login")
+	}
+	return a, nil
+}
+
+// Name returns the provider name
+func (a *taxcomAdapter) Name() string {
+	return "taxcom"
+}
+
+// FetchKKTs lists devices of all outlets and fetches their details
+func (a *taxcomAdapter) FetchKKTs(ctx context.Context) ([]OFDKKTState, error) {
+	var outlets taxcomRecords[taxcomOutlet]
+	if err := a.get(ctx, "/OutletList", nil, &outlets); err != nil {
+		return nil, err
+	}
+
+	var (
+		states []OFDKKTState
+		errs   []error
+	)
+	for _, outlet := range outlets.Records {
+		var kkts taxcomRecords[taxcomKKT]
+		if err := a.get(ctx, "/KKTList", url.Values{"id": {outlet.ID}}, &kkts); err != nil {
+			errs = append(errs, fmt.Errorf("outlet %s: %w", outlet.ID, err))
+			continue
+		}
+
+		for _, kkt := range kkts.Records {
+			state, err := a.fetchKKT(ctx, kkt)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("kkt %s: %w", kkt.KKTRegNumber, err))
+				continue
+			}
+			states = append(states, state)
+		}
+	}
+
+	return states, errors.Join(errs...)
+}
+
+// fetchKKT fetches the details of a device
+func (a *taxcomAdapter) fetchKKT(ctx context.Context, kkt taxcomKKT) (OFDKKTState, error) {
+	var info taxcomKKTInfo
+	if err := a.get(ctx, "/KKTInfo", url.Values{"fn": {kkt.FNFactoryNumber}}, &info); err != nil {
+		return OFDKKTState{}, err
+	}
+
+	state := OFDKKTState{
+		ID:                 kkt.KKTRegNumber,
+		FactoryNumber:      kkt.KKTFactoryNumber,
+		RegNumber:          kkt.KKTRegNumber,
+		Status:             taxcomStatus(info),
+		ShiftNumber:        info.LastShiftNumber,
+		LastDocumentNumber: info.LastDocumentNumber,
+		UnsentDocuments:    info.NotSentDocumentsCount,
+		FiscalDrive: domain.FiscalDrive{
+			Number:        kkt.FNFactoryNumber,
+			DocumentsMax:  info.FNDocumentsMax,
+			DocumentsUsed: info.FNDocumentsUsed,
+		},
+	}
+	if info.ShiftOpened {
+		state.ShiftStatus = domain.ShiftStatusOpen
+	}
+
+	var err error
+	if state.LastDocumentTime, err = parseOFDTime(info.LastDocumentDateTime); err != nil {
+		return OFDKKTState{}, err
+	}
+	if state.FiscalDrive.ExpiryDate, err = parseOFDTime(info.FNEndDateTime); err != nil {
+		return OFDKKTState{}, err
+	}
+	state.LastSeen = state.LastDocumentTime
+
+	return state, nil
+}
+
+// taxcomStatus maps cash desk state and problem indicator to KKTStatus
+func taxcomStatus(info taxcomKKTInfo) domain.KKTStatus {
+	switch {
+	case info.CashdeskState == "Inactive":
+		return domain.KKTStatusUnavailable
+	case info.CashdeskState == "Expired" || info.ProblemIndicator == "Problem":
+		return domain.KKTStatusError
+	default:
+		return domain.KKTStatusRunning
+	}
+}
+
+// get performs an authenticated request, logging in again once when the
+// session token has expired
+func (a *taxcomAdapter) get(ctx context.Context, path string, query url.Values, out interface{}) error {
+	for attempt := 0; ; attempt++ {
+		token, err := a.session(ctx, attempt > 0)
+		if err != nil {
+			return err
+		}
+
+		err = a.transport.Get(ctx, path, query, http.Header{"Session-Token": {token}}, out)
+		var httpErr *OFDHTTPError
+		if attempt == 0 && errors.As(err, &httpErr) && httpErr.StatusCode == http.StatusUnauthorized {
+			continue
+		}
+		return err
+	}
+}
+
+// session returns the session token, logging in when needed
+func (a *taxcomAdapter) session(ctx context.Context, renew bool) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.token != "" && !renew {
+		return a.token, nil
+	}
+
+	var resp struct {
+		SessionToken string `json:"sessionToken"`
+	}
+	body := map[string]string{"login": a.login, "password": a.password}
+	header := http.Header{"Integrator-Id": {a.integratorID}}
+	if err := a.transport.Do(ctx, http.MethodPost, "/Login", nil, header, body, &resp); err != nil {
+		return "", fmt.Errorf("taxcom login failed: %w", err)
+	}
+	if resp.SessionToken == "" {
+		return "", fmt.Errorf("taxcom login returned no session token")
+	}
+
+	a.token = resp.SessionToken
+	return a.token, nil
+}
@@ -0,0 +1,119 @@
+package collector
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/config"
+)
+
+// rateLimiter is a token bucket refilled at a constant rate. It can also be
+// paused until a point in time, e.g. when the server asks to slow down.
+type rateLimiter struct {
+	mu          sync.Mutex
+	rate        float64 // tokens per second, 0 means unlimited
+	burst       float64
+	tokens      float64
+	last        time.Time
+	pausedUntil time.Time
+	now         func() time.Time
+}
+
+// newRateLimiter creates a full token bucket
+func newRateLimiter(ratePerSecond float64, burst int) *rateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{
+		rate:   ratePerSecond,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		now:    time.Now,
+	}
+}
+
+// Wait blocks until a token is available and returns the time spent waiting
+func (l *rateLimiter) Wait(ctx context.Context) (time.Duration, error) {
+	var waited time.Duration
+	for {
+		delay := l.reserve()
+		if delay <= 0 {
+			return waited, nil
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return waited, ctx.Err()
+		case <-timer.C:
+			waited += delay
+		}
+	}
+}
+
+// reserve takes a token and returns zero, or returns how long to wait
+// before trying again
+func (l *rateLimiter) reserve() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if now.Before(l.pausedUntil) {
+		return l.pausedUntil.Sub(now)
+	}
+	if l.rate <= 0 {
+		return 0
+	}
+
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+	}
+	l.last = now
+
+	if l.tokens >= 1 {
+		l.tokens--
+		return 0
+	}
+	return time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+}
+
+var (
+	sharedLimitersMu sync.Mutex
+	sharedLimiters   = make(map[string]*rateLimiter)
+)
+
+// sharedRateLimiter returns the limiter of an OFD account, as identified by
+// config.HTTPOFDConfig.Account, creating it on first use. Collectors of the
+// same account share the bucket, so the limit and the pauses requested by
+// the server hold for the account; config validation ensures they agree on
+// the settings.
+func sharedRateLimiter(account string, cfg config.OFDRateLimitConfig) *rateLimiter {
+	sharedLimitersMu.Lock()
+	defer sharedLimitersMu.Unlock()
+
+	l, ok := sharedLimiters[account]
+	if !ok {
+		var rate float64
+		if cfg.RequestsPerSecond != nil {
+			rate = *cfg.RequestsPerSecond
+		}
+		l = newRateLimiter(rate, cfg.Burst)
+		sharedLimiters[account] = l
+	}
+	return l
+}
+
+// PauseUntil holds all requests until t
+func (l *rateLimiter) PauseUntil(t time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if t.After(l.pausedUntil) {
+		l.pausedUntil = t
+	}
+}
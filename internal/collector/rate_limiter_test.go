@@ -0,0 +1,76 @@
+package collector
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/config"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	l := newRateLimiter(2, 2)
+	l.now = func() time.Time { return now }
+
+	// The burst is available immediately
+	for i := 0; i < 2; i++ {
+		if delay := l.reserve(); delay != 0 {
+			t.Fatalf("Expected token %d without delay, got %v", i, delay)
+		}
+	}
+	if delay := l.reserve(); delay != 500*time.Millisecond {
+		t.Errorf("Expected 500ms delay with an empty bucket, got %v", delay)
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if delay := l.reserve(); delay != 0 {
+		t.Errorf("Expected a refilled token, got delay %v", delay)
+	}
+
+	l.PauseUntil(now.Add(10 * time.Second))
+	now = now.Add(5 * time.Second)
+	if delay := l.reserve(); delay != 5*time.Second {
+		t.Errorf("Expected 5s delay while paused, got %v", delay)
+	}
+
+	now = now.Add(5 * time.Second)
+	if delay := l.reserve(); delay != 0 {
+		t.Errorf("Expected token after the pause, got delay %v", delay)
+	}
+}
+
+func TestRateLimiter_Unlimited(t *testing.T) {
+	l := newRateLimiter(0, 0)
+	for i := 0; i < 100; i++ {
+		if delay := l.reserve(); delay != 0 {
+			t.Fatalf("Expected no delay without a rate, got %v", delay)
+		}
+	}
+}
+
+func TestSharedRateLimiter(t *testing.T) {
+	rate := 1.0
+	cfg := config.OFDRateLimitConfig{RequestsPerSecond: &rate, Burst: 1}
+	account := func(url, apiKey string) string {
+		return config.HTTPOFDConfig{Provider: "taxcom", URL: url, APIKey: apiKey}.Account()
+	}
+
+	a := sharedRateLimiter(account("https://shared.example.ru/api", "key-1"), cfg)
+	b := sharedRateLimiter(account("https://shared.example.ru/api", "key-1"), cfg)
+	if a != b {
+		t.Error("Expected collectors of the same account to share the limiter")
+	}
+	if c := sharedRateLimiter(account("https://other.example.ru/api", "key-1"), cfg); c == a {
+		t.Error("Expected another endpoint to get its own limiter")
+	}
+	if c := sharedRateLimiter(account("https://shared.example.ru/api", "key-2"), cfg); c == a {
+		t.Error("Expected another account to get its own limiter")
+	}
+
+	if delay := a.reserve(); delay != 0 {
+		t.Fatalf("Expected the first token without delay, got %v", delay)
+	}
+	if delay := b.reserve(); delay <= 0 {
+		t.Errorf("Expected the shared bucket to be empty, got delay %v", delay)
+	}
+}
@@ -0,0 +1,60 @@
+package collector
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/config"
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/pkg/logger"
+)
+
+// Factory creates a collector from its instance configuration
+type Factory func(cfg config.CollectorConfig, log *logger.Logger) (Collector, error)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = make(map[string]Factory)
+)
+
+// Register registers a collector factory under a type name
+func Register(typ string, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+
+	if _, exists := factories[typ]; exists {
+		panic(fmt.Sprintf("collector type %q already registered", typ))
+	}
+	factories[typ] = factory
+}
+
+// Types returns the names of registered collector types
+func Types() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+
+	types := make([]string, 0, len(factories))
+	for typ := range factories {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// New creates a collector of the configured type
+func New(cfg config.CollectorConfig, log *logger.Logger) (Collector, error) {
+	factoriesMu.RLock()
+	factory, ok := factories[cfg.Type]
+	factoriesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown collector type %q for %s (available: %s)",
+			cfg.Type, cfg.Name, strings.Join(Types(), ", "))
+	}
+
+	c, err := factory(cfg, log)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create collector %s: %w", cfg.Name, err)
+	}
+	return c, nil
+}
@@ -0,0 +1,57 @@
+package collector
+
+import (
+	"testing"
+
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/config"
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/pkg/logger"
+)
+
+func TestNew(t *testing.T) {
+	log := logger.New("error", "json")
+
+	fileLog, err := config.NewCollectorConfig("file_log", config.FileLogConfig{
+		Name:    "store-1",
+		Enabled: true,
+		Path:    "/var/log/kkt/*.log",
+	})
+	if err != nil {
+		t.Fatalf("NewCollectorConfig failed: %v", err)
+	}
+
+	c, err := New(fileLog, log)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if _, ok := c.(*FileLogCollector); !ok {
+		t.Errorf("Expected *FileLogCollector, got %T", c)
+	}
+	if c.Name() != "store-1" {
+		t.Errorf("Expected collector name store-1, got %s", c.Name())
+	}
+
+	tests := []struct {
+		name string
+		cfg  config.CollectorConfig
+	}{
+		{name: "unknown type", cfg: config.CollectorConfig{Type: "snmp", Name: "snmp", Enabled: true}},
+		{name: "invalid settings", cfg: config.CollectorConfig{Type: "http_ofd", Name: "ofd", Enabled: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(tt.cfg, log); err == nil {
+				t.Error("Expected error")
+			}
+		})
+	}
+}
+
+func TestRegister_Duplicate(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected panic on duplicate registration")
+		}
+	}()
+	Register("file_log", newFileLogFromConfig)
+}
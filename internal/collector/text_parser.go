@@ -0,0 +1,305 @@
+package collector
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/config"
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/domain"
+)
+
+// defaultTimeLayout is used for the "time" capture when no layout is configured
+const defaultTimeLayout = "2006-01-02 15:04:05"
+
+// textPattern is a compiled text log pattern
+type textPattern struct {
+	name     string
+	event    string
+	re       *regexp.Regexp
+	defaults map[string]string
+}
+
+// textLineParser parses plain-text log lines using configured regex patterns.
+//
+// Named capture groups are mapped to event fields: kkt_id, time,
+// document_type, document_number, shift_number, amount, operation_type,
+// taxation_system, fiscal_sign, error_code, error_type, severity, message,
+// status, shift_status, ofd_sync_status, fd_memory_usage, fn_serial,
+// fn_expiry_date, fn_documents_max, fn_documents_used, unsent_documents,
+// oldest_unsent_time. Pattern defaults supply values for fields that have no
+// capture group. The first matching pattern wins, lines matching no pattern
+// are ignored.
+type textLineParser struct {
+	patterns   []textPattern
+	timeLayout string
+}
+
+// newTextLineParser compiles the configured patterns
+func newTextLineParser(cfg config.FileLogConfig) (*textLineParser, error) {
+	p := &textLineParser{timeLayout: cfg.TimeLayout}
+	if p.timeLayout == "" {
+		p.timeLayout = defaultTimeLayout
+	}
+
+	for _, pc := range cfg.Patterns {
+		re, err := regexp.Compile(pc.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex in pattern %q: %w", pc.Name, err)
+		}
+		p.patterns = append(p.patterns, textPattern{
+			name:     pc.Name,
+			event:    pc.Event,
+			re:       re,
+			defaults: pc.Defaults,
+		})
+	}
+
+	return p, nil
+}
+
+// newLineParser creates the parser for the configured log format
+func newLineParser(cfg config.FileLogConfig) (lineParser, error) {
+	switch cfg.Format {
+	case "", "json":
+		return jsonLineParser{}, nil
+	case "text":
+		return newTextLineParser(cfg)
+	default:
+		return nil, fmt.Errorf("unsupported log format: %s", cfg.Format)
+	}
+}
+
+// Parse matches the line against the patterns
+func (p *textLineParser) Parse(line []byte) (logEvent, bool, error) {
+	for i := range p.patterns {
+		pattern := &p.patterns[i]
+
+		match := pattern.re.FindSubmatch(line)
+		if match == nil {
+			continue
+		}
+
+		fields := make(map[string]string, len(pattern.defaults)+len(match))
+		for k, v := range pattern.defaults {
+			fields[k] = v
+		}
+		for idx, name := range pattern.re.SubexpNames() {
+			if name != "" && len(match[idx]) > 0 {
+				fields[name] = string(match[idx])
+			}
+		}
+
+		ev, err := p.buildEvent(pattern, fields, string(line))
+		if err != nil {
+			return logEvent{}, false, fmt.Errorf("pattern %q: %w", pattern.name, err)
+		}
+		return ev, true, nil
+	}
+
+	return logEvent{}, false, nil
+}
+
+// buildEvent converts captured fields into an event
+func (p *textLineParser) buildEvent(pattern *textPattern, fields map[string]string, line string) (logEvent, error) {
+	ev := logEvent{
+		Kind:  pattern.event,
+		KKTID: fields["kkt_id"],
+	}
+	if ev.KKTID == "" {
+		return logEvent{}, fmt.Errorf("no kkt_id captured")
+	}
+
+	if ts, ok := fields["time"]; ok {
+		t, err := time.ParseInLocation(p.timeLayout, ts, time.Local)
+		if err != nil {
+			return logEvent{}, fmt.Errorf("invalid time %q: %w", ts, err)
+		}
+		ev.Time = t
+	}
+
+	var err error
+	switch pattern.event {
+	case logEventDocument:
+		ev.Document, err = buildDocument(fields)
+	case logEventError:
+		ev.Error, err = buildError(fields, line)
+	case logEventStatus:
+		ev.Status, err = buildStatus(fields, p.timeLayout)
+	default:
+		err = fmt.Errorf("unknown event %q", pattern.event)
+	}
+	if err != nil {
+		return logEvent{}, err
+	}
+
+	ev.normalize()
+	return ev, nil
+}
+
+// buildDocument builds a fiscal document from captured fields
+func buildDocument(fields map[string]string) (*domain.FiscalDocument, error) {
+	doc := &domain.FiscalDocument{
+		Type:       domain.DocumentTypeReceipt,
+		FiscalSign: fields["fiscal_sign"],
+	}
+
+	var err error
+	if v, ok := fields["document_type"]; ok {
+		if err = doc.Type.UnmarshalText([]byte(v)); err != nil {
+			return nil, fmt.Errorf("invalid document_type: %w", err)
+		}
+	}
+	if v, ok := fields["document_number"]; ok {
+		if doc.DocumentNumber, err = strconv.Atoi(v); err != nil {
+			return nil, fmt.Errorf("invalid document_number: %w", err)
+		}
+	}
+	if v, ok := fields["shift_number"]; ok {
+		if doc.ShiftNumber, err = strconv.Atoi(v); err != nil {
+			return nil, fmt.Errorf("invalid shift_number: %w", err)
+		}
+	}
+	if v, ok := fields["amount"]; ok {
+		if doc.Amount, err = parseDecimal(v); err != nil {
+			return nil, fmt.Errorf("invalid amount: %w", err)
+		}
+	}
+	if v, ok := fields["operation_type"]; ok {
+		if err = doc.OperationType.UnmarshalText([]byte(v)); err != nil {
+			return nil, fmt.Errorf("invalid operation_type: %w", err)
+		}
+	}
+	if v, ok := fields["taxation_system"]; ok {
+		if err = doc.TaxationSystem.UnmarshalText([]byte(v)); err != nil {
+			return nil, fmt.Errorf("invalid taxation_system: %w", err)
+		}
+	}
+
+	return doc, nil
+}
+
+// buildError builds a KKT error from captured fields
+func buildError(fields map[string]string, line string) (*domain.KKTError, error) {
+	kktErr := &domain.KKTError{
+		ErrorCode: fields["error_code"],
+		ErrorType: domain.ErrorTypeSoftware,
+		Severity:  domain.ErrorSeverityError,
+		Message:   fields["message"],
+	}
+	if kktErr.Message == "" {
+		kktErr.Message = strings.TrimSpace(line)
+	}
+
+	if v, ok := fields["error_type"]; ok {
+		if err := kktErr.ErrorType.UnmarshalText([]byte(v)); err != nil {
+			return nil, fmt.Errorf("invalid error_type: %w", err)
+		}
+	}
+	if v, ok := fields["severity"]; ok {
+		if err := kktErr.Severity.UnmarshalText([]byte(v)); err != nil {
+			return nil, fmt.Errorf("invalid severity: %w", err)
+		}
+	}
+
+	return kktErr, nil
+}
+
+// buildStatus builds a status update from captured fields
+func buildStatus(fields map[string]string, timeLayout string) (*statusEvent, error) {
+	st := &statusEvent{}
+
+	if v, ok := fields["status"]; ok {
+		var status domain.KKTStatus
+		if err := status.UnmarshalText([]byte(v)); err != nil {
+			return nil, fmt.Errorf("invalid status: %w", err)
+		}
+		st.Status = &status
+	}
+	if v, ok := fields["shift_status"]; ok {
+		var shift domain.ShiftStatus
+		if err := shift.UnmarshalText([]byte(v)); err != nil {
+			return nil, fmt.Errorf("invalid shift_status: %w", err)
+		}
+		st.ShiftStatus = &shift
+	}
+	if v, ok := fields["ofd_sync_status"]; ok {
+		var sync domain.OFDSyncStatus
+		if err := sync.UnmarshalText([]byte(v)); err != nil {
+			return nil, fmt.Errorf("invalid ofd_sync_status: %w", err)
+		}
+		st.OFDSyncStatus = &sync
+	}
+	if v, ok := fields["fd_memory_usage"]; ok {
+		usage, err := parseDecimal(strings.TrimSuffix(v, "%"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid fd_memory_usage: %w", err)
+		}
+		st.FDMemoryUsage = &usage
+	}
+	if v, ok := fields["unsent_documents"]; ok {
+		n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid unsent_documents: %w", err)
+		}
+		st.UnsentDocuments = &n
+	}
+	if v, ok := fields["oldest_unsent_time"]; ok {
+		t, err := time.ParseInLocation(timeLayout, v, time.Local)
+		if err != nil {
+			return nil, fmt.Errorf("invalid oldest_unsent_time: %w", err)
+		}
+		st.OldestUnsentTime = &t
+	}
+
+	fd, err := buildFiscalDrive(fields)
+	if err != nil {
+		return nil, err
+	}
+	st.FiscalDrive = fd
+
+	return st, nil
+}
+
+// buildFiscalDrive builds a fiscal drive update from captured fields, nil
+// when no fiscal drive field was captured
+func buildFiscalDrive(fields map[string]string) (*domain.FiscalDrive, error) {
+	fd := &domain.FiscalDrive{Number: fields["fn_serial"]}
+	found := fd.Number != ""
+
+	if v, ok := fields["fn_expiry_date"]; ok {
+		expiry, err := time.ParseInLocation("2006-01-02", strings.TrimSpace(v), time.Local)
+		if err != nil {
+			return nil, fmt.Errorf("invalid fn_expiry_date: %w", err)
+		}
+		fd.ExpiryDate = expiry
+		found = true
+	}
+	for name, dst := range map[string]*int{
+		"fn_documents_max":  &fd.DocumentsMax,
+		"fn_documents_used": &fd.DocumentsUsed,
+	} {
+		v, ok := fields[name]
+		if !ok {
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", name, err)
+		}
+		*dst = n
+		found = true
+	}
+
+	if !found {
+		return nil, nil
+	}
+	return fd, nil
+}
+
+// parseDecimal parses a number that may use a decimal comma
+func parseDecimal(s string) (float64, error) {
+	return strconv.ParseFloat(strings.ReplaceAll(strings.TrimSpace(s), ",", "."), 64)
+}
@@ -0,0 +1,108 @@
+package collector
+
+import (
+	"container/heap"
+	"time"
+)
+
+// unsentDocument is a document not acknowledged by the OFD
+type unsentDocument struct {
+	id     string
+	sentAt time.Time
+	index  int // position in the heap
+}
+
+// unsentQueue holds the documents not acknowledged by the OFD by document
+// ID, ordered by their first send time so that the oldest is found in
+// constant time and added or removed in O(log n)
+type unsentQueue struct {
+	docs map[string]*unsentDocument
+	heap unsentHeap
+}
+
+// newUnsentQueue creates an empty queue
+func newUnsentQueue() *unsentQueue {
+	return &unsentQueue{docs: make(map[string]*unsentDocument)}
+}
+
+// Len returns the number of unsent documents
+func (q *unsentQueue) Len() int {
+	return len(q.heap)
+}
+
+// SentAt returns the first send time of a document
+func (q *unsentQueue) SentAt(id string) (time.Time, bool) {
+	doc, ok := q.docs[id]
+	if !ok {
+		return time.Time{}, false
+	}
+	return doc.sentAt, true
+}
+
+// Add tracks a document sent at sentAt
+func (q *unsentQueue) Add(id string, sentAt time.Time) {
+	doc := &unsentDocument{id: id, sentAt: sentAt}
+	q.docs[id] = doc
+	heap.Push(&q.heap, doc)
+}
+
+// Remove stops tracking a document
+func (q *unsentQueue) Remove(id string) {
+	doc, ok := q.docs[id]
+	if !ok {
+		return
+	}
+	delete(q.docs, id)
+	heap.Remove(&q.heap, doc.index)
+}
+
+// Oldest returns the first send time of the oldest document, zero when
+// there are none
+func (q *unsentQueue) Oldest() time.Time {
+	if len(q.heap) == 0 {
+		return time.Time{}
+	}
+	return q.heap[0].sentAt
+}
+
+// DropOldest stops tracking the oldest document
+func (q *unsentQueue) DropOldest() {
+	if len(q.heap) == 0 {
+		return
+	}
+	doc := heap.Pop(&q.heap).(*unsentDocument)
+	delete(q.docs, doc.id)
+}
+
+// Clear stops tracking all documents
+func (q *unsentQueue) Clear() {
+	clear(q.docs)
+	q.heap = nil
+}
+
+// unsentHeap is a min-heap of documents by send time
+type unsentHeap []*unsentDocument
+
+func (h unsentHeap) Len() int           { return len(h) }
+func (h unsentHeap) Less(i, j int) bool { return h[i].sentAt.Before(h[j].sentAt) }
+
+func (h unsentHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *unsentHeap) Push(x interface{}) {
+	doc := x.(*unsentDocument)
+	doc.index = len(*h)
+	*h = append(*h, doc)
+}
+
+func (h *unsentHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	doc := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return doc
+}
@@ -0,0 +1,206 @@
+package config
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// CollectorsConfig is the list of collector instances. It is declared either
+// as a list of instances with a type:
+//
+//	collectors:
+//	  - type: file_log
+//	    name: store-1
+//	    path: /var/log/kkt/*.log
+//
+// or as a mapping from type to a single instance or a list of instances:
+//
+//	collectors:
+//	  file_log:
+//	    path: /var/log/kkt/*.log
+type CollectorsConfig []CollectorConfig
+
+// CollectorConfig is a collector instance. Settings specific to the type are
+// kept undecoded and read by the collector factory with Decode.
+type CollectorConfig struct {
+	Type    string `yaml:"type"`
+	Name    string `yaml:"name"`
+	Enabled bool   `yaml:"enabled"`
+
+	settings *yaml.Node
+}
+
+// NewCollectorConfig creates a collector instance of the given type from
+// typed settings, e.g. FileLogConfig
+func NewCollectorConfig(typ string, settings interface{}) (CollectorConfig, error) {
+	var node yaml.Node
+	if err := node.Encode(settings); err != nil {
+		return CollectorConfig{}, fmt.Errorf("failed to encode %s settings: %w", typ, err)
+	}
+
+	var c CollectorConfig
+	if err := node.Decode(&c); err != nil {
+		return CollectorConfig{}, err
+	}
+	c.Type = typ
+	return c, nil
+}
+
+// UnmarshalYAML decodes the common fields and keeps the settings
+func (c *CollectorConfig) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: collector must be a mapping", node.Line)
+	}
+
+	type common CollectorConfig
+	var decoded common
+	if err := node.Decode(&decoded); err != nil {
+		return err
+	}
+
+	*c = CollectorConfig(decoded)
+	c.settings = node
+	return nil
+}
+
+// Decode decodes the instance settings into out
+func (c CollectorConfig) Decode(out interface{}) error {
+	if c.settings == nil {
+		return nil
+	}
+	if err := c.settings.Decode(out); err != nil {
+		return fmt.Errorf("invalid %s settings: %w", c.Type, err)
+	}
+	return nil
+}
+
+// UnmarshalYAML accepts a list of typed instances or a mapping by type
+func (l *CollectorsConfig) UnmarshalYAML(node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.SequenceNode:
+		return node.Decode((*[]CollectorConfig)(l))
+	case yaml.MappingNode:
+	default:
+		return fmt.Errorf("line %d: collectors must be a list or a mapping", node.Line)
+	}
+
+	var instances []CollectorConfig
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		typ, value := node.Content[i].Value, node.Content[i+1]
+
+		var group []CollectorConfig
+		switch value.Kind {
+		case yaml.MappingNode:
+			group = make([]CollectorConfig, 1)
+			if err := value.Decode(&group[0]); err != nil {
+				return err
+			}
+		case yaml.SequenceNode:
+			if err := value.Decode(&group); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("line %d: %s must be a collector or a list of collectors", value.Line, typ)
+		}
+
+		for _, instance := range group {
+			if instance.Type != "" && instance.Type != typ {
+				return fmt.Errorf("collector %s declared under %s has type %s", instance.Name, typ, instance.Type)
+			}
+			instance.Type = typ
+			instances = append(instances, instance)
+		}
+	}
+
+	*l = instances
+	return nil
+}
+
+// validate names the instances and validates the settings of built-in types
+// against the exporter windows. Settings of other types are validated by
+// their factories.
+func (l CollectorsConfig) validate(exporter ExporterConfig) error {
+	counts := make(map[string]int)
+	for _, c := range l {
+		counts[c.Type]++
+	}
+
+	names := make(map[string]bool)
+	stateFiles := make(map[string]string)
+	rateLimits := make(map[string]HTTPOFDConfig)
+	for i := range l {
+		c := &l[i]
+		if c.Type == "" {
+			return fmt.Errorf("collector #%d: type is required", i+1)
+		}
+		if c.Name == "" {
+			if counts[c.Type] > 1 {
+				return fmt.Errorf("collector #%d: name is required when several %s instances are configured", i+1, c.Type)
+			}
+			c.Name = c.Type
+		}
+		if names[c.Name] {
+			return fmt.Errorf("duplicate collector name: %s", c.Name)
+		}
+		names[c.Name] = true
+
+		if !c.Enabled {
+			continue
+		}
+
+		switch c.Type {
+		case "file_log":
+			var fl FileLogConfig
+			if err := c.DecodeFileLog(&fl); err != nil {
+				return err
+			}
+			if fl.StateFile != "" {
+				if other, ok := stateFiles[fl.StateFile]; ok {
+					return fmt.Errorf("file_log %s: state_file is already used by %s", c.Name, other)
+				}
+				stateFiles[fl.StateFile] = c.Name
+			}
+			if fl.StaleAfter > exporter.DeleteAfter {
+				return fmt.Errorf("file_log %s: stale_after %v exceeds exporter delete_after %v", c.Name, fl.StaleAfter, exporter.DeleteAfter)
+			}
+		case "http_ofd":
+			var ofd HTTPOFDConfig
+			if err := c.DecodeHTTPOFD(&ofd); err != nil {
+				return err
+			}
+			// Instances of the same OFD account share one rate limiter
+			account := ofd.Account()
+			if other, ok := rateLimits[account]; ok && !other.RateLimit.Equal(ofd.RateLimit) {
+				return fmt.Errorf("http_ofd %s: rate_limit differs from %s, which uses the same OFD account", c.Name, other.Name)
+			}
+			rateLimits[account] = ofd
+		}
+	}
+
+	return nil
+}
+
+// DecodeFileLog decodes and validates file log settings
+func (c CollectorConfig) DecodeFileLog(out *FileLogConfig) error {
+	if err := c.Decode(out); err != nil {
+		return err
+	}
+	out.Name, out.Enabled = c.Name, c.Enabled
+	if err := out.Validate(); err != nil {
+		return fmt.Errorf("file_log %s: %w", c.Name, err)
+	}
+	return nil
+}
+
+// DecodeHTTPOFD decodes and validates HTTP OFD settings
+func (c CollectorConfig) DecodeHTTPOFD(out *HTTPOFDConfig) error {
+	if err := c.Decode(out); err != nil {
+		return err
+	}
+	out.Name, out.Enabled = c.Name, c.Enabled
+	if err := out.Validate(); err != nil {
+		return fmt.Errorf("http_ofd %s: %w", c.Name, err)
+	}
+	return nil
+}
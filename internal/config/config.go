@@ -3,6 +3,8 @@ package config
 import (
 	"fmt"
 	"os"
+	"regexp"
+	"strings"
 	"time"
 
 	"gopkg.in/yaml.v3"
@@ -12,6 +14,10 @@ import (
 type Config struct {
 	Server     ServerConfig     `yaml:"server"`
 	Collectors CollectorsConfig `yaml:"collectors"`
+	Exporter   ExporterConfig   `yaml:"exporter"`
+	Inventory  InventoryConfig  `yaml:"inventory"`
+	State      StateConfig      `yaml:"state"`
+	History    HistoryConfig    `yaml:"history"`
 	AI         AIConfig         `yaml:"ai"`
 	Logging    LoggingConfig    `yaml:"logging"`
 }
@@ -21,49 +27,165 @@ type ServerConfig struct {
 	Port        int    `yaml:"port"`
 	MetricsPath string `yaml:"metrics_path"`
 	APIPath     string `yaml:"api_path"`
+	// APIToken is the bearer token of API requests that change state;
+	// empty disables them
+	APIToken string `yaml:"api_token"`
 }
 
-// CollectorsConfig represents collectors configuration
-type CollectorsConfig struct {
-	FileLog FileLogConfig `yaml:"file_log"`
-	HTTPOFD HTTPOFDConfig `yaml:"http_ofd"`
+// ExporterConfig represents Prometheus exporter configuration
+type ExporterConfig struct {
+	// StaleAfter is the silence after which a device is reported unavailable
+	StaleAfter time.Duration `yaml:"stale_after"`
+	// DeleteAfter is the silence after which the series of a device are removed
+	DeleteAfter time.Duration `yaml:"delete_after"`
+	// GoCollector exports Go runtime metrics (go_*)
+	GoCollector bool `yaml:"go_collector"`
+	// ProcessCollector exports process metrics (process_*)
+	ProcessCollector bool `yaml:"process_collector"`
+	// NativeHistograms exports histograms also as native histograms
+	NativeHistograms bool `yaml:"native_histograms"`
+}
+
+// StateConfig represents device state store configuration
+type StateConfig struct {
+	// SnapshotFile is where the state is saved; empty disables snapshots
+	SnapshotFile string `yaml:"snapshot_file"`
+	// SnapshotInterval is how often the state is saved
+	SnapshotInterval time.Duration `yaml:"snapshot_interval"`
+	// ErrorsLimit is the number of recent KKT errors and of resolved
+	// tracked errors kept
+	ErrorsLimit int `yaml:"errors_limit"`
+	// AutoResolveCycles is the number of consecutive healthy collection cycles
+	// of a device with new events after which its open errors are resolved
+	AutoResolveCycles int `yaml:"auto_resolve_cycles"`
+}
+
+// HistoryConfig represents historical storage configuration
+type HistoryConfig struct {
+	// Dir is the directory of the history segments; empty disables history
+	Dir string `yaml:"dir"`
+	// Retention is how long samples and errors are kept
+	Retention time.Duration `yaml:"retention"`
+	// DownsampleAfter is the age after which metrics samples are downsampled
+	DownsampleAfter time.Duration `yaml:"downsample_after"`
+	// DownsampleInterval is the interval of downsampled metrics samples
+	DownsampleInterval time.Duration `yaml:"downsample_interval"`
 }
 
 // FileLogConfig represents file log collector configuration
 type FileLogConfig struct {
+	Name         string        `yaml:"name"`
 	Enabled      bool          `yaml:"enabled"`
 	Path         string        `yaml:"path"`
 	Format       string        `yaml:"format"`
 	PollInterval time.Duration `yaml:"poll_interval"`
+	StateFile    string        `yaml:"state_file"`
+	TimeLayout   string        `yaml:"time_layout"`
+	Patterns     []LogPattern  `yaml:"patterns"`
+	// StaleAfter overrides exporter.stale_after for the devices of the
+	// instance, e.g. for registers that log nothing at night
+	StaleAfter time.Duration `yaml:"stale_after"`
+}
+
+// LogPattern represents a regex pattern of the text log format
+type LogPattern struct {
+	Name     string            `yaml:"name"`
+	Event    string            `yaml:"event"`
+	Regex    string            `yaml:"regex"`
+	Defaults map[string]string `yaml:"defaults"`
 }
 
 // HTTPOFDConfig represents HTTP OFD collector configuration
 type HTTPOFDConfig struct {
-	Enabled      bool          `yaml:"enabled"`
-	URL          string        `yaml:"url"`
-	APIKey       string        `yaml:"api_key"`
-	PollInterval time.Duration `yaml:"poll_interval"`
-	Timeout      time.Duration `yaml:"timeout"`
+	Name         string             `yaml:"name"`
+	Enabled      bool               `yaml:"enabled"`
+	Provider     string             `yaml:"provider"`
+	URL          string             `yaml:"url"`
+	APIKey       string             `yaml:"api_key"`
+	PollInterval time.Duration      `yaml:"poll_interval"`
+	Timeout      time.Duration      `yaml:"timeout"`
+	Options      map[string]string  `yaml:"options"`
+	Endpoints    map[string]string  `yaml:"endpoints"`
+	Fields       map[string]string  `yaml:"fields"`
+	Retry        OFDRetryConfig     `yaml:"retry"`
+	RateLimit    OFDRateLimitConfig `yaml:"rate_limit"`
+}
+
+// OFDRetryConfig represents retry settings of OFD API requests
+type OFDRetryConfig struct {
+	MaxAttempts    int           `yaml:"max_attempts"`
+	InitialBackoff time.Duration `yaml:"initial_backoff"`
+	MaxBackoff     time.Duration `yaml:"max_backoff"`
+	Jitter         float64       `yaml:"jitter"`
+}
+
+// OFDRateLimitConfig represents the token bucket limiting OFD API requests.
+// RequestsPerSecond is nil when unset; an explicit 0 disables the limit.
+type OFDRateLimitConfig struct {
+	RequestsPerSecond *float64 `yaml:"requests_per_second"`
+	Burst             int      `yaml:"burst"`
+}
+
+// ofdDefaultURLs are the API base URLs of the built-in operator providers
+var ofdDefaultURLs = map[string]string{
+	"taxcom":    "https://api-lk-ofd.taxcom.ru/API/v2",
+	"platforma": "https://lk.platformaofd.ru/api/v1",
+	"ofd_ru":    "https://ofd.ru/api/integration/v1",
+	"kontur":    "https://ofd-api.kontur.ru/v1",
+}
+
+// Resolved returns the settings with the provider defaulted to generic and
+// the URL to the API base URL of the provider, without a trailing slash.
+// Config validation and the OFD adapters use the resolved values.
+func (c HTTPOFDConfig) Resolved() HTTPOFDConfig {
+	if c.Provider == "" {
+		c.Provider = "generic"
+	}
+	if c.URL == "" {
+		c.URL = ofdDefaultURLs[c.Provider]
+	}
+	c.URL = strings.TrimRight(c.URL, "/")
+	return c
+}
+
+// Account identifies the OFD account of the instance by the resolved
+// provider, URL and API key. Instances of one account share a rate limiter.
+func (c HTTPOFDConfig) Account() string {
+	r := c.Resolved()
+	return r.Provider + "\xff" + r.URL + "\xff" + r.APIKey
+}
+
+// Equal reports whether both settings limit requests the same way
+func (c OFDRateLimitConfig) Equal(other OFDRateLimitConfig) bool {
+	if (c.RequestsPerSecond == nil) != (other.RequestsPerSecond == nil) {
+		return false
+	}
+	if c.RequestsPerSecond != nil && *c.RequestsPerSecond != *other.RequestsPerSecond {
+		return false
+	}
+	return c.Burst == other.Burst
 }
 
 // AIConfig represents AI subsystem configuration
 type AIConfig struct {
-	Provider         string                  `yaml:"provider"`
-	ErrorClustering  ErrorClusteringConfig   `yaml:"error_clustering"`
-	AlertAdvisor     AlertAdvisorConfig      `yaml:"alert_advisor"`
+	Provider        string                `yaml:"provider"`
+	ErrorClustering ErrorClusteringConfig `yaml:"error_clustering"`
+	AlertAdvisor    AlertAdvisorConfig    `yaml:"alert_advisor"`
 }
 
 // ErrorClusteringConfig represents error clustering configuration
 type ErrorClusteringConfig struct {
-	Enabled         bool    `yaml:"enabled"`
-	MinClusterSize  int     `yaml:"min_cluster_size"`
-	SimilarityThreshold float64 `yaml:"similarity_threshold"`
+	Enabled             bool          `yaml:"enabled"`
+	MinClusterSize      int           `yaml:"min_cluster_size"`
+	SimilarityThreshold float64       `yaml:"similarity_threshold"`
+	Interval            time.Duration `yaml:"interval"`
 }
 
 // AlertAdvisorConfig represents alert advisor configuration
 type AlertAdvisorConfig struct {
-	Enabled bool `yaml:"enabled"`
+	Enabled        bool          `yaml:"enabled"`
 	LookbackPeriod time.Duration `yaml:"lookback_period"`
+	Interval       time.Duration `yaml:"interval"`
 }
 
 // LoggingConfig represents logging configuration
@@ -87,6 +209,10 @@ func Load(path string) (*Config, error) {
 		return nil, fmt.Errorf("failed to parse config: %w", err)
 	}
 
+	if err := cfg.Inventory.load(); err != nil {
+		return nil, err
+	}
+
 	if err := cfg.Validate(); err != nil {
 		return nil, fmt.Errorf("config validation failed: %w", err)
 	}
@@ -104,32 +230,73 @@ func (c *Config) Validate() error {
 		c.Server.MetricsPath = "/metrics"
 	}
 
+	if !strings.HasPrefix(c.Server.MetricsPath, "/") || c.Server.MetricsPath == "/healthz" {
+		return fmt.Errorf("invalid metrics path: %q (must start with / and differ from /healthz)", c.Server.MetricsPath)
+	}
+
 	if c.Server.APIPath == "" {
 		c.Server.APIPath = "/api/v1"
 	}
 
-	if c.Collectors.FileLog.Enabled {
-		if c.Collectors.FileLog.Path == "" {
-			return fmt.Errorf("file_log path is required when enabled")
-		}
-		if c.Collectors.FileLog.Format == "" {
-			c.Collectors.FileLog.Format = "json"
-		}
-		if c.Collectors.FileLog.PollInterval == 0 {
-			c.Collectors.FileLog.PollInterval = 10 * time.Second
-		}
+	c.Server.APIPath = strings.TrimSuffix(c.Server.APIPath, "/")
+	if !strings.HasPrefix(c.Server.APIPath, "/") || c.Server.APIPath == "/healthz" ||
+		strings.HasPrefix(c.Server.MetricsPath, c.Server.APIPath+"/") || c.Server.APIPath == c.Server.MetricsPath {
+		return fmt.Errorf("invalid api path: %q (must start with / and not overlap the metrics and health paths)", c.Server.APIPath)
 	}
 
-	if c.Collectors.HTTPOFD.Enabled {
-		if c.Collectors.HTTPOFD.URL == "" {
-			return fmt.Errorf("http_ofd url is required when enabled")
-		}
-		if c.Collectors.HTTPOFD.PollInterval == 0 {
-			c.Collectors.HTTPOFD.PollInterval = 30 * time.Second
-		}
-		if c.Collectors.HTTPOFD.Timeout == 0 {
-			c.Collectors.HTTPOFD.Timeout = 10 * time.Second
-		}
+	if c.Exporter.StaleAfter == 0 {
+		c.Exporter.StaleAfter = 10 * time.Minute
+	}
+
+	if c.Exporter.DeleteAfter == 0 {
+		c.Exporter.DeleteAfter = 24 * time.Hour
+	}
+
+	if c.Exporter.StaleAfter < 0 || c.Exporter.DeleteAfter < c.Exporter.StaleAfter {
+		return fmt.Errorf("invalid exporter windows: stale_after %v, delete_after %v (delete_after must not be less than stale_after)",
+			c.Exporter.StaleAfter, c.Exporter.DeleteAfter)
+	}
+
+	if err := c.Collectors.validate(c.Exporter); err != nil {
+		return err
+	}
+
+	if err := c.Inventory.validate(); err != nil {
+		return err
+	}
+
+	if c.State.SnapshotInterval == 0 {
+		c.State.SnapshotInterval = time.Minute
+	}
+
+	if c.State.ErrorsLimit == 0 {
+		c.State.ErrorsLimit = 1000
+	}
+
+	if c.State.AutoResolveCycles == 0 {
+		c.State.AutoResolveCycles = 3
+	}
+
+	if c.State.SnapshotInterval < 0 || c.State.ErrorsLimit < 0 || c.State.AutoResolveCycles < 0 {
+		return fmt.Errorf("invalid state settings: snapshot_interval %v, errors_limit %d, auto_resolve_cycles %d (must not be negative)",
+			c.State.SnapshotInterval, c.State.ErrorsLimit, c.State.AutoResolveCycles)
+	}
+
+	if c.History.Retention == 0 {
+		c.History.Retention = 30 * 24 * time.Hour // 30 days
+	}
+
+	if c.History.DownsampleAfter == 0 {
+		c.History.DownsampleAfter = 48 * time.Hour
+	}
+
+	if c.History.DownsampleInterval == 0 {
+		c.History.DownsampleInterval = time.Hour
+	}
+
+	if c.History.Retention < 0 || c.History.DownsampleAfter < 0 || c.History.DownsampleInterval < 0 {
+		return fmt.Errorf("invalid history settings: retention %v, downsample_after %v, downsample_interval %v (must not be negative)",
+			c.History.Retention, c.History.DownsampleAfter, c.History.DownsampleInterval)
 	}
 
 	if c.AI.Provider == "" {
@@ -144,10 +311,27 @@ func (c *Config) Validate() error {
 		c.AI.ErrorClustering.SimilarityThreshold = 0.7
 	}
 
+	if c.AI.ErrorClustering.SimilarityThreshold < 0 || c.AI.ErrorClustering.SimilarityThreshold > 1 {
+		return fmt.Errorf("invalid similarity_threshold: %v (must be between 0 and 1)", c.AI.ErrorClustering.SimilarityThreshold)
+	}
+
+	if c.AI.ErrorClustering.Interval == 0 {
+		c.AI.ErrorClustering.Interval = 5 * time.Minute
+	}
+
 	if c.AI.AlertAdvisor.LookbackPeriod == 0 {
 		c.AI.AlertAdvisor.LookbackPeriod = 7 * 24 * time.Hour // 7 days
 	}
 
+	if c.AI.AlertAdvisor.Interval == 0 {
+		c.AI.AlertAdvisor.Interval = time.Hour
+	}
+
+	if c.History.Dir != "" && c.AI.AlertAdvisor.Enabled && c.History.Retention < c.AI.AlertAdvisor.LookbackPeriod {
+		return fmt.Errorf("history retention %v is shorter than the alert advisor lookback period %v",
+			c.History.Retention, c.AI.AlertAdvisor.LookbackPeriod)
+	}
+
 	if c.Logging.Level == "" {
 		c.Logging.Level = "info"
 	}
@@ -158,3 +342,117 @@ func (c *Config) Validate() error {
 
 	return nil
 }
+
+// Validate applies defaults to the file log settings and validates them
+func (c *FileLogConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.Path == "" {
+		return fmt.Errorf("path is required when enabled")
+	}
+	if c.Format == "" {
+		c.Format = "json"
+	}
+	if err := c.validateFormat(); err != nil {
+		return err
+	}
+	if c.PollInterval == 0 {
+		c.PollInterval = 10 * time.Second
+	}
+	if c.StaleAfter < 0 {
+		return fmt.Errorf("invalid stale_after: %v", c.StaleAfter)
+	}
+	return nil
+}
+
+// Validate applies defaults to the HTTP OFD settings and validates them
+func (c *HTTPOFDConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	*c = c.Resolved()
+	if c.Provider == "generic" && c.URL == "" {
+		return fmt.Errorf("url is required when enabled")
+	}
+	if c.PollInterval == 0 {
+		c.PollInterval = 30 * time.Second
+	}
+	if c.Timeout == 0 {
+		c.Timeout = 10 * time.Second
+	}
+	return c.validateLimits()
+}
+
+// validateLimits applies defaults to retry and rate limit settings and validates them
+func (c *HTTPOFDConfig) validateLimits() error {
+	if c.Retry.MaxAttempts == 0 {
+		c.Retry.MaxAttempts = 3
+	}
+	if c.Retry.InitialBackoff == 0 {
+		c.Retry.InitialBackoff = 500 * time.Millisecond
+	}
+	if c.Retry.MaxBackoff == 0 {
+		c.Retry.MaxBackoff = 30 * time.Second
+	}
+	if c.Retry.Jitter == 0 {
+		c.Retry.Jitter = 0.2
+	}
+	if c.RateLimit.RequestsPerSecond == nil {
+		rate := 5.0
+		c.RateLimit.RequestsPerSecond = &rate
+	}
+	if c.RateLimit.Burst == 0 {
+		c.RateLimit.Burst = 5
+	}
+
+	if c.Retry.MaxAttempts < 1 {
+		return fmt.Errorf("retry max_attempts must be positive: %d", c.Retry.MaxAttempts)
+	}
+	if c.Retry.MaxBackoff < c.Retry.InitialBackoff {
+		return fmt.Errorf("retry max_backoff must not be less than initial_backoff")
+	}
+	if c.Retry.Jitter < 0 || c.Retry.Jitter > 1 {
+		return fmt.Errorf("retry jitter must be between 0 and 1: %v", c.Retry.Jitter)
+	}
+	if *c.RateLimit.RequestsPerSecond < 0 || c.RateLimit.Burst < 0 {
+		return fmt.Errorf("rate_limit values must not be negative")
+	}
+
+	return nil
+}
+
+// validateFormat validates the log format and its text patterns
+func (c *FileLogConfig) validateFormat() error {
+	switch c.Format {
+	case "json":
+		return nil
+	case "text":
+	default:
+		return fmt.Errorf("unsupported format: %s", c.Format)
+	}
+
+	if len(c.Patterns) == 0 {
+		return fmt.Errorf("patterns are required for text format")
+	}
+
+	for i, p := range c.Patterns {
+		if p.Name == "" {
+			return fmt.Errorf("pattern #%d has no name", i+1)
+		}
+		switch p.Event {
+		case "document", "error", "status":
+		default:
+			return fmt.Errorf("pattern %q has invalid event %q (must be document, error or status)", p.Name, p.Event)
+		}
+		re, err := regexp.Compile(p.Regex)
+		if err != nil {
+			return fmt.Errorf("pattern %q has invalid regex: %w", p.Name, err)
+		}
+		if re.SubexpIndex("kkt_id") < 0 && p.Defaults["kkt_id"] == "" {
+			return fmt.Errorf("pattern %q must capture kkt_id", p.Name)
+		}
+	}
+
+	return nil
+}
@@ -5,6 +5,8 @@ import (
 	"path/filepath"
 	"testing"
 	"time"
+
+	"gopkg.in/yaml.v3"
 )
 
 func TestLoad(t *testing.T) {
@@ -62,11 +64,21 @@ logging:
 	}
 
 	// Test collectors config
-	if !cfg.Collectors.FileLog.Enabled {
+	if len(cfg.Collectors) != 2 {
+		t.Fatalf("Expected 2 collector instances, got %d", len(cfg.Collectors))
+	}
+	if cfg.Collectors[0].Type != "file_log" || !cfg.Collectors[0].Enabled {
 		t.Error("Expected file_log to be enabled")
 	}
-	if cfg.Collectors.FileLog.PollInterval != 10*time.Second {
-		t.Errorf("Expected poll_interval 10s, got %v", cfg.Collectors.FileLog.PollInterval)
+	if cfg.Collectors[0].Name != "file_log" {
+		t.Errorf("Expected default instance name file_log, got %s", cfg.Collectors[0].Name)
+	}
+	var fileLog FileLogConfig
+	if err := cfg.Collectors[0].DecodeFileLog(&fileLog); err != nil {
+		t.Fatalf("Failed to decode file_log settings: %v", err)
+	}
+	if fileLog.PollInterval != 10*time.Second {
+		t.Errorf("Expected poll_interval 10s, got %v", fileLog.PollInterval)
 	}
 
 	// Test AI config
@@ -80,6 +92,15 @@ logging:
 	}
 }
 
+// mustCollector creates a collector instance from typed settings
+func mustCollector(typ string, settings interface{}) CollectorConfig {
+	c, err := NewCollectorConfig(typ, settings)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
 func TestValidate(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -93,14 +114,60 @@ func TestValidate(t *testing.T) {
 					Port: 9090,
 				},
 				Collectors: CollectorsConfig{
-					FileLog: FileLogConfig{
+					mustCollector("file_log", FileLogConfig{
 						Enabled: true,
 						Path:    "/var/log/kkt/*.log",
-					},
+					}),
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "file_log stale_after",
+			cfg: Config{
+				Server: ServerConfig{
+					Port: 9090,
+				},
+				Collectors: CollectorsConfig{
+					mustCollector("file_log", FileLogConfig{
+						Enabled:    true,
+						Path:       "/var/log/kkt/*.log",
+						StaleAfter: 12 * time.Hour,
+					}),
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "rate limits of separate OFD accounts",
+			cfg: Config{
+				Server: ServerConfig{
+					Port: 9090,
+				},
+				Collectors: CollectorsConfig{
+					mustCollector("http_ofd", HTTPOFDConfig{Name: "store1", Enabled: true, Provider: "taxcom", APIKey: "key-1"}),
+					mustCollector("http_ofd", HTTPOFDConfig{Name: "store2", Enabled: true, Provider: "taxcom", APIKey: "key-2",
+						RateLimit: OFDRateLimitConfig{Burst: 1}}),
 				},
 			},
 			wantErr: false,
 		},
+		{
+			name: "file_log stale_after past delete_after",
+			cfg: Config{
+				Server: ServerConfig{
+					Port: 9090,
+				},
+				Collectors: CollectorsConfig{
+					mustCollector("file_log", FileLogConfig{
+						Enabled:    true,
+						Path:       "/var/log/kkt/*.log",
+						StaleAfter: 48 * time.Hour,
+					}),
+				},
+			},
+			wantErr: true,
+		},
 		{
 			name: "invalid port - too low",
 			cfg: Config{
@@ -126,14 +193,244 @@ func TestValidate(t *testing.T) {
 					Port: 9090,
 				},
 				Collectors: CollectorsConfig{
-					FileLog: FileLogConfig{
+					mustCollector("file_log", FileLogConfig{
 						Enabled: true,
 						Path:    "",
+					}),
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "text format without patterns",
+			cfg: Config{
+				Server: ServerConfig{
+					Port: 9090,
+				},
+				Collectors: CollectorsConfig{
+					mustCollector("file_log", FileLogConfig{
+						Enabled: true,
+						Path:    "/var/log/kkt/*.log",
+						Format:  "text",
+					}),
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "text pattern without kkt_id",
+			cfg: Config{
+				Server: ServerConfig{
+					Port: 9090,
+				},
+				Collectors: CollectorsConfig{
+					mustCollector("file_log", FileLogConfig{
+						Enabled: true,
+						Path:    "/var/log/kkt/*.log",
+						Format:  "text",
+						Patterns: []LogPattern{
+							{Name: "receipt", Event: "document", Regex: `FD (?P<document_number>\d+)`},
+						},
+					}),
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "http_ofd retry jitter out of range",
+			cfg: Config{
+				Server: ServerConfig{
+					Port: 9090,
+				},
+				Collectors: CollectorsConfig{
+					mustCollector("http_ofd", HTTPOFDConfig{
+						Enabled: true,
+						URL:     "https://ofd.example.ru/api/v1",
+						Retry:   OFDRetryConfig{Jitter: 1.5},
+					}),
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "several instances without names",
+			cfg: Config{
+				Server: ServerConfig{
+					Port: 9090,
+				},
+				Collectors: CollectorsConfig{
+					mustCollector("file_log", FileLogConfig{Enabled: true, Path: "/var/log/kkt/store1/*.log"}),
+					mustCollector("file_log", FileLogConfig{Enabled: true, Path: "/var/log/kkt/store2/*.log"}),
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "duplicate instance names",
+			cfg: Config{
+				Server: ServerConfig{
+					Port: 9090,
+				},
+				Collectors: CollectorsConfig{
+					mustCollector("file_log", FileLogConfig{Name: "store1", Enabled: true, Path: "/var/log/kkt/store1/*.log"}),
+					mustCollector("http_ofd", HTTPOFDConfig{Name: "store1", Enabled: true, URL: "https://ofd.example.ru/api/v1"}),
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "shared file_log state file",
+			cfg: Config{
+				Server: ServerConfig{
+					Port: 9090,
+				},
+				Collectors: CollectorsConfig{
+					mustCollector("file_log", FileLogConfig{Name: "store1", Enabled: true, Path: "/var/log/kkt/store1/*.log", StateFile: "/tmp/offsets.json"}),
+					mustCollector("file_log", FileLogConfig{Name: "store2", Enabled: true, Path: "/var/log/kkt/store2/*.log", StateFile: "/tmp/offsets.json"}),
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "conflicting rate limits of one OFD account",
+			cfg: Config{
+				Server: ServerConfig{
+					Port: 9090,
+				},
+				Collectors: CollectorsConfig{
+					mustCollector("http_ofd", HTTPOFDConfig{Name: "store1", Enabled: true, Provider: "taxcom", APIKey: "key"}),
+					mustCollector("http_ofd", HTTPOFDConfig{Name: "store2", Enabled: true, Provider: "taxcom", APIKey: "key",
+						RateLimit: OFDRateLimitConfig{Burst: 1}}),
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "conflicting rate limits of one OFD account with the default URL spelled out",
+			cfg: Config{
+				Server: ServerConfig{
+					Port: 9090,
+				},
+				Collectors: CollectorsConfig{
+					mustCollector("http_ofd", HTTPOFDConfig{Name: "store1", Enabled: true, Provider: "taxcom", APIKey: "key"}),
+					mustCollector("http_ofd", HTTPOFDConfig{Name: "store2", Enabled: true, Provider: "taxcom", APIKey: "key",
+						URL: "https://api-lk-ofd.taxcom.ru/API/v2/", RateLimit: OFDRateLimitConfig{Burst: 1}}),
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "duplicate inventory kkt_id",
+			cfg: Config{
+				Server: ServerConfig{
+					Port: 9090,
+				},
+				Inventory: InventoryConfig{
+					Devices: []DeviceConfig{{KKTID: "kkt-001"}, {KKTID: "kkt-001"}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid inventory inn",
+			cfg: Config{
+				Server: ServerConfig{
+					Port: 9090,
+				},
+				Inventory: InventoryConfig{
+					Devices: []DeviceConfig{{KKTID: "kkt-001", INN: "77000"}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "metrics path without leading slash",
+			cfg: Config{
+				Server: ServerConfig{
+					Port:        9090,
+					MetricsPath: "metrics",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "api path overlapping metrics path",
+			cfg: Config{
+				Server: ServerConfig{
+					Port:        9090,
+					MetricsPath: "/api/metrics",
+					APIPath:     "/api/",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative state errors limit",
+			cfg: Config{
+				Server: ServerConfig{
+					Port: 9090,
+				},
+				State: StateConfig{
+					ErrorsLimit: -1,
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "similarity threshold above 1",
+			cfg: Config{
+				Server: ServerConfig{
+					Port: 9090,
+				},
+				AI: AIConfig{
+					ErrorClustering: ErrorClusteringConfig{
+						SimilarityThreshold: 1.5,
 					},
 				},
 			},
 			wantErr: true,
 		},
+		{
+			name: "negative auto resolve cycles",
+			cfg: Config{
+				Server: ServerConfig{
+					Port: 9090,
+				},
+				State: StateConfig{
+					AutoResolveCycles: -1,
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "history retention shorter than advisor lookback",
+			cfg: Config{
+				Server: ServerConfig{
+					Port: 9090,
+				},
+				History: HistoryConfig{
+					Dir:       "/var/lib/kkt-monitor/history",
+					Retention: 24 * time.Hour,
+				},
+				AI: AIConfig{
+					AlertAdvisor: AlertAdvisorConfig{Enabled: true},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "delete_after shorter than stale_after",
+			cfg: Config{
+				Server: ServerConfig{
+					Port: 9090,
+				},
+				Exporter: ExporterConfig{
+					StaleAfter:  time.Hour,
+					DeleteAfter: 10 * time.Minute,
+				},
+			},
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -145,3 +442,200 @@ func TestValidate(t *testing.T) {
 		})
 	}
 }
+
+func TestLoad_CollectorInstances(t *testing.T) {
+	tests := []struct {
+		name       string
+		collectors string
+	}{
+		{
+			name: "mapping by type",
+			collectors: `
+  file_log:
+    - name: store-1
+      enabled: true
+      path: /var/log/kkt/store1/*.log
+    - name: store-2
+      enabled: true
+      path: /var/log/kkt/store2/*.log
+      poll_interval: 5s
+  http_ofd:
+    name: taxcom
+    enabled: true
+    provider: taxcom
+    api_key: secret
+`,
+		},
+		{
+			name: "list of typed instances",
+			collectors: `
+  - type: file_log
+    name: store-1
+    enabled: true
+    path: /var/log/kkt/store1/*.log
+  - type: file_log
+    name: store-2
+    enabled: true
+    path: /var/log/kkt/store2/*.log
+    poll_interval: 5s
+  - type: http_ofd
+    name: taxcom
+    enabled: true
+    provider: taxcom
+    api_key: secret
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configPath := filepath.Join(t.TempDir(), "config.yaml")
+			content := "server:\n  port: 9090\ncollectors:" + tt.collectors
+			if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+				t.Fatalf("Failed to write config file: %v", err)
+			}
+
+			cfg, err := Load(configPath)
+			if err != nil {
+				t.Fatalf("Failed to load config: %v", err)
+			}
+
+			if len(cfg.Collectors) != 3 {
+				t.Fatalf("Expected 3 collector instances, got %d", len(cfg.Collectors))
+			}
+
+			var store1, store2 FileLogConfig
+			if err := cfg.Collectors[0].DecodeFileLog(&store1); err != nil {
+				t.Fatalf("Failed to decode store-1: %v", err)
+			}
+			if err := cfg.Collectors[1].DecodeFileLog(&store2); err != nil {
+				t.Fatalf("Failed to decode store-2: %v", err)
+			}
+			if store1.Name != "store-1" || store1.PollInterval != 10*time.Second {
+				t.Errorf("Unexpected store-1 settings: %+v", store1)
+			}
+			if store2.Name != "store-2" || store2.PollInterval != 5*time.Second {
+				t.Errorf("Unexpected store-2 settings: %+v", store2)
+			}
+
+			var ofd HTTPOFDConfig
+			if err := cfg.Collectors[2].DecodeHTTPOFD(&ofd); err != nil {
+				t.Fatalf("Failed to decode taxcom: %v", err)
+			}
+			if cfg.Collectors[2].Type != "http_ofd" || ofd.Provider != "taxcom" || ofd.APIKey != "secret" {
+				t.Errorf("Unexpected taxcom instance: %+v", ofd)
+			}
+		})
+	}
+}
+
+func TestHTTPOFDConfig_RateLimit(t *testing.T) {
+	var unset HTTPOFDConfig
+	if err := yaml.Unmarshal([]byte("enabled: true\nurl: https://ofd.example.ru/api/v1\n"), &unset); err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	if err := unset.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	if unset.RateLimit.RequestsPerSecond == nil || *unset.RateLimit.RequestsPerSecond != 5 {
+		t.Errorf("Expected default of 5 requests per second, got %v", unset.RateLimit.RequestsPerSecond)
+	}
+
+	// An explicit 0 disables the limit
+	var unlimited HTTPOFDConfig
+	if err := yaml.Unmarshal([]byte("enabled: true\nurl: https://ofd.example.ru/api/v1\nrate_limit:\n  requests_per_second: 0\n"), &unlimited); err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	if err := unlimited.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	if unlimited.RateLimit.RequestsPerSecond == nil || *unlimited.RateLimit.RequestsPerSecond != 0 {
+		t.Errorf("Expected 0 requests per second, got %v", unlimited.RateLimit.RequestsPerSecond)
+	}
+}
+
+func TestHTTPOFDConfig_Account(t *testing.T) {
+	implicit := HTTPOFDConfig{Provider: "taxcom", APIKey: "key"}
+	explicit := HTTPOFDConfig{Provider: "taxcom", URL: "https://api-lk-ofd.taxcom.ru/API/v2/", APIKey: "key"}
+	if implicit.Account() != explicit.Account() {
+		t.Error("Expected the default URL of the provider to identify the same account")
+	}
+	if got := implicit.Resolved().URL; got != "https://api-lk-ofd.taxcom.ru/API/v2" {
+		t.Errorf("Expected the default URL of the provider, got %q", got)
+	}
+
+	generic := HTTPOFDConfig{URL: "https://ofd.example.ru/api/v1", APIKey: "key"}
+	if got := generic.Resolved().Provider; got != "generic" {
+		t.Errorf("Expected provider generic, got %q", got)
+	}
+	if generic.Account() == implicit.Account() {
+		t.Error("Expected another provider to be another account")
+	}
+}
+
+func TestValidate_CollectorType(t *testing.T) {
+	cfg := Config{
+		Server:     ServerConfig{Port: 9090},
+		Collectors: CollectorsConfig{{Name: "custom", Enabled: true}},
+	}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected error for collector without type")
+	}
+
+	// Types unknown to config are left to their factories
+	cfg.Collectors[0].Type = "custom"
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+}
+
+func TestLoad_Inventory(t *testing.T) {
+	tmpDir := t.TempDir()
+	inventoryPath := filepath.Join(tmpDir, "inventory.yaml")
+	configPath := filepath.Join(tmpDir, "config.yaml")
+
+	inventoryContent := `
+devices:
+  - kkt_id: kkt-002
+    store: store-2
+    region: "78"
+    inn: "7800000000"
+    model: Shtrih-M-01F
+    ffd_version: "1.2"
+    ofd: taxcom
+`
+	configContent := `
+server:
+  port: 9090
+inventory:
+  file: ` + inventoryPath + `
+  devices:
+    - kkt_id: kkt-001
+      store: store-1
+      address: Moscow, Tverskaya 1
+      inn: "7700000000"
+`
+
+	if err := os.WriteFile(inventoryPath, []byte(inventoryContent), 0644); err != nil {
+		t.Fatalf("Failed to write inventory file: %v", err)
+	}
+	if err := os.WriteFile(configPath, []byte(configContent), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	devices := cfg.Inventory.Devices
+	if len(devices) != 2 {
+		t.Fatalf("Expected 2 inventory devices, got %d", len(devices))
+	}
+	if devices[0].KKTID != "kkt-001" || devices[0].Address != "Moscow, Tverskaya 1" {
+		t.Errorf("Expected inline device kkt-001 first, got %+v", devices[0])
+	}
+	if devices[1].KKTID != "kkt-002" || devices[1].FFDVersion != "1.2" || devices[1].OFD != "taxcom" {
+		t.Errorf("Expected file device kkt-002, got %+v", devices[1])
+	}
+}
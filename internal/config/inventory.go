@@ -0,0 +1,89 @@
+package config
+
+import (
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// InventoryConfig describes the KKT devices, declared inline or in a
+// separate YAML file with a top-level devices list
+type InventoryConfig struct {
+	File    string         `yaml:"file"`
+	Devices []DeviceConfig `yaml:"devices"`
+}
+
+// DeviceConfig is the inventory record of a KKT device
+type DeviceConfig struct {
+	KKTID      string `yaml:"kkt_id"`
+	Store      string `yaml:"store"`
+	Address    string `yaml:"address"`
+	Region     string `yaml:"region"`
+	INN        string `yaml:"inn"` // INN of the organization
+	Model      string `yaml:"model"`
+	FFDVersion string `yaml:"ffd_version"`
+	OFD        string `yaml:"ofd"` // OFD provider
+}
+
+// LoadInventory loads devices from an inventory file
+func LoadInventory(path string) ([]DeviceConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read inventory file: %w", err)
+	}
+
+	var inventory struct {
+		Devices []DeviceConfig `yaml:"devices"`
+	}
+	if err := yaml.Unmarshal(data, &inventory); err != nil {
+		return nil, fmt.Errorf("failed to parse inventory file: %w", err)
+	}
+	return inventory.Devices, nil
+}
+
+// load appends the devices of the inventory file to the inline devices
+func (c *InventoryConfig) load() error {
+	if c.File == "" {
+		return nil
+	}
+	devices, err := LoadInventory(c.File)
+	if err != nil {
+		return err
+	}
+	c.Devices = append(c.Devices, devices...)
+	return nil
+}
+
+// validate checks that every device has a unique KKT ID and a valid INN
+func (c InventoryConfig) validate() error {
+	seen := make(map[string]bool)
+	for i, d := range c.Devices {
+		if d.KKTID == "" {
+			return fmt.Errorf("inventory device #%d: kkt_id is required", i+1)
+		}
+		if seen[d.KKTID] {
+			return fmt.Errorf("inventory: duplicate kkt_id %s", d.KKTID)
+		}
+		seen[d.KKTID] = true
+
+		if d.INN != "" && !validINN(d.INN) {
+			return fmt.Errorf("inventory device %s: invalid inn %q (must be 10 or 12 digits)", d.KKTID, d.INN)
+		}
+	}
+	return nil
+}
+
+// validINN reports whether inn has the length of an organization (10) or
+// individual entrepreneur (12) INN and consists of digits
+func validINN(inn string) bool {
+	if len(inn) != 10 && len(inn) != 12 {
+		return false
+	}
+	for _, r := range inn {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,338 @@
+package domain
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Enums are encoded as stable lower-case names in text, JSON and YAML.
+// Decoding accepts the names, their aliases and plain numbers; values
+// without a name are encoded as numbers. The zero value of enums starting
+// at 1 is "unknown".
+
+// enum maps the values of an enum type to names
+type enum[T ~int] struct {
+	typeName string
+	names    map[T]string
+	values   map[string]T
+}
+
+// newEnum creates the mapping of an enum. Aliases are accepted by parse only.
+func newEnum[T ~int](typeName string, names map[T]string, aliases map[string]T) enum[T] {
+	values := make(map[string]T, len(names)+len(aliases))
+	for v, name := range names {
+		values[name] = v
+	}
+	for alias, v := range aliases {
+		values[alias] = v
+	}
+	return enum[T]{typeName: typeName, names: names, values: values}
+}
+
+// EnumLabel returns the name of an enum value as a Prometheus label value.
+// Values without a name are "unknown", so that they cannot grow the number
+// of series.
+func EnumLabel(v fmt.Stringer) string {
+	name := v.String()
+	if _, err := strconv.Atoi(name); err == nil {
+		return "unknown"
+	}
+	return name
+}
+
+// name returns the name of v, or the number when it has none
+func (e enum[T]) name(v T) string {
+	if name, ok := e.names[v]; ok {
+		return name
+	}
+	return strconv.Itoa(int(v))
+}
+
+// parse parses a case-insensitive name or a number
+func (e enum[T]) parse(text []byte) (T, error) {
+	s := strings.ToLower(strings.TrimSpace(string(text)))
+	if v, ok := e.values[s]; ok {
+		return v, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("unknown %s %q", e.typeName, s)
+	}
+	return T(n), nil
+}
+
+// parseJSON parses a JSON string or number
+func (e enum[T]) parseJSON(data []byte, v *T) error {
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		data = []byte(s)
+	}
+	parsed, err := e.parse(data)
+	if err != nil {
+		return err
+	}
+	*v = parsed
+	return nil
+}
+
+var kktStatuses = newEnum("kkt status", map[KKTStatus]string{
+	KKTStatusUnavailable: "unavailable",
+	KKTStatusRunning:     "running",
+	KKTStatusError:       "error",
+}, nil)
+
+// String returns the name of the status
+func (s KKTStatus) String() string { return kktStatuses.name(s) }
+
+// MarshalText encodes the status as its name
+func (s KKTStatus) MarshalText() ([]byte, error) { return []byte(s.String()), nil }
+
+// UnmarshalText decodes a status name or number
+func (s *KKTStatus) UnmarshalText(text []byte) (err error) {
+	*s, err = kktStatuses.parse(text)
+	return err
+}
+
+// UnmarshalJSON decodes a status name or number
+func (s *KKTStatus) UnmarshalJSON(data []byte) error { return kktStatuses.parseJSON(data, s) }
+
+var shiftStatuses = newEnum("shift status", map[ShiftStatus]string{
+	ShiftStatusClosed: "closed",
+	ShiftStatusOpen:   "open",
+}, nil)
+
+// String returns the name of the shift status
+func (s ShiftStatus) String() string { return shiftStatuses.name(s) }
+
+// MarshalText encodes the shift status as its name
+func (s ShiftStatus) MarshalText() ([]byte, error) { return []byte(s.String()), nil }
+
+// UnmarshalText decodes a shift status name or number
+func (s *ShiftStatus) UnmarshalText(text []byte) (err error) {
+	*s, err = shiftStatuses.parse(text)
+	return err
+}
+
+// UnmarshalJSON decodes a shift status name or number
+func (s *ShiftStatus) UnmarshalJSON(data []byte) error { return shiftStatuses.parseJSON(data, s) }
+
+var ofdSyncStatuses = newEnum("OFD sync status", map[OFDSyncStatus]string{
+	OFDSyncStatusUnknown: "unknown",
+	OFDSyncStatusSynced:  "synced",
+	OFDSyncStatusPending: "pending",
+	OFDSyncStatusError:   "error",
+}, nil)
+
+// String returns the name of the sync status
+func (s OFDSyncStatus) String() string { return ofdSyncStatuses.name(s) }
+
+// MarshalText encodes the sync status as its name
+func (s OFDSyncStatus) MarshalText() ([]byte, error) { return []byte(s.String()), nil }
+
+// UnmarshalText decodes a sync status name or number
+func (s *OFDSyncStatus) UnmarshalText(text []byte) (err error) {
+	*s, err = ofdSyncStatuses.parse(text)
+	return err
+}
+
+// UnmarshalJSON decodes a sync status name or number
+func (s *OFDSyncStatus) UnmarshalJSON(data []byte) error { return ofdSyncStatuses.parseJSON(data, s) }
+
+var documentTypes = newEnum("document type", map[DocumentType]string{
+	0:                             "unknown",
+	DocumentTypeReceipt:           "receipt",
+	DocumentTypeReceiptReturn:     "receipt_return",
+	DocumentTypeReceiptCorrection: "receipt_correction",
+	DocumentTypeOpenShift:         "open_shift",
+	DocumentTypeCloseShift:        "close_shift",
+	DocumentTypeRegistration:      "registration",
+	DocumentTypeReRegistration:    "re_registration",
+	DocumentTypeCloseArchive:      "close_archive",
+}, nil)
+
+// String returns the name of the document type
+func (t DocumentType) String() string { return documentTypes.name(t) }
+
+// MarshalText encodes the document type as its name
+func (t DocumentType) MarshalText() ([]byte, error) { return []byte(t.String()), nil }
+
+// UnmarshalText decodes a document type name or number
+func (t *DocumentType) UnmarshalText(text []byte) (err error) {
+	*t, err = documentTypes.parse(text)
+	return err
+}
+
+// UnmarshalJSON decodes a document type name or number
+func (t *DocumentType) UnmarshalJSON(data []byte) error { return documentTypes.parseJSON(data, t) }
+
+var operationTypes = newEnum("operation type", map[OperationType]string{
+	0:                           "unknown",
+	OperationTypeSale:           "sale",
+	OperationTypeSaleReturn:     "sale_return",
+	OperationTypePurchase:       "purchase",
+	OperationTypePurchaseReturn: "purchase_return",
+}, nil)
+
+// String returns the name of the operation type
+func (t OperationType) String() string { return operationTypes.name(t) }
+
+// MarshalText encodes the operation type as its name
+func (t OperationType) MarshalText() ([]byte, error) { return []byte(t.String()), nil }
+
+// UnmarshalText decodes an operation type name or number
+func (t *OperationType) UnmarshalText(text []byte) (err error) {
+	*t, err = operationTypes.parse(text)
+	return err
+}
+
+// UnmarshalJSON decodes an operation type name or number
+func (t *OperationType) UnmarshalJSON(data []byte) error { return operationTypes.parseJSON(data, t) }
+
+var taxationSystems = newEnum("taxation system", map[TaxationSystem]string{
+	0:                                  "unknown",
+	TaxationSystemCommon:               "common",
+	TaxationSystemSimplified:           "simplified",
+	TaxationSystemSimplifiedMinusCosts: "simplified_minus_costs",
+	TaxationSystemSingleTax:            "single_tax",
+	TaxationSystemPatent:               "patent",
+}, nil)
+
+// String returns the name of the taxation system
+func (t TaxationSystem) String() string { return taxationSystems.name(t) }
+
+// MarshalText encodes the taxation system as its name
+func (t TaxationSystem) MarshalText() ([]byte, error) { return []byte(t.String()), nil }
+
+// UnmarshalText decodes a taxation system name or number
+func (t *TaxationSystem) UnmarshalText(text []byte) (err error) {
+	*t, err = taxationSystems.parse(text)
+	return err
+}
+
+// UnmarshalJSON decodes a taxation system name or number
+func (t *TaxationSystem) UnmarshalJSON(data []byte) error { return taxationSystems.parseJSON(data, t) }
+
+// VAT rates are named "vat<percent>" so that names never read as numbers
+var vatRates = newEnum("VAT rate", map[VATRate]string{
+	VATRateNone: "none",
+	VATRate0:    "vat0",
+	VATRate10:   "vat10",
+	VATRate20:   "vat20",
+}, nil)
+
+// String returns the name of the VAT rate
+func (r VATRate) String() string { return vatRates.name(r) }
+
+// MarshalText encodes the VAT rate as its name
+func (r VATRate) MarshalText() ([]byte, error) { return []byte(r.String()), nil }
+
+// UnmarshalText decodes a VAT rate name or number
+func (r *VATRate) UnmarshalText(text []byte) (err error) {
+	*r, err = vatRates.parse(text)
+	return err
+}
+
+// UnmarshalJSON decodes a VAT rate name or number
+func (r *VATRate) UnmarshalJSON(data []byte) error { return vatRates.parseJSON(data, r) }
+
+var errorTypes = newEnum("error type", map[ErrorType]string{
+	0:                      "unknown",
+	ErrorTypeNetwork:       "network",
+	ErrorTypeFiscalDrive:   "fiscal_drive",
+	ErrorTypeOFD:           "ofd",
+	ErrorTypePrinter:       "printer",
+	ErrorTypeHardware:      "hardware",
+	ErrorTypeSoftware:      "software",
+	ErrorTypeConfiguration: "configuration",
+}, map[string]ErrorType{
+	"fn": ErrorTypeFiscalDrive,
+})
+
+// ErrorTypes returns all known error types
+func ErrorTypes() []ErrorType {
+	return []ErrorType{
+		ErrorTypeNetwork,
+		ErrorTypeFiscalDrive,
+		ErrorTypeOFD,
+		ErrorTypePrinter,
+		ErrorTypeHardware,
+		ErrorTypeSoftware,
+		ErrorTypeConfiguration,
+	}
+}
+
+// String returns the name of the error type
+func (t ErrorType) String() string { return errorTypes.name(t) }
+
+// MarshalText encodes the error type as its name
+func (t ErrorType) MarshalText() ([]byte, error) { return []byte(t.String()), nil }
+
+// UnmarshalText decodes an error type name or number
+func (t *ErrorType) UnmarshalText(text []byte) (err error) {
+	*t, err = errorTypes.parse(text)
+	return err
+}
+
+// UnmarshalJSON decodes an error type name or number
+func (t *ErrorType) UnmarshalJSON(data []byte) error { return errorTypes.parseJSON(data, t) }
+
+var errorSeverities = newEnum("error severity", map[ErrorSeverity]string{
+	0:                     "unknown",
+	ErrorSeverityInfo:     "info",
+	ErrorSeverityWarning:  "warning",
+	ErrorSeverityError:    "error",
+	ErrorSeverityCritical: "critical",
+}, map[string]ErrorSeverity{
+	"warn":  ErrorSeverityWarning,
+	"err":   ErrorSeverityError,
+	"crit":  ErrorSeverityCritical,
+	"fatal": ErrorSeverityCritical,
+})
+
+// String returns the name of the severity
+func (s ErrorSeverity) String() string { return errorSeverities.name(s) }
+
+// MarshalText encodes the severity as its name
+func (s ErrorSeverity) MarshalText() ([]byte, error) { return []byte(s.String()), nil }
+
+// UnmarshalText decodes a severity name or number
+func (s *ErrorSeverity) UnmarshalText(text []byte) (err error) {
+	*s, err = errorSeverities.parse(text)
+	return err
+}
+
+// UnmarshalJSON decodes a severity name or number
+func (s *ErrorSeverity) UnmarshalJSON(data []byte) error { return errorSeverities.parseJSON(data, s) }
+
+var errorStates = newEnum("error state", map[ErrorState]string{
+	0:                      "unknown",
+	ErrorStateOpen:         "open",
+	ErrorStateAcknowledged: "acknowledged",
+	ErrorStateResolved:     "resolved",
+}, map[string]ErrorState{
+	"ack": ErrorStateAcknowledged,
+})
+
+// String returns the name of the error state
+func (s ErrorState) String() string { return errorStates.name(s) }
+
+// MarshalText encodes the error state as its name
+func (s ErrorState) MarshalText() ([]byte, error) { return []byte(s.String()), nil }
+
+// UnmarshalText decodes an error state name or number
+func (s *ErrorState) UnmarshalText(text []byte) (err error) {
+	*s, err = errorStates.parse(text)
+	return err
+}
+
+// UnmarshalJSON decodes an error state name or number
+func (s *ErrorState) UnmarshalJSON(data []byte) error { return errorStates.parseJSON(data, s) }
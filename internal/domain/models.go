@@ -1,6 +1,9 @@
 package domain
 
-import "time"
+import (
+	"errors"
+	"time"
+)
 
 // KKTStatus represents the operational status of a KKT device
 type KKTStatus int
@@ -121,6 +124,22 @@ const (
 	VATRate20
 )
 
+// ReceiptTotal is the number and amount of receipts of an operation type
+// and taxation system
+type ReceiptTotal struct {
+	OperationType  OperationType  `json:"operation_type"`
+	TaxationSystem TaxationSystem `json:"taxation_system"`
+	Count          int64          `json:"count"`
+	Amount         float64        `json:"amount"`
+}
+
+// VATTotal is the amount of receipt items of an operation type and VAT rate
+type VATTotal struct {
+	OperationType OperationType `json:"operation_type"`
+	VATRate       VATRate       `json:"vat_rate"`
+	Amount        float64       `json:"amount"`
+}
+
 // KKTError represents an error from KKT device
 type KKTError struct {
 	ID          string       `json:"id"`
@@ -134,6 +153,37 @@ type KKTError struct {
 	ResolvedAt  *time.Time   `json:"resolved_at,omitempty"`
 }
 
+// ErrorState is the lifecycle state of a tracked KKT error
+type ErrorState int
+
+const (
+	ErrorStateOpen ErrorState = iota + 1
+	ErrorStateAcknowledged
+	ErrorStateResolved
+)
+
+// TrackedError is a KKT error followed from its first occurrence to its
+// resolution. The embedded KKTError is the first occurrence with the ID of
+// the tracked error; recurrences of the error code on the KKT before it is
+// resolved are counted on it.
+type TrackedError struct {
+	KKTError
+	State          ErrorState `json:"state"`
+	Occurrences    int        `json:"occurrences"`
+	LastSeen       time.Time  `json:"last_seen"`
+	AcknowledgedAt *time.Time `json:"acknowledged_at,omitempty"`
+	AcknowledgedBy string     `json:"acknowledged_by,omitempty"`
+	// ResolvedBy is the operator, or "auto" when the device recovered
+	ResolvedBy string `json:"resolved_by,omitempty"`
+	Note       string `json:"note,omitempty"`
+}
+
+// Errors of operator actions on tracked errors
+var (
+	ErrTrackedErrorNotFound = errors.New("tracked error not found")
+	ErrTrackedErrorResolved = errors.New("tracked error is already resolved")
+)
+
 // ErrorType represents the type of error
 type ErrorType int
 
@@ -171,15 +221,40 @@ type OFDTransaction struct {
 
 // Metrics represents aggregated metrics for KKT monitoring
 type Metrics struct {
-	KKTID              string              `json:"kkt_id"`
-	Timestamp          time.Time           `json:"timestamp"`
-	Status             KKTStatus           `json:"status"`
-	DocumentsTotal     int64               `json:"documents_total"`
-	ErrorsByType       map[ErrorType]int64 `json:"errors_by_type"`
-	OFDSyncStatus      OFDSyncStatus       `json:"ofd_sync_status"`
-	ShiftStatus        ShiftStatus         `json:"shift_status"`
-	LastDocumentTime   time.Time           `json:"last_document_time"`
-	FDMemoryUsage      float64             `json:"fd_memory_usage"`
-	DocumentsPerHour   float64             `json:"documents_per_hour"`
-	AverageSyncTime    float64             `json:"average_sync_time"` // seconds
+	KKTID            string              `json:"kkt_id"`
+	Collector        string              `json:"collector"` // name of the collector instance
+	Timestamp        time.Time           `json:"timestamp"`
+	Status           KKTStatus           `json:"status"`
+	DocumentsTotal   int64               `json:"documents_total"` // documents seen since the collector started
+	ErrorsByType     map[ErrorType]int64 `json:"errors_by_type"`  // errors seen since the collector started
+	OFDSyncStatus    OFDSyncStatus       `json:"ofd_sync_status"`
+	ShiftStatus      ShiftStatus         `json:"shift_status"`
+	LastDocumentTime time.Time           `json:"last_document_time"`
+	FDMemoryUsage    float64             `json:"fd_memory_usage"`
+	DocumentsPerHour float64             `json:"documents_per_hour"`
+	AverageSyncTime  float64             `json:"average_sync_time"` // seconds
+	UnsentDocuments  int64               `json:"unsent_documents"`  // documents not acknowledged by the OFD
+	// LastDocumentNumber is the fiscal number of the last document
+	LastDocumentNumber int64 `json:"last_document_number"`
+	// FiscalDrive is the installed fiscal drive, zero fields are unknown
+	FiscalDrive FiscalDrive `json:"fiscal_drive"`
+	// OldestUnsentTime is the time of the oldest document not acknowledged
+	// by the OFD, zero when there are none or the time is unknown
+	OldestUnsentTime time.Time `json:"oldest_unsent_time"`
+	// Receipts and ReceiptVAT are receipt totals since the collector started
+	Receipts   []ReceiptTotal `json:"receipts,omitempty"`
+	ReceiptVAT []VATTotal     `json:"receipt_vat,omitempty"`
+	// OFD is the OFD provider of the device, empty when unknown
+	OFD string `json:"ofd,omitempty"`
+	// OFDAckLatencies are the send to acknowledgement latencies in seconds
+	// of documents acknowledged since the previous metrics of the device
+	OFDAckLatencies []float64 `json:"ofd_ack_latencies,omitempty"`
+	// ShiftNumber is the number of the current or last shift, 0 when unknown
+	ShiftNumber int `json:"shift_number"`
+	// ShiftOpenedAt is when the open shift was opened, zero when the shift
+	// is closed or the time is unknown
+	ShiftOpenedAt time.Time `json:"shift_opened_at"`
+	// LastEventTime is the time of the latest device event the metrics
+	// reflect, e.g. a document or a status report; zero when unknown
+	LastEventTime time.Time `json:"last_event_time"`
 }
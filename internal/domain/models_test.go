@@ -1,6 +1,7 @@
 package domain
 
 import (
+	"encoding/json"
 	"testing"
 	"time"
 )
@@ -112,3 +113,172 @@ func TestMetrics(t *testing.T) {
 		t.Errorf("Expected 5 network errors, got %d", metrics.ErrorsByType[ErrorTypeNetwork])
 	}
 }
+
+func TestMetrics_ApplyShiftDocument(t *testing.T) {
+	start := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+	var m Metrics
+
+	tests := []struct {
+		name       string
+		doc        FiscalDocument
+		wantStatus ShiftStatus
+		wantNumber int
+		wantOpened time.Time
+	}{
+		{
+			name:       "open shift",
+			doc:        FiscalDocument{Type: DocumentTypeOpenShift, ShiftNumber: 12, DateTime: start},
+			wantStatus: ShiftStatusOpen, wantNumber: 12, wantOpened: start,
+		},
+		{
+			name:       "receipt of the open shift",
+			doc:        FiscalDocument{Type: DocumentTypeReceipt, ShiftNumber: 12, DateTime: start.Add(time.Hour)},
+			wantStatus: ShiftStatusOpen, wantNumber: 12, wantOpened: start,
+		},
+		{
+			name:       "close shift",
+			doc:        FiscalDocument{Type: DocumentTypeCloseShift, ShiftNumber: 12, DateTime: start.Add(12 * time.Hour)},
+			wantStatus: ShiftStatusClosed, wantNumber: 12,
+		},
+		{
+			name:       "late receipt of the closed shift",
+			doc:        FiscalDocument{Type: DocumentTypeReceipt, ShiftNumber: 12, DateTime: start.Add(13 * time.Hour)},
+			wantStatus: ShiftStatusClosed, wantNumber: 12,
+		},
+		{
+			// The open shift document of shift 13 was missed
+			name:       "receipt of a newer shift",
+			doc:        FiscalDocument{Type: DocumentTypeReceipt, ShiftNumber: 13, DateTime: start.Add(24 * time.Hour)},
+			wantStatus: ShiftStatusOpen, wantNumber: 13, wantOpened: start.Add(24 * time.Hour),
+		},
+		{
+			name:       "close of an older shift",
+			doc:        FiscalDocument{Type: DocumentTypeCloseShift, ShiftNumber: 12, DateTime: start.Add(25 * time.Hour)},
+			wantStatus: ShiftStatusOpen, wantNumber: 13, wantOpened: start.Add(24 * time.Hour),
+		},
+	}
+
+	for _, tt := range tests {
+		m.ApplyShiftDocument(tt.doc)
+		if m.ShiftStatus != tt.wantStatus || m.ShiftNumber != tt.wantNumber || !m.ShiftOpenedAt.Equal(tt.wantOpened) {
+			t.Errorf("%s: expected status %d, shift %d opened at %v, got %d, %d, %v",
+				tt.name, tt.wantStatus, tt.wantNumber, tt.wantOpened, m.ShiftStatus, m.ShiftNumber, m.ShiftOpenedAt)
+		}
+	}
+}
+
+func TestMetrics_AddReceipt(t *testing.T) {
+	var m Metrics
+
+	m.AddReceipt(FiscalDocument{
+		Type:           DocumentTypeReceipt,
+		TaxationSystem: TaxationSystemSimplified,
+		Items: []DocumentItem{
+			{Price: 100, Quantity: 2, VATRate: VATRate20},
+			{Amount: 50, VATRate: VATRate10},
+		},
+	})
+	m.AddReceipt(FiscalDocument{Type: DocumentTypeReceipt, TaxationSystem: TaxationSystemSimplified, Amount: 300})
+	m.AddReceipt(FiscalDocument{Type: DocumentTypeReceiptReturn, TaxationSystem: TaxationSystemSimplified, Amount: 100})
+	m.AddReceipt(FiscalDocument{Type: DocumentTypeOpenShift})
+
+	want := []ReceiptTotal{
+		{OperationType: OperationTypeSale, TaxationSystem: TaxationSystemSimplified, Count: 2, Amount: 550},
+		{OperationType: OperationTypeSaleReturn, TaxationSystem: TaxationSystemSimplified, Count: 1, Amount: 100},
+	}
+	if len(m.Receipts) != len(want) {
+		t.Fatalf("Expected %d receipt totals, got %+v", len(want), m.Receipts)
+	}
+	for i := range want {
+		if m.Receipts[i] != want[i] {
+			t.Errorf("Expected receipt total %+v, got %+v", want[i], m.Receipts[i])
+		}
+	}
+
+	if len(m.ReceiptVAT) != 2 || m.ReceiptVAT[0].VATRate != VATRate20 || m.ReceiptVAT[0].Amount != 200 {
+		t.Errorf("Expected VAT 20%% amount 200, got %+v", m.ReceiptVAT)
+	}
+}
+
+func TestEnums_Text(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    ErrorType
+		wantErr bool
+	}{
+		{name: "name", input: "ofd", want: ErrorTypeOFD},
+		{name: "alias", input: "FN", want: ErrorTypeFiscalDrive},
+		{name: "number", input: "4", want: ErrorTypePrinter},
+		{name: "unknown name", input: "disk", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got ErrorType
+			err := got.UnmarshalText([]byte(tt.input))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			if got != tt.want {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+
+	if s := VATRate20.String(); s != "vat20" {
+		t.Errorf("Expected vat20, got %s", s)
+	}
+	if s := OperationType(0).String(); s != "unknown" {
+		t.Errorf("Expected unknown, got %s", s)
+	}
+	if s := ErrorSeverity(9).String(); s != "9" {
+		t.Errorf("Expected 9, got %s", s)
+	}
+	if s := EnumLabel(ErrorStateAcknowledged); s != "acknowledged" {
+		t.Errorf("Expected acknowledged label, got %s", s)
+	}
+	if s := EnumLabel(ErrorType(42)); s != "unknown" {
+		t.Errorf("Expected unknown label, got %s", s)
+	}
+}
+
+func TestEnums_JSON(t *testing.T) {
+	var kktErr KKTError
+	if err := json.Unmarshal([]byte(`{"error_type":3,"severity":"warn"}`), &kktErr); err != nil {
+		t.Fatalf("Failed to unmarshal: %v", err)
+	}
+	if kktErr.ErrorType != ErrorTypeOFD {
+		t.Errorf("Expected error type ofd, got %v", kktErr.ErrorType)
+	}
+	if kktErr.Severity != ErrorSeverityWarning {
+		t.Errorf("Expected severity warning, got %v", kktErr.Severity)
+	}
+
+	metrics := Metrics{
+		Status:       KKTStatusRunning,
+		ErrorsByType: map[ErrorType]int64{ErrorTypeNetwork: 2},
+	}
+	data, err := json.Marshal(metrics)
+	if err != nil {
+		t.Fatalf("Failed to marshal: %v", err)
+	}
+	var decoded map[string]any
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Failed to unmarshal: %v", err)
+	}
+	if decoded["status"] != "running" {
+		t.Errorf("Expected status running, got %v", decoded["status"])
+	}
+	if byType, _ := decoded["errors_by_type"].(map[string]any); byType["network"] != float64(2) {
+		t.Errorf("Expected errors_by_type network 2, got %v", decoded["errors_by_type"])
+	}
+
+	var roundTrip Metrics
+	if err := json.Unmarshal(data, &roundTrip); err != nil {
+		t.Fatalf("Failed to unmarshal: %v", err)
+	}
+	if roundTrip.Status != KKTStatusRunning || roundTrip.ErrorsByType[ErrorTypeNetwork] != 2 {
+		t.Errorf("Expected round trip to keep status and errors, got %+v", roundTrip)
+	}
+}
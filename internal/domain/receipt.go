@@ -0,0 +1,62 @@
+package domain
+
+// AddReceipt adds a receipt to the receipt totals. Other documents are
+// ignored. A receipt without operation type is a sale, or a sale return for
+// receipt return documents. Items without amount count as price times
+// quantity.
+func (m *Metrics) AddReceipt(doc FiscalDocument) {
+	op := doc.OperationType
+	switch doc.Type {
+	case DocumentTypeReceipt:
+		if op == 0 {
+			op = OperationTypeSale
+		}
+	case DocumentTypeReceiptReturn:
+		if op == 0 {
+			op = OperationTypeSaleReturn
+		}
+	default:
+		return
+	}
+
+	amount := doc.Amount
+	itemsAmount := 0.0
+	for _, item := range doc.Items {
+		itemAmount := item.Amount
+		if itemAmount == 0 {
+			itemAmount = item.Price * item.Quantity
+		}
+		itemsAmount += itemAmount
+		m.addVAT(op, item.VATRate, itemAmount)
+	}
+	if amount == 0 {
+		amount = itemsAmount
+	}
+
+	for i := range m.Receipts {
+		r := &m.Receipts[i]
+		if r.OperationType == op && r.TaxationSystem == doc.TaxationSystem {
+			r.Count++
+			r.Amount += amount
+			return
+		}
+	}
+	m.Receipts = append(m.Receipts, ReceiptTotal{
+		OperationType:  op,
+		TaxationSystem: doc.TaxationSystem,
+		Count:          1,
+		Amount:         amount,
+	})
+}
+
+// addVAT adds an item amount to the VAT totals
+func (m *Metrics) addVAT(op OperationType, rate VATRate, amount float64) {
+	for i := range m.ReceiptVAT {
+		v := &m.ReceiptVAT[i]
+		if v.OperationType == op && v.VATRate == rate {
+			v.Amount += amount
+			return
+		}
+	}
+	m.ReceiptVAT = append(m.ReceiptVAT, VATTotal{OperationType: op, VATRate: rate, Amount: amount})
+}
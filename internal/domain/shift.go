@@ -0,0 +1,32 @@
+package domain
+
+import "time"
+
+// ApplyShiftDocument updates the shift state from a fiscal document. Open
+// and close shift documents open and close the shift; any other document of
+// a newer shift means the open shift document was missed, so the shift is
+// considered opened at that document. Documents of older shifts are ignored.
+func (m *Metrics) ApplyShiftDocument(doc FiscalDocument) {
+	if doc.ShiftNumber > 0 && doc.ShiftNumber < m.ShiftNumber {
+		return
+	}
+
+	switch doc.Type {
+	case DocumentTypeOpenShift:
+		m.ShiftStatus = ShiftStatusOpen
+		m.ShiftOpenedAt = doc.DateTime
+	case DocumentTypeCloseShift:
+		m.ShiftStatus = ShiftStatusClosed
+		m.ShiftOpenedAt = time.Time{}
+	default:
+		if doc.ShiftNumber <= m.ShiftNumber {
+			return
+		}
+		m.ShiftStatus = ShiftStatusOpen
+		m.ShiftOpenedAt = doc.DateTime
+	}
+
+	if doc.ShiftNumber > 0 {
+		m.ShiftNumber = doc.ShiftNumber
+	}
+}
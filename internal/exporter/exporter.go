@@ -4,36 +4,104 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/config"
 	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/domain"
 	"github.com/ranas-mukminov/kkt-54fz-monitoring/pkg/logger"
 )
 
 // Exporter implements Prometheus exporter for KKT metrics
 type Exporter struct {
+	cfg config.ExporterConfig
 	log *logger.Logger
 
+	registerer prometheus.Registerer
+	gatherer   prometheus.Gatherer
+
 	// Metrics
 	kktStatus           *prometheus.GaugeVec
-	kktDocumentsTotal   *prometheus.GaugeVec
-	kktErrorsTotal      *prometheus.GaugeVec
+	kktDocumentsTotal   *prometheus.CounterVec
+	kktErrorsTotal      *prometheus.CounterVec
 	kktOFDSyncStatus    *prometheus.GaugeVec
 	kktShiftStatus      *prometheus.GaugeVec
 	kktLastDocumentTime *prometheus.GaugeVec
 	kktFDMemoryUsage    *prometheus.GaugeVec
 	kktDocumentsPerHour *prometheus.GaugeVec
 	kktAvgSyncTime      *prometheus.GaugeVec
+	kktLastDocumentNum  *prometheus.GaugeVec
+	kktDeviceInfo       *prometheus.GaugeVec
+	kktFNExpiry         *prometheus.GaugeVec
+	kktFNDaysLeft       *prometheus.GaugeVec
+	kktFNDocsRemaining  *prometheus.GaugeVec
+	kktFNInfo           *prometheus.GaugeVec
+	kktUnsentDocuments  *prometheus.GaugeVec
+	kktOldestUnsentAge  *prometheus.GaugeVec
+	kktShiftOpened      *prometheus.GaugeVec
+	kktShiftNumber      *prometheus.GaugeVec
+	kktOFDAckLatency    *prometheus.HistogramVec
+	kktReceiptsTotal    *prometheus.CounterVec
+	kktReceiptAmount    *prometheus.CounterVec
+	kktReceiptVATAmount *prometheus.CounterVec
+
+	// totals holds the last reported collector totals of counter series
+	totals map[*prometheus.CounterVec]map[string]float64
+
+	// devices tracks when each device last reported
+	devices map[string]*deviceSeries
+	// staleAfter overrides StaleAfter for the devices of a collector
+	staleAfter map[string]time.Duration
+	// inventoryOFD is the OFD provider of inventory devices by KKT ID
+	inventoryOFD map[string]string
+
+	healthSource HealthSource
+	// handlers are additional handlers of the HTTP server by pattern
+	handlers map[string]http.Handler
+	now      func() time.Time
 
 	mu sync.RWMutex
 }
 
-// New creates a new Prometheus exporter
-func New(log *logger.Logger) *Exporter {
+// deviceVec is a metric vector whose series can be deleted by device labels
+type deviceVec interface {
+	DeletePartialMatch(labels prometheus.Labels) int
+}
+
+// deviceSeries is a device exporting series
+type deviceSeries struct {
+	collector string
+	kktID     string
+	lastSeen  time.Time
+	stale     bool
+	fnExpiry  time.Time
+	// oldestUnsent is the time of the oldest unsent document
+	oldestUnsent time.Time
+}
+
+// New creates a new Prometheus exporter with its own registry
+func New(cfg config.ExporterConfig, log *logger.Logger) *Exporter {
+	registry := prometheus.NewRegistry()
+	return NewWithRegistry(cfg, registry, registry, log)
+}
+
+// NewWithRegistry creates a new Prometheus exporter that registers its
+// metrics with registerer and serves the metrics of gatherer
+func NewWithRegistry(cfg config.ExporterConfig, registerer prometheus.Registerer,
+	gatherer prometheus.Gatherer, log *logger.Logger) *Exporter {
 	e := &Exporter{
-		log: log,
+		cfg:        cfg,
+		log:        log,
+		registerer: registerer,
+		gatherer:   gatherer,
+		totals:     make(map[*prometheus.CounterVec]map[string]float64),
+		devices:    make(map[string]*deviceSeries),
+		staleAfter: make(map[string]time.Duration),
+		now:        time.Now,
 	}
 
 	e.initMetrics()
@@ -49,23 +117,23 @@ func (e *Exporter) initMetrics() {
 			Name: "kkt_status",
 			Help: "KKT device status (0=unavailable, 1=running, 2=error)",
 		},
-		[]string{"kkt_id"},
+		[]string{"collector", "kkt_id"},
 	)
 
-	e.kktDocumentsTotal = prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
+	e.kktDocumentsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
 			Name: "kkt_documents_total",
 			Help: "Total number of fiscal documents",
 		},
-		[]string{"kkt_id"},
+		[]string{"collector", "kkt_id"},
 	)
 
-	e.kktErrorsTotal = prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
+	e.kktErrorsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
 			Name: "kkt_errors_total",
 			Help: "Total number of errors by type",
 		},
-		[]string{"kkt_id", "error_type"},
+		[]string{"collector", "kkt_id", "error_type"},
 	)
 
 	e.kktOFDSyncStatus = prometheus.NewGaugeVec(
@@ -73,7 +141,7 @@ func (e *Exporter) initMetrics() {
 			Name: "kkt_ofd_sync_status",
 			Help: "OFD synchronization status (0=unknown, 1=synced, 2=pending, 3=error)",
 		},
-		[]string{"kkt_id"},
+		[]string{"collector", "kkt_id"},
 	)
 
 	e.kktShiftStatus = prometheus.NewGaugeVec(
@@ -81,7 +149,7 @@ func (e *Exporter) initMetrics() {
 			Name: "kkt_shift_status",
 			Help: "Shift status (0=closed, 1=open)",
 		},
-		[]string{"kkt_id"},
+		[]string{"collector", "kkt_id"},
 	)
 
 	e.kktLastDocumentTime = prometheus.NewGaugeVec(
@@ -89,7 +157,7 @@ func (e *Exporter) initMetrics() {
 			Name: "kkt_last_document_timestamp",
 			Help: "Timestamp of last fiscal document (Unix time)",
 		},
-		[]string{"kkt_id"},
+		[]string{"collector", "kkt_id"},
 	)
 
 	e.kktFDMemoryUsage = prometheus.NewGaugeVec(
@@ -97,15 +165,15 @@ func (e *Exporter) initMetrics() {
 			Name: "kkt_fd_memory_usage_percent",
 			Help: "Fiscal drive memory usage percentage",
 		},
-		[]string{"kkt_id"},
+		[]string{"collector", "kkt_id"},
 	)
 
 	e.kktDocumentsPerHour = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "kkt_documents_per_hour",
-			Help: "Average documents processed per hour",
+			Help: "Documents processed in the last hour",
 		},
-		[]string{"kkt_id"},
+		[]string{"collector", "kkt_id"},
 	)
 
 	e.kktAvgSyncTime = prometheus.NewGaugeVec(
@@ -113,13 +181,136 @@ func (e *Exporter) initMetrics() {
 			Name: "kkt_average_sync_time_seconds",
 			Help: "Average OFD synchronization time in seconds",
 		},
-		[]string{"kkt_id"},
+		[]string{"collector", "kkt_id"},
+	)
+
+	e.kktLastDocumentNum = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "kkt_last_document_number",
+			Help: "Fiscal number of the last document",
+		},
+		[]string{"collector", "kkt_id"},
+	)
+
+	e.kktDeviceInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "kkt_device_info",
+			Help: "KKT device inventory record (always 1)",
+		},
+		[]string{"kkt_id", "store", "address", "region", "inn", "model", "ffd_version", "ofd"},
+	)
+
+	e.kktFNExpiry = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "kkt_fn_expiry_timestamp_seconds",
+			Help: "Expiry date of the fiscal drive (Unix time)",
+		},
+		[]string{"collector", "kkt_id"},
+	)
+
+	e.kktFNDaysLeft = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "kkt_fn_days_until_expiry",
+			Help: "Days until the fiscal drive expires",
+		},
+		[]string{"collector", "kkt_id"},
+	)
+
+	e.kktFNDocsRemaining = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "kkt_fn_documents_remaining",
+			Help: "Number of documents the fiscal drive can still store",
+		},
+		[]string{"collector", "kkt_id"},
+	)
+
+	e.kktFNInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "kkt_fn_info",
+			Help: "Fiscal drive installed in the KKT (always 1)",
+		},
+		[]string{"collector", "kkt_id", "fn_serial"},
+	)
+
+	e.kktUnsentDocuments = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "kkt_ofd_unsent_documents",
+			Help: "Number of documents not acknowledged by the OFD",
+		},
+		[]string{"collector", "kkt_id"},
+	)
+
+	e.kktOldestUnsentAge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "kkt_ofd_oldest_unsent_age_seconds",
+			Help: "Age of the oldest document not acknowledged by the OFD (0 when all are acknowledged)",
+		},
+		[]string{"collector", "kkt_id"},
+	)
+
+	e.kktShiftOpened = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "kkt_shift_opened_timestamp",
+			Help: "Time the open shift was opened (Unix time, 0 when the shift is closed)",
+		},
+		[]string{"collector", "kkt_id"},
+	)
+
+	e.kktShiftNumber = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "kkt_shift_number",
+			Help: "Number of the current or last shift",
+		},
+		[]string{"collector", "kkt_id"},
+	)
+
+	latencyOpts := prometheus.HistogramOpts{
+		Name:    "kkt_ofd_ack_latency_seconds",
+		Help:    "Time from sending a document to the OFD to its acknowledgement",
+		Buckets: []float64{0.5, 1, 2, 5, 10, 30, 60, 300, 900, 3600, 6 * 3600, 24 * 3600},
+	}
+	if e.cfg.NativeHistograms {
+		latencyOpts.NativeHistogramBucketFactor = 1.1
+		latencyOpts.NativeHistogramMaxBucketNumber = 160
+		latencyOpts.NativeHistogramMinResetDuration = time.Hour
+	}
+	e.kktOFDAckLatency = prometheus.NewHistogramVec(latencyOpts, []string{"collector", "kkt_id", "ofd"})
+
+	e.kktReceiptsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kkt_receipts_total",
+			Help: "Total number of receipts by operation type and taxation system",
+		},
+		[]string{"collector", "kkt_id", "operation_type", "taxation_system"},
+	)
+
+	e.kktReceiptAmount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kkt_receipt_amount_rubles_total",
+			Help: "Total amount of receipts by operation type and taxation system",
+		},
+		[]string{"collector", "kkt_id", "operation_type", "taxation_system"},
+	)
+
+	e.kktReceiptVATAmount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kkt_receipt_items_amount_rubles_total",
+			Help: "Total amount of receipt items by operation type and VAT rate",
+		},
+		[]string{"collector", "kkt_id", "operation_type", "vat_rate"},
 	)
 }
 
-// registerMetrics registers metrics with Prometheus
+// registerMetrics registers metrics with the registerer
 func (e *Exporter) registerMetrics() {
-	prometheus.MustRegister(
+	if e.cfg.GoCollector {
+		e.registerer.MustRegister(collectors.NewGoCollector())
+	}
+	if e.cfg.ProcessCollector {
+		e.registerer.MustRegister(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	}
+
+	e.registerer.MustRegister(
 		e.kktStatus,
 		e.kktDocumentsTotal,
 		e.kktErrorsTotal,
@@ -129,44 +320,327 @@ func (e *Exporter) registerMetrics() {
 		e.kktFDMemoryUsage,
 		e.kktDocumentsPerHour,
 		e.kktAvgSyncTime,
+		e.kktLastDocumentNum,
+		e.kktDeviceInfo,
+		e.kktFNExpiry,
+		e.kktFNDaysLeft,
+		e.kktFNDocsRemaining,
+		e.kktFNInfo,
+		e.kktUnsentDocuments,
+		e.kktOldestUnsentAge,
+		e.kktShiftOpened,
+		e.kktShiftNumber,
+		e.kktOFDAckLatency,
+		e.kktReceiptsTotal,
+		e.kktReceiptAmount,
+		e.kktReceiptVATAmount,
 	)
 }
 
+// Register registers additional metrics, e.g. collector self-monitoring
+func (e *Exporter) Register(cs ...prometheus.Collector) {
+	e.registerer.MustRegister(cs...)
+}
+
+// Handle serves an additional handler, e.g. the API, on the HTTP server.
+// It must be called before Start.
+func (e *Exporter) Handle(pattern string, handler http.Handler) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.handlers == nil {
+		e.handlers = make(map[string]http.Handler)
+	}
+	e.handlers[pattern] = handler
+}
+
+// SetInventory exports the device inventory as kkt_device_info, replacing
+// the previous inventory
+func (e *Exporter) SetInventory(devices []config.DeviceConfig) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.kktDeviceInfo.Reset()
+	e.inventoryOFD = make(map[string]string, len(devices))
+	for _, d := range devices {
+		e.inventoryOFD[d.KKTID] = d.OFD
+		e.kktDeviceInfo.WithLabelValues(d.KKTID, d.Store, d.Address, d.Region,
+			d.INN, d.Model, d.FFDVersion, d.OFD).Set(1)
+	}
+}
+
+// SetStaleAfter sets the silence after which a device of a collector is
+// reported unavailable, instead of StaleAfter
+func (e *Exporter) SetStaleAfter(collector string, staleAfter time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.staleAfter[collector] = staleAfter
+}
+
 // UpdateMetrics updates metrics from domain.Metrics
 func (e *Exporter) UpdateMetrics(metrics domain.Metrics) {
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
-	e.kktStatus.WithLabelValues(metrics.KKTID).Set(float64(metrics.Status))
-	e.kktDocumentsTotal.WithLabelValues(metrics.KKTID).Set(float64(metrics.DocumentsTotal))
-	e.kktOFDSyncStatus.WithLabelValues(metrics.KKTID).Set(float64(metrics.OFDSyncStatus))
-	e.kktShiftStatus.WithLabelValues(metrics.KKTID).Set(float64(metrics.ShiftStatus))
-	e.kktLastDocumentTime.WithLabelValues(metrics.KKTID).Set(float64(metrics.LastDocumentTime.Unix()))
-	e.kktFDMemoryUsage.WithLabelValues(metrics.KKTID).Set(metrics.FDMemoryUsage)
-	e.kktDocumentsPerHour.WithLabelValues(metrics.KKTID).Set(metrics.DocumentsPerHour)
-	e.kktAvgSyncTime.WithLabelValues(metrics.KKTID).Set(metrics.AverageSyncTime)
+	key := seriesKey(metrics.Collector, metrics.KKTID)
+	device, ok := e.devices[key]
+	if !ok {
+		device = &deviceSeries{collector: metrics.Collector, kktID: metrics.KKTID}
+		e.devices[key] = device
+	}
+	device.lastSeen = e.now()
+	device.stale = false
+
+	e.kktStatus.WithLabelValues(metrics.Collector, metrics.KKTID).Set(float64(metrics.Status))
+	if _, seen := e.totals[e.kktDocumentsTotal][seriesKey(metrics.Collector, metrics.KKTID)]; !seen {
+		// Start error counters at zero so that increase() sees the first error
+		for _, et := range domain.ErrorTypes() {
+			e.kktErrorsTotal.WithLabelValues(metrics.Collector, metrics.KKTID, domain.EnumLabel(et))
+		}
+	}
+	e.addTotal(e.kktDocumentsTotal, float64(metrics.DocumentsTotal), metrics.Collector, metrics.KKTID)
+	e.kktOFDSyncStatus.WithLabelValues(metrics.Collector, metrics.KKTID).Set(float64(metrics.OFDSyncStatus))
+	e.kktShiftStatus.WithLabelValues(metrics.Collector, metrics.KKTID).Set(float64(metrics.ShiftStatus))
+	e.updateShift(metrics)
+	e.observeAckLatencies(metrics)
+
+	for _, r := range metrics.Receipts {
+		op, tax := domain.EnumLabel(r.OperationType), domain.EnumLabel(r.TaxationSystem)
+		e.addTotal(e.kktReceiptsTotal, float64(r.Count), metrics.Collector, metrics.KKTID, op, tax)
+		e.addTotal(e.kktReceiptAmount, r.Amount, metrics.Collector, metrics.KKTID, op, tax)
+	}
+	for _, v := range metrics.ReceiptVAT {
+		e.addTotal(e.kktReceiptVATAmount, v.Amount, metrics.Collector, metrics.KKTID,
+			domain.EnumLabel(v.OperationType), vatRateLabel(v.VATRate))
+	}
+	e.kktLastDocumentTime.WithLabelValues(metrics.Collector, metrics.KKTID).Set(float64(metrics.LastDocumentTime.Unix()))
+	e.kktFDMemoryUsage.WithLabelValues(metrics.Collector, metrics.KKTID).Set(metrics.FDMemoryUsage)
+	e.kktDocumentsPerHour.WithLabelValues(metrics.Collector, metrics.KKTID).Set(metrics.DocumentsPerHour)
+	e.kktAvgSyncTime.WithLabelValues(metrics.Collector, metrics.KKTID).Set(metrics.AverageSyncTime)
+	if metrics.LastDocumentNumber > 0 {
+		e.kktLastDocumentNum.WithLabelValues(metrics.Collector, metrics.KKTID).Set(float64(metrics.LastDocumentNumber))
+	}
 
-	// Update error gauges with current counts
 	for errorType, count := range metrics.ErrorsByType {
-		e.kktErrorsTotal.WithLabelValues(metrics.KKTID, errorTypeName(errorType)).Set(float64(count))
+		e.addTotal(e.kktErrorsTotal, float64(count), metrics.Collector, metrics.KKTID, domain.EnumLabel(errorType))
+	}
+
+	e.kktUnsentDocuments.WithLabelValues(metrics.Collector, metrics.KKTID).Set(float64(metrics.UnsentDocuments))
+	device.oldestUnsent = time.Time{}
+	if metrics.UnsentDocuments > 0 {
+		device.oldestUnsent = metrics.OldestUnsentTime
+	}
+	e.setUnsentAge(device, device.lastSeen)
+
+	if !metrics.FiscalDrive.ExpiryDate.IsZero() {
+		device.fnExpiry = metrics.FiscalDrive.ExpiryDate
+	}
+	e.updateFiscalDrive(metrics.Collector, metrics.KKTID, metrics.FiscalDrive)
+}
+
+// observeAckLatencies records OFD acknowledgement latencies. The OFD of the
+// inventory is used when the collector does not know it.
+func (e *Exporter) observeAckLatencies(metrics domain.Metrics) {
+	if len(metrics.OFDAckLatencies) == 0 {
+		return
+	}
+
+	ofd := metrics.OFD
+	if ofd == "" {
+		ofd = e.inventoryOFD[metrics.KKTID]
+	}
+	histogram := e.kktOFDAckLatency.WithLabelValues(metrics.Collector, metrics.KKTID, ofd)
+	for _, latency := range metrics.OFDAckLatencies {
+		histogram.Observe(latency)
+	}
+}
+
+// updateShift exports the shift opening time and number
+func (e *Exporter) updateShift(metrics domain.Metrics) {
+	opened := 0.0
+	if metrics.ShiftStatus == domain.ShiftStatusOpen && !metrics.ShiftOpenedAt.IsZero() {
+		opened = float64(metrics.ShiftOpenedAt.Unix())
+	}
+	e.kktShiftOpened.WithLabelValues(metrics.Collector, metrics.KKTID).Set(opened)
+
+	if metrics.ShiftNumber > 0 {
+		e.kktShiftNumber.WithLabelValues(metrics.Collector, metrics.KKTID).Set(float64(metrics.ShiftNumber))
+	}
+}
+
+// setUnsentAge exports the age of the oldest unsent document of a device
+func (e *Exporter) setUnsentAge(device *deviceSeries, now time.Time) {
+	age := 0.0
+	if !device.oldestUnsent.IsZero() {
+		age = now.Sub(device.oldestUnsent).Seconds()
+	}
+	e.kktOldestUnsentAge.WithLabelValues(device.collector, device.kktID).Set(age)
+}
+
+// updateFiscalDrive exports the known fiscal drive fields
+func (e *Exporter) updateFiscalDrive(collector, kktID string, fd domain.FiscalDrive) {
+	if fd.Number != "" {
+		// Drop the series of a replaced drive
+		e.kktFNInfo.DeletePartialMatch(prometheus.Labels{"collector": collector, "kkt_id": kktID})
+		e.kktFNInfo.WithLabelValues(collector, kktID, fd.Number).Set(1)
+	}
+	if !fd.ExpiryDate.IsZero() {
+		e.kktFNExpiry.WithLabelValues(collector, kktID).Set(float64(fd.ExpiryDate.Unix()))
+		e.kktFNDaysLeft.WithLabelValues(collector, kktID).Set(fd.ExpiryDate.Sub(e.now()).Hours() / 24)
+	}
+	if fd.DocumentsMax > 0 {
+		e.kktFNDocsRemaining.WithLabelValues(collector, kktID).Set(float64(fd.DocumentsMax - fd.DocumentsUsed))
+	}
+}
+
+// addTotal increments a counter by the growth of a collector total. A total
+// lower than the previous one means the collector restarted counting, so
+// the whole new total is added.
+func (e *Exporter) addTotal(counter *prometheus.CounterVec, total float64, labels ...string) {
+	totals, ok := e.totals[counter]
+	if !ok {
+		totals = make(map[string]float64)
+		e.totals[counter] = totals
+	}
+
+	key := seriesKey(labels...)
+	delta := total - totals[key]
+	if delta < 0 {
+		delta = total
+	}
+	totals[key] = total
+
+	c := counter.WithLabelValues(labels...)
+	if delta > 0 {
+		c.Add(delta)
+	}
+}
+
+// ExpireStale reports devices silent for longer than StaleAfter, or the
+// window of their collector, as unavailable and removes all series of devices silent for longer than
+// DeleteAfter. Days until fiscal drive expiry and the age of unsent
+// documents of the remaining devices are recalculated.
+func (e *Exporter) ExpireStale(now time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for key, device := range e.devices {
+		if !device.fnExpiry.IsZero() {
+			e.kktFNDaysLeft.WithLabelValues(device.collector, device.kktID).Set(device.fnExpiry.Sub(now).Hours() / 24)
+		}
+		e.setUnsentAge(device, now)
+
+		staleAfter := e.cfg.StaleAfter
+		if d, ok := e.staleAfter[device.collector]; ok {
+			staleAfter = d
+		}
+
+		silence := now.Sub(device.lastSeen)
+		switch {
+		case silence > e.cfg.DeleteAfter:
+			e.deleteDevice(key, device)
+			e.log.Info("Removed series of silent device",
+				"collector", device.collector, "kkt_id", device.kktID, "last_seen", device.lastSeen)
+		case silence > staleAfter && !device.stale:
+			device.stale = true
+			e.kktStatus.WithLabelValues(device.collector, device.kktID).Set(float64(domain.KKTStatusUnavailable))
+			e.log.Warn("Device stopped reporting, marked unavailable",
+				"collector", device.collector, "kkt_id", device.kktID, "last_seen", device.lastSeen)
+		}
+	}
+}
+
+// deleteDevice removes all series and counter totals of a device
+func (e *Exporter) deleteDevice(key string, device *deviceSeries) {
+	labels := prometheus.Labels{"collector": device.collector, "kkt_id": device.kktID}
+	for _, vec := range e.deviceVecs() {
+		vec.DeletePartialMatch(labels)
+	}
+
+	for _, totals := range e.totals {
+		for k := range totals {
+			if k == key || strings.HasPrefix(k, key+"\xff") {
+				delete(totals, k)
+			}
+		}
+	}
+	delete(e.devices, key)
+}
+
+// deviceVecs returns the metric vectors labelled by device
+func (e *Exporter) deviceVecs() []deviceVec {
+	return []deviceVec{
+		e.kktStatus,
+		e.kktDocumentsTotal,
+		e.kktErrorsTotal,
+		e.kktOFDSyncStatus,
+		e.kktShiftStatus,
+		e.kktLastDocumentTime,
+		e.kktFDMemoryUsage,
+		e.kktDocumentsPerHour,
+		e.kktAvgSyncTime,
+		e.kktLastDocumentNum,
+		e.kktFNExpiry,
+		e.kktFNDaysLeft,
+		e.kktFNDocsRemaining,
+		e.kktFNInfo,
+		e.kktUnsentDocuments,
+		e.kktOldestUnsentAge,
+		e.kktShiftOpened,
+		e.kktShiftNumber,
+		e.kktOFDAckLatency,
+		e.kktReceiptsTotal,
+		e.kktReceiptAmount,
+		e.kktReceiptVATAmount,
+	}
+}
+
+// expireLoop runs ExpireStale until ctx is cancelled
+func (e *Exporter) expireLoop(ctx context.Context) {
+	interval := e.cfg.StaleAfter / 2
+	if interval <= 0 || interval > 30*time.Second {
+		interval = 30 * time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			e.ExpireStale(e.now())
+		}
 	}
 }
 
 // Handler returns the HTTP handler for metrics endpoint
 func (e *Exporter) Handler() http.Handler {
-	return promhttp.Handler()
+	return promhttp.InstrumentMetricHandler(e.registerer,
+		promhttp.HandlerFor(e.gatherer, promhttp.HandlerOpts{}))
 }
 
 // Start starts the exporter
-func (e *Exporter) Start(ctx context.Context, addr string) error {
+func (e *Exporter) Start(ctx context.Context, cfg config.ServerConfig) error {
 	mux := http.NewServeMux()
-	mux.Handle("/metrics", e.Handler())
+	mux.Handle(cfg.MetricsPath, e.Handler())
+	mux.Handle("/healthz", e.HealthHandler())
+	e.mu.RLock()
+	for pattern, handler := range e.handlers {
+		mux.Handle(pattern, handler)
+	}
+	e.mu.RUnlock()
 
+	addr := fmt.Sprintf(":%d", cfg.Port)
 	server := &http.Server{
 		Addr:    addr,
 		Handler: mux,
 	}
 
+	go e.expireLoop(ctx)
+
 	go func() {
 		<-ctx.Done()
 		e.log.Info("Shutting down metrics server")
@@ -175,7 +649,7 @@ func (e *Exporter) Start(ctx context.Context, addr string) error {
 		}
 	}()
 
-	e.log.Info("Starting metrics server", "addr", addr)
+	e.log.Info("Starting metrics server", "addr", addr, "metrics_path", cfg.MetricsPath)
 	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		return fmt.Errorf("failed to start metrics server: %w", err)
 	}
@@ -183,24 +657,13 @@ func (e *Exporter) Start(ctx context.Context, addr string) error {
 	return nil
 }
 
-// errorTypeName converts ErrorType to string
-func errorTypeName(et domain.ErrorType) string {
-	switch et {
-	case domain.ErrorTypeNetwork:
-		return "network"
-	case domain.ErrorTypeFiscalDrive:
-		return "fiscal_drive"
-	case domain.ErrorTypeOFD:
-		return "ofd"
-	case domain.ErrorTypePrinter:
-		return "printer"
-	case domain.ErrorTypeHardware:
-		return "hardware"
-	case domain.ErrorTypeSoftware:
-		return "software"
-	case domain.ErrorTypeConfiguration:
-		return "configuration"
-	default:
-		return "unknown"
-	}
+// vatRateLabel returns the vat_rate label value: the VAT rate name without
+// its "vat" prefix, e.g. "20" for "vat20"
+func vatRateLabel(rate domain.VATRate) string {
+	return strings.TrimPrefix(domain.EnumLabel(rate), "vat")
+}
+
+// seriesKey joins label values into a map key
+func seriesKey(labels ...string) string {
+	return strings.Join(labels, "\xff")
 }
@@ -0,0 +1,332 @@
+package exporter
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/config"
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/domain"
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/pkg/logger"
+)
+
+func TestExporter_AddTotal(t *testing.T) {
+	e := &Exporter{totals: make(map[*prometheus.CounterVec]map[string]float64)}
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_total"}, []string{"kkt_id"})
+
+	tests := []struct {
+		name  string
+		total float64
+		want  float64
+	}{
+		{name: "first total", total: 5, want: 5},
+		{name: "growth", total: 8, want: 8},
+		{name: "unchanged", total: 8, want: 8},
+		// The collector restarted counting from zero
+		{name: "reset", total: 2, want: 10},
+		{name: "growth after reset", total: 3, want: 11},
+	}
+
+	for _, tt := range tests {
+		e.addTotal(counter, tt.total, "kkt-001")
+		if got := testutil.ToFloat64(counter.WithLabelValues("kkt-001")); got != tt.want {
+			t.Errorf("%s: expected counter %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestExporter_ExpireStale(t *testing.T) {
+	e := New(config.ExporterConfig{StaleAfter: 10 * time.Minute, DeleteAfter: time.Hour}, logger.New("error", "text"))
+	start := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	e.now = func() time.Time { return start }
+
+	for _, id := range []string{"kkt-001", "kkt-002"} {
+		e.UpdateMetrics(domain.Metrics{
+			Collector:      "store-1",
+			KKTID:          id,
+			Status:         domain.KKTStatusRunning,
+			DocumentsTotal: 5,
+			ErrorsByType:   map[domain.ErrorType]int64{domain.ErrorTypeOFD: 1},
+		})
+	}
+
+	// kkt-002 keeps reporting
+	e.now = func() time.Time { return start.Add(15 * time.Minute) }
+	e.UpdateMetrics(domain.Metrics{Collector: "store-1", KKTID: "kkt-002", Status: domain.KKTStatusRunning})
+
+	e.ExpireStale(start.Add(15 * time.Minute))
+	if got := testutil.ToFloat64(e.kktStatus.WithLabelValues("store-1", "kkt-001")); got != float64(domain.KKTStatusUnavailable) {
+		t.Errorf("Expected silent device to be unavailable, got status %v", got)
+	}
+	if got := testutil.ToFloat64(e.kktStatus.WithLabelValues("store-1", "kkt-002")); got != float64(domain.KKTStatusRunning) {
+		t.Errorf("Expected reporting device to stay running, got status %v", got)
+	}
+
+	e.ExpireStale(start.Add(61 * time.Minute))
+	if got := testutil.CollectAndCount(e.kktDocumentsTotal); got != 1 {
+		t.Errorf("Expected 1 documents series after expiry, got %d", got)
+	}
+	if got := testutil.CollectAndCount(e.kktErrorsTotal); got != 7 {
+		t.Errorf("Expected 7 error series after expiry, got %d", got)
+	}
+	if _, ok := e.devices[seriesKey("store-1", "kkt-001")]; ok {
+		t.Error("Expected expired device to be forgotten")
+	}
+
+	// A device coming back starts its counters from scratch
+	e.UpdateMetrics(domain.Metrics{Collector: "store-1", KKTID: "kkt-001", DocumentsTotal: 5})
+	if got := testutil.ToFloat64(e.kktDocumentsTotal.WithLabelValues("store-1", "kkt-001")); got != 5 {
+		t.Errorf("Expected documents counter 5 after return, got %v", got)
+	}
+}
+
+func TestExporter_CollectorStaleAfter(t *testing.T) {
+	e := New(config.ExporterConfig{StaleAfter: 10 * time.Minute, DeleteAfter: 24 * time.Hour}, logger.New("error", "text"))
+	start := time.Date(2024, 1, 15, 22, 0, 0, 0, time.UTC)
+	e.now = func() time.Time { return start }
+	e.SetStaleAfter("store-1", 12*time.Hour)
+
+	e.UpdateMetrics(domain.Metrics{Collector: "store-1", KKTID: "kkt-001", Status: domain.KKTStatusRunning})
+	e.UpdateMetrics(domain.Metrics{Collector: "ofd", KKTID: "kkt-001", Status: domain.KKTStatusRunning})
+
+	// The register logs nothing at night
+	e.ExpireStale(start.Add(8 * time.Hour))
+	if got := testutil.ToFloat64(e.kktStatus.WithLabelValues("store-1", "kkt-001")); got != float64(domain.KKTStatusRunning) {
+		t.Errorf("Expected the device to stay running within the window of its collector, got status %v", got)
+	}
+	if got := testutil.ToFloat64(e.kktStatus.WithLabelValues("ofd", "kkt-001")); got != float64(domain.KKTStatusUnavailable) {
+		t.Errorf("Expected the default window for other collectors, got status %v", got)
+	}
+
+	e.ExpireStale(start.Add(13 * time.Hour))
+	if got := testutil.ToFloat64(e.kktStatus.WithLabelValues("store-1", "kkt-001")); got != float64(domain.KKTStatusUnavailable) {
+		t.Errorf("Expected the device to be unavailable past the window, got status %v", got)
+	}
+}
+
+func TestExporter_Registry(t *testing.T) {
+	tests := []struct {
+		name   string
+		cfg    config.ExporterConfig
+		wantGo bool
+	}{
+		{name: "kkt metrics only", cfg: config.ExporterConfig{}},
+		{name: "with go collector", cfg: config.ExporterConfig{GoCollector: true}, wantGo: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Every exporter has its own registry, so creating several must not panic
+			e := New(tt.cfg, logger.New("error", "text"))
+			e.UpdateMetrics(domain.Metrics{Collector: "store-1", KKTID: "kkt-001", Status: domain.KKTStatusRunning})
+
+			rec := httptest.NewRecorder()
+			e.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+			body, _ := io.ReadAll(rec.Body)
+
+			if !strings.Contains(string(body), `kkt_status{collector="store-1",kkt_id="kkt-001"} 1`) {
+				t.Errorf("Expected kkt_status in output, got:\n%s", body)
+			}
+			if got := strings.Contains(string(body), "go_goroutines"); got != tt.wantGo {
+				t.Errorf("Expected go metrics %v, got %v", tt.wantGo, got)
+			}
+		})
+	}
+}
+
+func TestExporter_SetInventory(t *testing.T) {
+	e := New(config.ExporterConfig{}, logger.New("error", "text"))
+
+	e.SetInventory([]config.DeviceConfig{
+		{KKTID: "kkt-001", Store: "store-1", INN: "7700000000"},
+		{KKTID: "kkt-002", Store: "store-2"},
+	})
+	if got := testutil.CollectAndCount(e.kktDeviceInfo); got != 2 {
+		t.Errorf("Expected 2 device info series, got %d", got)
+	}
+	if got := testutil.ToFloat64(e.kktDeviceInfo.WithLabelValues("kkt-001", "store-1", "", "", "7700000000", "", "", "")); got != 1 {
+		t.Errorf("Expected device info value 1, got %v", got)
+	}
+
+	// A new inventory replaces the old one
+	e.SetInventory([]config.DeviceConfig{{KKTID: "kkt-003"}})
+	if got := testutil.CollectAndCount(e.kktDeviceInfo); got != 1 {
+		t.Errorf("Expected 1 device info series after replace, got %d", got)
+	}
+}
+
+func TestExporter_FiscalDrive(t *testing.T) {
+	e := New(config.ExporterConfig{StaleAfter: time.Hour, DeleteAfter: 24 * time.Hour}, logger.New("error", "text"))
+	now := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	e.now = func() time.Time { return now }
+
+	e.UpdateMetrics(domain.Metrics{
+		Collector: "ofd",
+		KKTID:     "kkt-001",
+		FiscalDrive: domain.FiscalDrive{
+			Number:        "9960440300000001",
+			ExpiryDate:    now.Add(30 * 24 * time.Hour),
+			DocumentsMax:  250000,
+			DocumentsUsed: 240000,
+		},
+	})
+
+	if got := testutil.ToFloat64(e.kktFNDaysLeft.WithLabelValues("ofd", "kkt-001")); got != 30 {
+		t.Errorf("Expected 30 days until expiry, got %v", got)
+	}
+	if got := testutil.ToFloat64(e.kktFNDocsRemaining.WithLabelValues("ofd", "kkt-001")); got != 10000 {
+		t.Errorf("Expected 10000 documents remaining, got %v", got)
+	}
+
+	// Days are recalculated without new metrics
+	e.ExpireStale(now.Add(12 * time.Hour))
+	if got := testutil.ToFloat64(e.kktFNDaysLeft.WithLabelValues("ofd", "kkt-001")); got != 29.5 {
+		t.Errorf("Expected 29.5 days until expiry, got %v", got)
+	}
+
+	// A replaced drive drops the old serial
+	e.UpdateMetrics(domain.Metrics{Collector: "ofd", KKTID: "kkt-001", FiscalDrive: domain.FiscalDrive{Number: "9960440300000002"}})
+	if got := testutil.CollectAndCount(e.kktFNInfo); got != 1 {
+		t.Errorf("Expected 1 fn info series, got %d", got)
+	}
+	if got := testutil.ToFloat64(e.kktFNInfo.WithLabelValues("ofd", "kkt-001", "9960440300000002")); got != 1 {
+		t.Errorf("Expected fn info for the new drive, got %v", got)
+	}
+}
+
+func TestExporter_UnsentDocuments(t *testing.T) {
+	e := New(config.ExporterConfig{StaleAfter: time.Hour, DeleteAfter: 24 * time.Hour}, logger.New("error", "text"))
+	now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	e.now = func() time.Time { return now }
+
+	e.UpdateMetrics(domain.Metrics{
+		Collector:        "ofd",
+		KKTID:            "kkt-001",
+		UnsentDocuments:  3,
+		OldestUnsentTime: now.Add(-2 * time.Hour),
+	})
+	if got := testutil.ToFloat64(e.kktUnsentDocuments.WithLabelValues("ofd", "kkt-001")); got != 3 {
+		t.Errorf("Expected 3 unsent documents, got %v", got)
+	}
+	if got := testutil.ToFloat64(e.kktOldestUnsentAge.WithLabelValues("ofd", "kkt-001")); got != 7200 {
+		t.Errorf("Expected oldest unsent age 7200, got %v", got)
+	}
+
+	// The age grows without new metrics
+	e.ExpireStale(now.Add(30 * time.Minute))
+	if got := testutil.ToFloat64(e.kktOldestUnsentAge.WithLabelValues("ofd", "kkt-001")); got != 9000 {
+		t.Errorf("Expected oldest unsent age 9000, got %v", got)
+	}
+
+	e.UpdateMetrics(domain.Metrics{Collector: "ofd", KKTID: "kkt-001"})
+	if got := testutil.ToFloat64(e.kktOldestUnsentAge.WithLabelValues("ofd", "kkt-001")); got != 0 {
+		t.Errorf("Expected age 0 once all documents are sent, got %v", got)
+	}
+}
+
+func TestExporter_Shift(t *testing.T) {
+	e := New(config.ExporterConfig{}, logger.New("error", "text"))
+	opened := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+
+	e.UpdateMetrics(domain.Metrics{
+		Collector:     "store-1",
+		KKTID:         "kkt-001",
+		ShiftStatus:   domain.ShiftStatusOpen,
+		ShiftNumber:   12,
+		ShiftOpenedAt: opened,
+	})
+	if got := testutil.ToFloat64(e.kktShiftOpened.WithLabelValues("store-1", "kkt-001")); got != float64(opened.Unix()) {
+		t.Errorf("Expected shift opened at %d, got %v", opened.Unix(), got)
+	}
+	if got := testutil.ToFloat64(e.kktShiftNumber.WithLabelValues("store-1", "kkt-001")); got != 12 {
+		t.Errorf("Expected shift number 12, got %v", got)
+	}
+
+	e.UpdateMetrics(domain.Metrics{Collector: "store-1", KKTID: "kkt-001", ShiftStatus: domain.ShiftStatusClosed, ShiftNumber: 12})
+	if got := testutil.ToFloat64(e.kktShiftOpened.WithLabelValues("store-1", "kkt-001")); got != 0 {
+		t.Errorf("Expected shift opened 0 for a closed shift, got %v", got)
+	}
+}
+
+func TestExporter_OFDAckLatency(t *testing.T) {
+	e := New(config.ExporterConfig{}, logger.New("error", "text"))
+	e.SetInventory([]config.DeviceConfig{{KKTID: "kkt-001", OFD: "taxcom"}})
+
+	e.UpdateMetrics(domain.Metrics{Collector: "store-1", KKTID: "kkt-001", OFDAckLatencies: []float64{0.8, 3, 120}})
+	e.UpdateMetrics(domain.Metrics{Collector: "ofd", KKTID: "kkt-002", OFD: "kontur", OFDAckLatencies: []float64{1.5}})
+
+	want := `
+# HELP kkt_ofd_ack_latency_seconds Time from sending a document to the OFD to its acknowledgement
+# TYPE kkt_ofd_ack_latency_seconds histogram
+kkt_ofd_ack_latency_seconds_bucket{collector="ofd",kkt_id="kkt-002",ofd="kontur",le="0.5"} 0
+kkt_ofd_ack_latency_seconds_bucket{collector="ofd",kkt_id="kkt-002",ofd="kontur",le="1"} 0
+kkt_ofd_ack_latency_seconds_bucket{collector="ofd",kkt_id="kkt-002",ofd="kontur",le="2"} 1
+kkt_ofd_ack_latency_seconds_bucket{collector="ofd",kkt_id="kkt-002",ofd="kontur",le="5"} 1
+kkt_ofd_ack_latency_seconds_bucket{collector="ofd",kkt_id="kkt-002",ofd="kontur",le="10"} 1
+kkt_ofd_ack_latency_seconds_bucket{collector="ofd",kkt_id="kkt-002",ofd="kontur",le="30"} 1
+kkt_ofd_ack_latency_seconds_bucket{collector="ofd",kkt_id="kkt-002",ofd="kontur",le="60"} 1
+kkt_ofd_ack_latency_seconds_bucket{collector="ofd",kkt_id="kkt-002",ofd="kontur",le="300"} 1
+kkt_ofd_ack_latency_seconds_bucket{collector="ofd",kkt_id="kkt-002",ofd="kontur",le="900"} 1
+kkt_ofd_ack_latency_seconds_bucket{collector="ofd",kkt_id="kkt-002",ofd="kontur",le="3600"} 1
+kkt_ofd_ack_latency_seconds_bucket{collector="ofd",kkt_id="kkt-002",ofd="kontur",le="21600"} 1
+kkt_ofd_ack_latency_seconds_bucket{collector="ofd",kkt_id="kkt-002",ofd="kontur",le="86400"} 1
+kkt_ofd_ack_latency_seconds_bucket{collector="ofd",kkt_id="kkt-002",ofd="kontur",le="+Inf"} 1
+kkt_ofd_ack_latency_seconds_sum{collector="ofd",kkt_id="kkt-002",ofd="kontur"} 1.5
+kkt_ofd_ack_latency_seconds_count{collector="ofd",kkt_id="kkt-002",ofd="kontur"} 1
+kkt_ofd_ack_latency_seconds_bucket{collector="store-1",kkt_id="kkt-001",ofd="taxcom",le="0.5"} 0
+kkt_ofd_ack_latency_seconds_bucket{collector="store-1",kkt_id="kkt-001",ofd="taxcom",le="1"} 1
+kkt_ofd_ack_latency_seconds_bucket{collector="store-1",kkt_id="kkt-001",ofd="taxcom",le="2"} 1
+kkt_ofd_ack_latency_seconds_bucket{collector="store-1",kkt_id="kkt-001",ofd="taxcom",le="5"} 2
+kkt_ofd_ack_latency_seconds_bucket{collector="store-1",kkt_id="kkt-001",ofd="taxcom",le="10"} 2
+kkt_ofd_ack_latency_seconds_bucket{collector="store-1",kkt_id="kkt-001",ofd="taxcom",le="30"} 2
+kkt_ofd_ack_latency_seconds_bucket{collector="store-1",kkt_id="kkt-001",ofd="taxcom",le="60"} 2
+kkt_ofd_ack_latency_seconds_bucket{collector="store-1",kkt_id="kkt-001",ofd="taxcom",le="300"} 3
+kkt_ofd_ack_latency_seconds_bucket{collector="store-1",kkt_id="kkt-001",ofd="taxcom",le="900"} 3
+kkt_ofd_ack_latency_seconds_bucket{collector="store-1",kkt_id="kkt-001",ofd="taxcom",le="3600"} 3
+kkt_ofd_ack_latency_seconds_bucket{collector="store-1",kkt_id="kkt-001",ofd="taxcom",le="21600"} 3
+kkt_ofd_ack_latency_seconds_bucket{collector="store-1",kkt_id="kkt-001",ofd="taxcom",le="86400"} 3
+kkt_ofd_ack_latency_seconds_bucket{collector="store-1",kkt_id="kkt-001",ofd="taxcom",le="+Inf"} 3
+kkt_ofd_ack_latency_seconds_sum{collector="store-1",kkt_id="kkt-001",ofd="taxcom"} 123.8
+kkt_ofd_ack_latency_seconds_count{collector="store-1",kkt_id="kkt-001",ofd="taxcom"} 3
+`
+	if err := testutil.CollectAndCompare(e.kktOFDAckLatency, strings.NewReader(want)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestExporter_Receipts(t *testing.T) {
+	e := New(config.ExporterConfig{}, logger.New("error", "text"))
+	metrics := domain.Metrics{
+		Collector: "store-1",
+		KKTID:     "kkt-001",
+		Receipts: []domain.ReceiptTotal{
+			{OperationType: domain.OperationTypeSale, TaxationSystem: domain.TaxationSystemCommon, Count: 2, Amount: 550},
+		},
+		ReceiptVAT: []domain.VATTotal{
+			{OperationType: domain.OperationTypeSale, VATRate: domain.VATRate20, Amount: 550},
+			{OperationType: domain.OperationType(9), VATRate: domain.VATRate(7), Amount: 10},
+		},
+	}
+	e.UpdateMetrics(metrics)
+
+	metrics.Receipts[0].Count, metrics.Receipts[0].Amount = 3, 700
+	e.UpdateMetrics(metrics)
+
+	if got := testutil.ToFloat64(e.kktReceiptsTotal.WithLabelValues("store-1", "kkt-001", "sale", "common")); got != 3 {
+		t.Errorf("Expected 3 receipts, got %v", got)
+	}
+	if got := testutil.ToFloat64(e.kktReceiptAmount.WithLabelValues("store-1", "kkt-001", "sale", "common")); got != 700 {
+		t.Errorf("Expected receipt amount 700, got %v", got)
+	}
+	if got := testutil.ToFloat64(e.kktReceiptVATAmount.WithLabelValues("store-1", "kkt-001", "sale", "20")); got != 550 {
+		t.Errorf("Expected VAT 20 amount 550, got %v", got)
+	}
+	// Values without a name do not add series of their own
+	if got := testutil.ToFloat64(e.kktReceiptVATAmount.WithLabelValues("store-1", "kkt-001", "unknown", "unknown")); got != 10 {
+		t.Errorf("Expected unknown VAT amount 10, got %v", got)
+	}
+}
@@ -0,0 +1,55 @@
+package exporter
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/collector"
+)
+
+// HealthSource returns the health of the running collectors
+type HealthSource func() []collector.Health
+
+// healthResponse is the body of the health endpoint
+type healthResponse struct {
+	Status     string             `json:"status"`
+	Collectors []collector.Health `json:"collectors"`
+}
+
+// SetHealthSource sets the source of collector health for /healthz
+func (e *Exporter) SetHealthSource(source HealthSource) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.healthSource = source
+}
+
+// HealthHandler returns the HTTP handler of the health endpoint. It responds
+// with 503 when any collector is unhealthy.
+func (e *Exporter) HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		e.mu.RLock()
+		source := e.healthSource
+		e.mu.RUnlock()
+
+		resp := healthResponse{Status: "ok", Collectors: []collector.Health{}}
+		if source != nil {
+			resp.Collectors = source()
+		}
+
+		code := http.StatusOK
+		for _, h := range resp.Collectors {
+			if h.Status == collector.HealthUnhealthy {
+				resp.Status = "unhealthy"
+				code = http.StatusServiceUnavailable
+				break
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			e.log.Error("Failed to write health response", "error", err)
+		}
+	})
+}
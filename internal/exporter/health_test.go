@@ -0,0 +1,64 @@
+package exporter
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/collector"
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/pkg/logger"
+)
+
+func TestHealthHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		health     []collector.Health
+		wantCode   int
+		wantStatus string
+	}{
+		{name: "no collectors", wantCode: http.StatusOK, wantStatus: "ok"},
+		{
+			name: "healthy and starting",
+			health: []collector.Health{
+				{Collector: "store-1", Status: collector.HealthHealthy},
+				{Collector: "ofd", Status: collector.HealthStarting},
+			},
+			wantCode:   http.StatusOK,
+			wantStatus: "ok",
+		},
+		{
+			name: "one unhealthy",
+			health: []collector.Health{
+				{Collector: "store-1", Status: collector.HealthHealthy},
+				{Collector: "ofd", Status: collector.HealthUnhealthy, LastError: "timeout"},
+			},
+			wantCode:   http.StatusServiceUnavailable,
+			wantStatus: "unhealthy",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &Exporter{log: logger.New("error", "json")}
+			e.SetHealthSource(func() []collector.Health { return tt.health })
+
+			rec := httptest.NewRecorder()
+			e.HealthHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+			if rec.Code != tt.wantCode {
+				t.Errorf("Expected status code %d, got %d", tt.wantCode, rec.Code)
+			}
+			var resp healthResponse
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if resp.Status != tt.wantStatus {
+				t.Errorf("Expected status %s, got %s", tt.wantStatus, resp.Status)
+			}
+			if len(resp.Collectors) != len(tt.health) {
+				t.Errorf("Expected %d collectors, got %d", len(tt.health), len(resp.Collectors))
+			}
+		})
+	}
+}
@@ -0,0 +1,224 @@
+package history
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/domain"
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/pkg/logger"
+)
+
+// compactInterval is how often retention and downsampling run
+const compactInterval = time.Hour
+
+// Run compacts the store at start and then every compactInterval until
+// ctx is canceled
+func (s *Store) Run(ctx context.Context, log *logger.Logger) {
+	ticker := time.NewTicker(compactInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.Compact(); err != nil {
+			log.Error("Failed to compact history", "dir", s.dir, "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Compact deletes the segments of days past the retention and downsamples
+// the metrics segments of days older than DownsampleAfter. Segments are
+// downsampled without blocking appends.
+func (s *Store) Compact() error {
+	s.compactMu.Lock()
+	defer s.compactMu.Unlock()
+
+	pending, err := s.expire()
+	if err != nil {
+		return err
+	}
+	for _, seg := range pending {
+		if err := s.downsample(seg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expire deletes the segments of days past the retention and returns the
+// metrics segments to downsample
+func (s *Store) expire() ([]segment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var pending []segment
+	now := s.now()
+	for _, kind := range []string{kindMetrics, kindErrors} {
+		segments, err := s.segments(kind)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, seg := range segments {
+			end := seg.day.Add(24 * time.Hour)
+			switch {
+			case end.Before(now.Add(-s.cfg.Retention)):
+				if err := os.Remove(seg.path); err != nil {
+					return nil, fmt.Errorf("failed to delete history segment: %w", err)
+				}
+				delete(s.downsampled, seg.day.Format(dayLayout))
+			case kind == kindMetrics && !seg.downsampled && end.Before(now.Add(-s.cfg.DownsampleAfter)):
+				pending = append(pending, seg)
+			}
+		}
+	}
+
+	return pending, nil
+}
+
+// sampleKey identifies the samples of a device and collector in an interval
+type sampleKey struct {
+	kktID, collector string
+	bucket           time.Time
+}
+
+// sampler reduces metrics to one sample per device, collector and interval:
+// the last sample of the interval, carrying the OFD acknowledgement
+// latencies of all its samples
+type sampler struct {
+	interval  time.Duration
+	buckets   map[sampleKey]domain.Metrics
+	latencies map[sampleKey][]float64
+}
+
+// newSampler creates a sampler of the interval
+func newSampler(interval time.Duration) *sampler {
+	return &sampler{
+		interval:  interval,
+		buckets:   make(map[sampleKey]domain.Metrics),
+		latencies: make(map[sampleKey][]float64),
+	}
+}
+
+// add adds a sample to its interval
+func (sm *sampler) add(m domain.Metrics) {
+	key := sampleKey{m.KKTID, m.Collector, m.Timestamp.Truncate(sm.interval)}
+	sm.latencies[key] = append(sm.latencies[key], m.OFDAckLatencies...)
+	if last, ok := sm.buckets[key]; !ok || !m.Timestamp.Before(last.Timestamp) {
+		sm.buckets[key] = m
+	}
+}
+
+// samples returns the sample of every interval ordered by time
+func (sm *sampler) samples() []domain.Metrics {
+	samples := make([]domain.Metrics, 0, len(sm.buckets))
+	for key, m := range sm.buckets {
+		m.OFDAckLatencies = sm.latencies[key]
+		samples = append(samples, m)
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i].Timestamp.Before(samples[j].Timestamp) })
+	return samples
+}
+
+// downsample replaces a metrics segment with one sample per device,
+// collector and DownsampleInterval. The segment is read and the samples
+// written without the lock; samples appended meanwhile are copied as they
+// are while the segment is replaced under the write lock.
+func (s *Store) downsample(seg segment) error {
+	sm := newSampler(s.cfg.DownsampleInterval)
+	offset, err := readRecords(seg.path, 0, func(data []byte) error {
+		var m domain.Metrics
+		if err := json.Unmarshal(data, &m); err != nil {
+			return err
+		}
+		sm.add(m)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	day := seg.day.Format(dayLayout)
+	path := s.path(kindMetrics, day, true)
+	tmp := path + ".tmp"
+	if err := writeSamples(tmp, sm.samples()); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := copyRecords(seg.path, offset, tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to replace history segment: %w", err)
+	}
+	if err := os.Remove(seg.path); err != nil {
+		return fmt.Errorf("failed to delete history segment: %w", err)
+	}
+	s.downsampled[day] = true
+	return nil
+}
+
+// writeSamples writes samples as a new segment file
+func writeSamples(path string, samples []domain.Metrics) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create history segment: %w", err)
+	}
+
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, m := range samples {
+		if err := enc.Encode(m); err != nil {
+			f.Close()
+			return fmt.Errorf("failed to write history segment: %w", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write history segment: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write history segment: %w", err)
+	}
+	return nil
+}
+
+// copyRecords appends the records of a segment from offset to the file at
+// path
+func copyRecords(src string, offset int64, path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open history segment: %w", err)
+	}
+
+	w := bufio.NewWriter(f)
+	_, err = readRecords(src, offset, func(data []byte) error {
+		w.Write(data)
+		return w.WriteByte('\n')
+	})
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write history segment: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write history segment: %w", err)
+	}
+	return nil
+}
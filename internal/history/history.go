@@ -0,0 +1,346 @@
+package history
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/config"
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/domain"
+)
+
+// Segment kinds
+const (
+	kindMetrics = "metrics"
+	kindErrors  = "errors"
+)
+
+// File name parts of segments. A segment holds the records of one UTC day
+// as JSON lines, e.g. metrics-2024-05-01.jsonl; downsampled metrics
+// segments are named metrics-2024-05-01.downsampled.jsonl.
+const (
+	dayLayout         = "2006-01-02"
+	segmentExt        = ".jsonl"
+	downsampledSuffix = ".downsampled"
+)
+
+// maxLineSize is the longest record read from a segment
+const maxLineSize = 4 << 20
+
+// Store is an embedded append-only store of metrics samples and KKT errors
+// kept in daily segment files. It is safe for concurrent use.
+type Store struct {
+	dir string
+	cfg config.HistoryConfig
+
+	// compactMu serializes compactions, which downsample without mu
+	compactMu sync.Mutex
+
+	mu sync.RWMutex
+	// downsampled are the days of downsampled metrics segments
+	downsampled map[string]bool
+	now         func() time.Time
+}
+
+// Open opens the store in the configured directory, creating it if needed
+func Open(cfg config.HistoryConfig) (*Store, error) {
+	if err := os.MkdirAll(cfg.Dir, 0750); err != nil {
+		return nil, fmt.Errorf("failed to create history directory: %w", err)
+	}
+
+	s := &Store{
+		dir:         cfg.Dir,
+		cfg:         cfg,
+		downsampled: make(map[string]bool),
+		now:         time.Now,
+	}
+
+	segments, err := s.segments(kindMetrics)
+	if err != nil {
+		return nil, err
+	}
+	for _, seg := range segments {
+		if seg.downsampled {
+			s.downsampled[seg.day.Format(dayLayout)] = true
+		}
+	}
+
+	return s, nil
+}
+
+// AppendMetrics records a metrics sample
+func (s *Store) AppendMetrics(m domain.Metrics) error {
+	if m.Timestamp.IsZero() {
+		m.Timestamp = s.now()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	day := m.Timestamp.UTC().Format(dayLayout)
+	return s.append(s.path(kindMetrics, day, s.downsampled[day]), m)
+}
+
+// AppendError records a KKT error
+func (s *Store) AppendError(kktErr domain.KKTError) error {
+	if kktErr.Timestamp.IsZero() {
+		kktErr.Timestamp = s.now()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.append(s.path(kindErrors, kktErr.Timestamp.UTC().Format(dayLayout), false), kktErr)
+}
+
+// Metrics returns at most limit of the latest samples of a device in
+// [from, to) ordered by time. An empty kktID selects all devices.
+func (s *Store) Metrics(kktID string, from, to time.Time, limit int) ([]domain.Metrics, error) {
+	out := newLatest(limit, func(m domain.Metrics) time.Time { return m.Timestamp })
+	err := s.read(kindMetrics, from, to, func(data []byte) error {
+		var m domain.Metrics
+		if err := json.Unmarshal(data, &m); err != nil {
+			return err
+		}
+		if (kktID == "" || m.KKTID == kktID) && inRange(m.Timestamp, from, to) {
+			out.add(m)
+		}
+		return nil
+	})
+	return out.records(), err
+}
+
+// DownsampledMetrics returns the samples of all devices in [from, to)
+// reduced to one per device, collector and DownsampleInterval as compaction
+// does, ordered by time. Samples are reduced while reading, so memory is
+// bounded by the intervals of the range; at most limit of the latest
+// samples are returned.
+func (s *Store) DownsampledMetrics(from, to time.Time, limit int) ([]domain.Metrics, error) {
+	sm := newSampler(s.cfg.DownsampleInterval)
+	err := s.read(kindMetrics, from, to, func(data []byte) error {
+		var m domain.Metrics
+		if err := json.Unmarshal(data, &m); err != nil {
+			return err
+		}
+		if inRange(m.Timestamp, from, to) {
+			sm.add(m)
+		}
+		return nil
+	})
+	out := sm.samples()
+	if len(out) > limit {
+		out = out[len(out)-limit:]
+	}
+	return out, err
+}
+
+// Errors returns at most limit of the latest errors in [from, to) that
+// match, ordered by time. A nil match selects all errors.
+func (s *Store) Errors(from, to time.Time, match func(domain.KKTError) bool, limit int) ([]domain.KKTError, error) {
+	out := newLatest(limit, func(kktErr domain.KKTError) time.Time { return kktErr.Timestamp })
+	err := s.read(kindErrors, from, to, func(data []byte) error {
+		var kktErr domain.KKTError
+		if err := json.Unmarshal(data, &kktErr); err != nil {
+			return err
+		}
+		if inRange(kktErr.Timestamp, from, to) && (match == nil || match(kktErr)) {
+			out.add(kktErr)
+		}
+		return nil
+	})
+	return out.records(), err
+}
+
+// latest collects the latest records up to a limit. It holds at most twice
+// the limit while reading, so memory does not grow with the time range.
+type latest[T any] struct {
+	limit int
+	at    func(T) time.Time
+	recs  []T
+}
+
+// newLatest creates a collector of the latest limit records by time
+func newLatest[T any](limit int, at func(T) time.Time) *latest[T] {
+	return &latest[T]{limit: limit, at: at}
+}
+
+// add adds a record, dropping the oldest ones when twice the limit is held
+func (l *latest[T]) add(v T) {
+	l.recs = append(l.recs, v)
+	if len(l.recs) >= 2*l.limit {
+		l.trim()
+	}
+}
+
+// trim orders the records by time and drops all but the latest limit
+func (l *latest[T]) trim() {
+	sort.SliceStable(l.recs, func(i, j int) bool { return l.at(l.recs[i]).Before(l.at(l.recs[j])) })
+	if n := len(l.recs) - l.limit; n > 0 {
+		l.recs = append(l.recs[:0], l.recs[n:]...)
+	}
+}
+
+// records returns the latest records ordered by time
+func (l *latest[T]) records() []T {
+	l.trim()
+	return l.recs
+}
+
+// append writes a record to the end of a segment. The caller must hold
+// the write lock.
+func (s *Store) append(path string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode history record: %w", err)
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open history segment: %w", err)
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write history segment: %w", err)
+	}
+	return f.Close()
+}
+
+// read calls fn with every record of the segments of kind overlapping
+// [from, to). Records that fail to decode are skipped.
+func (s *Store) read(kind string, from, to time.Time, fn func(data []byte) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	segments, err := s.segments(kind)
+	if err != nil {
+		return err
+	}
+
+	for _, seg := range segments {
+		if !seg.overlaps(from, to) {
+			continue
+		}
+		if err := readSegment(seg.path, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readSegment calls fn with every record of a segment
+func readSegment(path string, fn func(data []byte) error) error {
+	_, err := readRecords(path, 0, fn)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// readRecords calls fn with every newline-terminated record of a segment
+// from offset and returns the offset after the last one. A record still
+// being written is left for a later read.
+func readRecords(path string, offset int64, fn func(data []byte) error) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return offset, fmt.Errorf("failed to open history segment: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return offset, fmt.Errorf("failed to read history segment %s: %w", filepath.Base(path), err)
+	}
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+	scanner.Split(scanTerminatedLines)
+	for scanner.Scan() {
+		offset += int64(len(scanner.Bytes())) + 1
+		// A record that fails to decode is skipped
+		_ = fn(scanner.Bytes())
+	}
+	if err := scanner.Err(); err != nil {
+		return offset, fmt.Errorf("failed to read history segment %s: %w", filepath.Base(path), err)
+	}
+	return offset, nil
+}
+
+// scanTerminatedLines is a bufio.SplitFunc of the lines terminated by a
+// newline, without it. An unterminated last line is not returned.
+func scanTerminatedLines(data []byte, atEOF bool) (int, []byte, error) {
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	return 0, nil, nil
+}
+
+// segment is a daily segment file
+type segment struct {
+	path        string
+	day         time.Time
+	downsampled bool
+}
+
+// overlaps reports whether the day of the segment overlaps [from, to).
+// Zero bounds are open.
+func (seg segment) overlaps(from, to time.Time) bool {
+	end := seg.day.Add(24 * time.Hour)
+	return (from.IsZero() || end.After(from)) && (to.IsZero() || seg.day.Before(to))
+}
+
+// segments lists the segments of kind ordered by day
+func (s *Store) segments(kind string) ([]segment, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list history segments: %w", err)
+	}
+
+	var segments []segment
+	for _, entry := range entries {
+		name := entry.Name()
+		rest, ok := strings.CutPrefix(name, kind+"-")
+		if !ok || !entry.Type().IsRegular() {
+			continue
+		}
+		rest, ok = strings.CutSuffix(rest, segmentExt)
+		if !ok {
+			continue
+		}
+		rest, downsampled := strings.CutSuffix(rest, downsampledSuffix)
+		day, err := time.Parse(dayLayout, rest)
+		if err != nil {
+			continue
+		}
+		segments = append(segments, segment{
+			path:        filepath.Join(s.dir, name),
+			day:         day,
+			downsampled: downsampled,
+		})
+	}
+
+	sort.Slice(segments, func(i, j int) bool { return segments[i].day.Before(segments[j].day) })
+	return segments, nil
+}
+
+// path returns the path of the segment of kind for a day
+func (s *Store) path(kind, day string, downsampled bool) string {
+	name := kind + "-" + day
+	if downsampled {
+		name += downsampledSuffix
+	}
+	return filepath.Join(s.dir, name+segmentExt)
+}
+
+// inRange reports whether t is in [from, to). Zero bounds are open.
+func inRange(t, from, to time.Time) bool {
+	return (from.IsZero() || !t.Before(from)) && (to.IsZero() || t.Before(to))
+}
@@ -0,0 +1,258 @@
+package history
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/config"
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/domain"
+)
+
+func newTestStore(t *testing.T, now time.Time) *Store {
+	t.Helper()
+	s, err := Open(config.HistoryConfig{
+		Dir:                t.TempDir(),
+		Retention:          7 * 24 * time.Hour,
+		DownsampleAfter:    24 * time.Hour,
+		DownsampleInterval: time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("Failed to open history: %v", err)
+	}
+	s.now = func() time.Time { return now }
+	return s
+}
+
+func TestStore_AppendAndRead(t *testing.T) {
+	now := time.Date(2024, 5, 2, 12, 0, 0, 0, time.UTC)
+	s := newTestStore(t, now)
+
+	for _, m := range []domain.Metrics{
+		{KKTID: "kkt-001", Collector: "store-1", Timestamp: now.Add(-26 * time.Hour), DocumentsTotal: 1},
+		{KKTID: "kkt-002", Collector: "store-1", Timestamp: now.Add(-2 * time.Hour), DocumentsTotal: 2},
+		{KKTID: "kkt-001", Collector: "store-1", Timestamp: now.Add(-time.Hour), DocumentsTotal: 3},
+	} {
+		if err := s.AppendMetrics(m); err != nil {
+			t.Fatalf("Failed to append metrics: %v", err)
+		}
+	}
+	if err := s.AppendError(domain.KKTError{KKTID: "kkt-001", ErrorType: domain.ErrorTypeOFD, Timestamp: now.Add(-time.Hour)}); err != nil {
+		t.Fatalf("Failed to append error: %v", err)
+	}
+
+	metrics, err := s.Metrics("kkt-001", now.Add(-48*time.Hour), now, 10)
+	if err != nil {
+		t.Fatalf("Failed to read metrics: %v", err)
+	}
+	if len(metrics) != 2 || metrics[0].DocumentsTotal != 1 || metrics[1].DocumentsTotal != 3 {
+		t.Errorf("Expected 2 samples of kkt-001 ordered by time, got %+v", metrics)
+	}
+
+	metrics, err = s.Metrics("", now.Add(-3*time.Hour), now, 10)
+	if err != nil {
+		t.Fatalf("Failed to read metrics: %v", err)
+	}
+	if len(metrics) != 2 {
+		t.Errorf("Expected 2 samples in the last 3 hours, got %d", len(metrics))
+	}
+
+	metrics, err = s.Metrics("", time.Time{}, time.Time{}, 1)
+	if err != nil {
+		t.Fatalf("Failed to read metrics: %v", err)
+	}
+	if len(metrics) != 1 || metrics[0].DocumentsTotal != 3 {
+		t.Errorf("Expected only the latest sample, got %+v", metrics)
+	}
+
+	errs, err := s.Errors(time.Time{}, time.Time{}, nil, 10)
+	if err != nil {
+		t.Fatalf("Failed to read errors: %v", err)
+	}
+	if len(errs) != 1 || errs[0].ErrorType != domain.ErrorTypeOFD {
+		t.Errorf("Expected 1 OFD error, got %+v", errs)
+	}
+	isNetwork := func(kktErr domain.KKTError) bool { return kktErr.ErrorType == domain.ErrorTypeNetwork }
+	if errs, err := s.Errors(time.Time{}, time.Time{}, isNetwork, 10); err != nil || len(errs) != 0 {
+		t.Errorf("Expected no network errors, got %d (%v)", len(errs), err)
+	}
+
+	// A truncated record is skipped
+	f, err := os.OpenFile(s.path(kindErrors, now.Format(dayLayout), false), os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatalf("Failed to open segment: %v", err)
+	}
+	f.WriteString(`{"kkt_id":"kkt-0`)
+	f.Close()
+	if errs, err := s.Errors(time.Time{}, time.Time{}, nil, 10); err != nil || len(errs) != 1 {
+		t.Errorf("Expected 1 error after a truncated record, got %d (%v)", len(errs), err)
+	}
+}
+
+func TestStore_DownsampledMetrics(t *testing.T) {
+	now := time.Date(2024, 5, 2, 12, 0, 0, 0, time.UTC)
+	s := newTestStore(t, now)
+
+	for _, m := range []domain.Metrics{
+		{KKTID: "kkt-001", Collector: "store-1", Timestamp: now.Add(-150 * time.Minute), DocumentsTotal: 1, OFDAckLatencies: []float64{1}},
+		{KKTID: "kkt-001", Collector: "store-1", Timestamp: now.Add(-140 * time.Minute), DocumentsTotal: 2, OFDAckLatencies: []float64{2}},
+		{KKTID: "kkt-002", Collector: "store-1", Timestamp: now.Add(-140 * time.Minute), DocumentsTotal: 5},
+		{KKTID: "kkt-001", Collector: "store-1", Timestamp: now.Add(-30 * time.Minute), DocumentsTotal: 3},
+	} {
+		if err := s.AppendMetrics(m); err != nil {
+			t.Fatalf("Failed to append metrics: %v", err)
+		}
+	}
+
+	metrics, err := s.DownsampledMetrics(now.Add(-3*time.Hour), now, 10)
+	if err != nil {
+		t.Fatalf("Failed to read metrics: %v", err)
+	}
+	if len(metrics) != 3 {
+		t.Fatalf("Expected 3 hourly samples, got %+v", metrics)
+	}
+	if metrics[0].DocumentsTotal != 2 || len(metrics[0].OFDAckLatencies) != 2 {
+		t.Errorf("Expected the last sample of the hour with all latencies, got %+v", metrics[0])
+	}
+
+	metrics, err = s.DownsampledMetrics(now.Add(-3*time.Hour), now, 1)
+	if err != nil {
+		t.Fatalf("Failed to read metrics: %v", err)
+	}
+	if len(metrics) != 1 || metrics[0].DocumentsTotal != 3 {
+		t.Errorf("Expected only the latest sample, got %+v", metrics)
+	}
+}
+
+func TestStore_Compact(t *testing.T) {
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	s := newTestStore(t, now)
+
+	old := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)    // past retention
+	day := time.Date(2024, 5, 8, 10, 0, 0, 0, time.UTC)    // to downsample
+	today := time.Date(2024, 5, 10, 10, 0, 0, 0, time.UTC) // kept as is
+
+	samples := []domain.Metrics{
+		{KKTID: "kkt-001", Collector: "store-1", Timestamp: old},
+		{KKTID: "kkt-001", Collector: "store-1", Timestamp: day, DocumentsTotal: 1, OFDAckLatencies: []float64{1}},
+		{KKTID: "kkt-001", Collector: "store-1", Timestamp: day.Add(20 * time.Minute), DocumentsTotal: 2, OFDAckLatencies: []float64{2}},
+		{KKTID: "kkt-001", Collector: "store-1", Timestamp: day.Add(40 * time.Minute), DocumentsTotal: 3},
+		{KKTID: "kkt-001", Collector: "store-1", Timestamp: day.Add(time.Hour), DocumentsTotal: 4},
+		{KKTID: "kkt-001", Collector: "store-1", Timestamp: today, DocumentsTotal: 5},
+		{KKTID: "kkt-001", Collector: "store-1", Timestamp: today.Add(time.Minute), DocumentsTotal: 6},
+	}
+	for _, m := range samples {
+		if err := s.AppendMetrics(m); err != nil {
+			t.Fatalf("Failed to append metrics: %v", err)
+		}
+	}
+	if err := s.AppendError(domain.KKTError{KKTID: "kkt-001", Timestamp: old}); err != nil {
+		t.Fatalf("Failed to append error: %v", err)
+	}
+
+	if err := s.Compact(); err != nil {
+		t.Fatalf("Failed to compact: %v", err)
+	}
+
+	metrics, err := s.Metrics("", time.Time{}, time.Time{}, 10)
+	if err != nil {
+		t.Fatalf("Failed to read metrics: %v", err)
+	}
+	var totals []int64
+	for _, m := range metrics {
+		totals = append(totals, m.DocumentsTotal)
+	}
+	if len(totals) != 4 || totals[0] != 3 || totals[1] != 4 || totals[2] != 5 || totals[3] != 6 {
+		t.Fatalf("Expected samples 3, 4, 5, 6, got %v", totals)
+	}
+	if len(metrics[0].OFDAckLatencies) != 2 {
+		t.Errorf("Expected latencies of the hour to be kept, got %v", metrics[0].OFDAckLatencies)
+	}
+	if errs, _ := s.Errors(time.Time{}, time.Time{}, nil, 10); len(errs) != 0 {
+		t.Errorf("Expected errors past retention to be deleted, got %d", len(errs))
+	}
+
+	// Reopening keeps appending late samples to the downsampled segment
+	reopened, err := Open(s.cfg)
+	if err != nil {
+		t.Fatalf("Failed to reopen history: %v", err)
+	}
+	if !reopened.downsampled["2024-05-08"] {
+		t.Error("Expected 2024-05-08 to be downsampled")
+	}
+	if err := reopened.AppendMetrics(domain.Metrics{KKTID: "kkt-002", Timestamp: day}); err != nil {
+		t.Fatalf("Failed to append metrics: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(s.dir, "metrics-2024-05-08.jsonl")); !os.IsNotExist(err) {
+		t.Errorf("Expected no raw segment of a downsampled day, got %v", err)
+	}
+}
+
+func TestStore_LatestRecords(t *testing.T) {
+	now := time.Date(2024, 5, 2, 12, 0, 0, 0, time.UTC)
+	s := newTestStore(t, now)
+
+	// Samples of two days, the first one appended out of order
+	for _, i := range []int{30, 10, 25, 20, 15, 5, 0} {
+		m := domain.Metrics{KKTID: "kkt-001", Timestamp: now.Add(-time.Duration(i) * time.Hour), DocumentsTotal: int64(i)}
+		if err := s.AppendMetrics(m); err != nil {
+			t.Fatalf("Failed to append metrics: %v", err)
+		}
+	}
+
+	metrics, err := s.Metrics("kkt-001", time.Time{}, time.Time{}, 3)
+	if err != nil {
+		t.Fatalf("Failed to read metrics: %v", err)
+	}
+	var totals []int64
+	for _, m := range metrics {
+		totals = append(totals, m.DocumentsTotal)
+	}
+	if len(totals) != 3 || totals[0] != 10 || totals[1] != 5 || totals[2] != 0 {
+		t.Errorf("Expected the latest samples 10, 5, 0, got %v", totals)
+	}
+}
+
+func TestReadRecords(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "metrics-2024-05-08.jsonl")
+	dst := filepath.Join(dir, "metrics-2024-05-08.downsampled.jsonl.tmp")
+
+	if err := os.WriteFile(src, []byte("{\"a\":1}\n{\"a\":2}\n{\"a\""), 0600); err != nil {
+		t.Fatalf("Failed to write segment: %v", err)
+	}
+	var n int
+	offset, err := readRecords(src, 0, func(data []byte) error {
+		n++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to read records: %v", err)
+	}
+	if n != 2 || offset != 16 {
+		t.Errorf("Expected 2 records up to offset 16, got %d up to %d", n, offset)
+	}
+
+	// The record being written is completed and another is appended
+	f, err := os.OpenFile(src, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatalf("Failed to open segment: %v", err)
+	}
+	f.WriteString(":3}\n{\"a\":4}\n")
+	f.Close()
+
+	if err := os.WriteFile(dst, []byte("{\"a\":0}\n"), 0600); err != nil {
+		t.Fatalf("Failed to write segment: %v", err)
+	}
+	if err := copyRecords(src, offset, dst); err != nil {
+		t.Fatalf("Failed to copy records: %v", err)
+	}
+	data, err := os.ReadFile(dst)
+	if err != nil {
+		t.Fatalf("Failed to read segment: %v", err)
+	}
+	if want := "{\"a\":0}\n{\"a\":3}\n{\"a\":4}\n"; string(data) != want {
+		t.Errorf("Expected %q, got %q", want, data)
+	}
+}
@@ -0,0 +1,371 @@
+package state
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/domain"
+)
+
+// AutoResolvedBy is the ResolvedBy of errors resolved because the device
+// recovered
+const AutoResolvedBy = "auto"
+
+// errorKey correlates recurrences of an error on a KKT: errors with the
+// same code, or without a code the same type and message, are one error
+type errorKey struct {
+	kktID string
+	code  string
+}
+
+// keyOf returns the correlation key of an error
+func keyOf(kktErr domain.KKTError) errorKey {
+	if kktErr.ErrorCode != "" {
+		return errorKey{kktID: kktErr.KKTID, code: kktErr.ErrorCode}
+	}
+	return errorKey{kktID: kktErr.KKTID, code: kktErr.ErrorType.String() + ":" + kktErr.Message}
+}
+
+// track opens a tracked error for an error, or counts a recurrence of an
+// unresolved one. Informational errors are not tracked. The caller must
+// hold the write lock.
+func (s *Store) track(kktErr domain.KKTError) {
+	if kktErr.Severity == domain.ErrorSeverityInfo {
+		return
+	}
+	if kktErr.Timestamp.IsZero() {
+		kktErr.Timestamp = s.now()
+	}
+
+	key := keyOf(kktErr)
+	if id, ok := s.unresolved[key.kktID][key.code]; ok {
+		te := s.tracked[id]
+		te.Occurrences++
+		if kktErr.Timestamp.After(te.LastSeen) {
+			te.LastSeen = kktErr.Timestamp
+		}
+		if kktErr.Severity > te.Severity {
+			te.Severity = kktErr.Severity
+		}
+		return
+	}
+
+	s.nextID++
+	te := &domain.TrackedError{
+		KKTError:    kktErr,
+		State:       domain.ErrorStateOpen,
+		Occurrences: 1,
+		LastSeen:    kktErr.Timestamp,
+	}
+	te.ID = fmt.Sprintf("err-%d", s.nextID)
+	te.Resolved = false
+	te.ResolvedAt = nil
+	s.tracked[te.ID] = te
+	s.setUnresolved(key, te.ID)
+}
+
+// setUnresolved indexes an unresolved tracked error by its key. The caller
+// must hold the write lock.
+func (s *Store) setUnresolved(key errorKey, id string) {
+	ids, ok := s.unresolved[key.kktID]
+	if !ok {
+		ids = make(map[string]string)
+		s.unresolved[key.kktID] = ids
+	}
+	ids[key.code] = id
+}
+
+// deleteUnresolved removes a tracked error from the unresolved index. The
+// caller must hold the write lock.
+func (s *Store) deleteUnresolved(key errorKey) {
+	ids := s.unresolved[key.kktID]
+	delete(ids, key.code)
+	if len(ids) == 0 {
+		delete(s.unresolved, key.kktID)
+	}
+}
+
+// countHealth counts a report of a device. A running device synchronized
+// with the OFD is healthy; after autoResolveCycles healthy collection
+// cycles in a row its unresolved errors are resolved. Only reports of
+// device events after the last counted one or the last error are evidence,
+// and the reports of several collectors count once per cycle. The caller
+// must hold the write lock.
+func (s *Store) countHealth(d *device, m domain.Metrics) {
+	if s.autoResolveCycles <= 0 {
+		return
+	}
+	if m.Status != domain.KKTStatusRunning || m.OFDSyncStatus == domain.OFDSyncStatusError {
+		d.resetHealth()
+		return
+	}
+
+	at := m.LastEventTime
+	if at.IsZero() {
+		at = m.Timestamp
+	}
+	if !at.After(d.lastEvent) {
+		return
+	}
+	d.lastEvent = at
+
+	// A collector reporting again starts the next cycle
+	if d.cycleCollectors == nil {
+		d.cycleCollectors = make(map[string]bool)
+	}
+	if len(d.cycleCollectors) > 0 && !d.cycleCollectors[m.Collector] {
+		d.cycleCollectors[m.Collector] = true
+		return
+	}
+	clear(d.cycleCollectors)
+	d.cycleCollectors[m.Collector] = true
+
+	d.healthyReports++
+	if d.healthyReports < s.autoResolveCycles {
+		return
+	}
+	for _, id := range s.unresolved[d.info.ID] {
+		s.resolve(s.tracked[id], AutoResolvedBy, "")
+	}
+}
+
+// resetHealth restarts counting the healthy cycles of a device
+func (d *device) resetHealth() {
+	d.healthyReports = 0
+	clear(d.cycleCollectors)
+}
+
+// Acknowledge marks an unresolved error as being handled by an operator
+func (s *Store) Acknowledge(id, operator, note string) (domain.TrackedError, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	te, err := s.unresolvedError(id)
+	if err != nil {
+		return domain.TrackedError{}, err
+	}
+
+	now := s.now()
+	te.State = domain.ErrorStateAcknowledged
+	te.AcknowledgedAt = &now
+	te.AcknowledgedBy = operator
+	if note != "" {
+		te.Note = note
+	}
+	return *te, nil
+}
+
+// Resolve resolves an unresolved error on behalf of an operator
+func (s *Store) Resolve(id, operator, note string) (domain.TrackedError, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	te, err := s.unresolvedError(id)
+	if err != nil {
+		return domain.TrackedError{}, err
+	}
+
+	s.resolve(te, operator, note)
+	return *te, nil
+}
+
+// TrackedErrors returns the tracked errors ordered by first occurrence
+func (s *Store) TrackedErrors() []domain.TrackedError {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.trackedErrors()
+}
+
+// TrackedError returns a tracked error by ID
+func (s *Store) TrackedError(id string) (domain.TrackedError, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	te, ok := s.tracked[id]
+	if !ok {
+		return domain.TrackedError{}, false
+	}
+	return *te, true
+}
+
+// unresolvedError returns an unresolved tracked error by ID. The caller
+// must hold the lock.
+func (s *Store) unresolvedError(id string) (*domain.TrackedError, error) {
+	te, ok := s.tracked[id]
+	if !ok {
+		return nil, domain.ErrTrackedErrorNotFound
+	}
+	if te.State == domain.ErrorStateResolved {
+		return nil, domain.ErrTrackedErrorResolved
+	}
+	return te, nil
+}
+
+// resolve resolves a tracked error and the recent errors it correlates.
+// The caller must hold the write lock.
+func (s *Store) resolve(te *domain.TrackedError, by, note string) {
+	now := s.now()
+	te.State = domain.ErrorStateResolved
+	te.Resolved = true
+	te.ResolvedAt = &now
+	te.ResolvedBy = by
+	if note != "" {
+		te.Note = note
+	}
+
+	key := keyOf(te.KKTError)
+	s.deleteUnresolved(key)
+	for i := range s.errors {
+		e := &s.errors[i]
+		if !e.Resolved && !e.Timestamp.After(now) && keyOf(*e) == key {
+			e.Resolved = true
+			e.ResolvedAt = &now
+		}
+	}
+
+	resolvedBy := "operator"
+	if by == AutoResolvedBy {
+		resolvedBy = AutoResolvedBy
+	}
+	s.timeToResolve.WithLabelValues(domain.EnumLabel(te.ErrorType), resolvedBy).
+		Observe(max(now.Sub(te.Timestamp).Seconds(), 0))
+
+	if evicted, ok := s.resolved.Push(te.ID, s.errorsLimit); ok {
+		delete(s.tracked, evicted)
+	}
+}
+
+// resolvedRing holds the IDs of the latest resolved errors in the order
+// they were resolved. Once it holds limit IDs, adding one evicts the
+// earliest.
+type resolvedRing struct {
+	ids  []string
+	next int // earliest ID of a full ring
+}
+
+// Push adds an ID to a ring of limit IDs and returns the evicted one, if any
+func (r *resolvedRing) Push(id string, limit int) (string, bool) {
+	if limit <= 0 {
+		return id, true
+	}
+	if len(r.ids) < limit {
+		r.ids = append(r.ids, id)
+		return "", false
+	}
+	evicted := r.ids[r.next]
+	r.ids[r.next] = id
+	r.next = (r.next + 1) % len(r.ids)
+	return evicted, true
+}
+
+// newTimeToResolve creates the time-to-resolve histogram
+func newTimeToResolve() *prometheus.HistogramVec {
+	return prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "kkt_error_time_to_resolve_seconds",
+			Help:    "Time from the first occurrence of a KKT error to its resolution (resolved_by=auto or operator)",
+			Buckets: []float64{60, 300, 900, 3600, 4 * 3600, 12 * 3600, 86400, 3 * 86400, 7 * 86400},
+		},
+		[]string{"error_type", "resolved_by"},
+	)
+}
+
+// openErrorsDesc describes the unresolved errors gauge
+var openErrorsDesc = prometheus.NewDesc(
+	"kkt_open_errors",
+	"Number of unresolved KKT errors by state (open or acknowledged)",
+	[]string{"kkt_id", "error_type", "state"}, nil,
+)
+
+// openErrorsCollector exports the unresolved errors of a store
+type openErrorsCollector struct {
+	store *Store
+}
+
+// Describe implements prometheus.Collector
+func (c openErrorsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- openErrorsDesc
+}
+
+// Collect implements prometheus.Collector
+func (c openErrorsCollector) Collect(ch chan<- prometheus.Metric) {
+	type series struct {
+		kktID     string
+		errorType domain.ErrorType
+		state     domain.ErrorState
+	}
+	counts := make(map[series]int)
+
+	c.store.mu.RLock()
+	for _, ids := range c.store.unresolved {
+		for _, id := range ids {
+			te := c.store.tracked[id]
+			counts[series{te.KKTID, te.ErrorType, te.State}]++
+		}
+	}
+	c.store.mu.RUnlock()
+
+	for sr, n := range counts {
+		ch <- prometheus.MustNewConstMetric(openErrorsDesc, prometheus.GaugeValue, float64(n),
+			sr.kktID, domain.EnumLabel(sr.errorType), domain.EnumLabel(sr.state))
+	}
+}
+
+// Collectors returns the error lifecycle metrics for registration
+func (s *Store) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{openErrorsCollector{store: s}, s.timeToResolve}
+}
+
+// restoreTracked restores tracked errors from a snapshot, keeping the
+// latest errorsLimit resolved ones. The caller must hold the write lock.
+func (s *Store) restoreTracked(tracked []domain.TrackedError, nextID int) {
+	s.tracked = make(map[string]*domain.TrackedError, len(tracked))
+	s.unresolved = make(map[string]map[string]string)
+	s.resolved = resolvedRing{}
+	s.nextID = nextID
+
+	var resolved []*domain.TrackedError
+	for i := range tracked {
+		te := &tracked[i]
+		s.tracked[te.ID] = te
+		if te.State != domain.ErrorStateResolved {
+			s.setUnresolved(keyOf(te.KKTError), te.ID)
+			continue
+		}
+		resolved = append(resolved, te)
+	}
+
+	sort.Slice(resolved, func(i, j int) bool { return resolvedBefore(resolved[i], resolved[j]) })
+	for _, te := range resolved {
+		if evicted, ok := s.resolved.Push(te.ID, s.errorsLimit); ok {
+			delete(s.tracked, evicted)
+		}
+	}
+}
+
+// resolvedBefore reports whether a was resolved before b
+func resolvedBefore(a, b *domain.TrackedError) bool {
+	if a.ResolvedAt == nil || b.ResolvedAt == nil {
+		return b.ResolvedAt != nil
+	}
+	return a.ResolvedAt.Before(*b.ResolvedAt)
+}
+
+// trackedErrors returns a copy of the tracked errors ordered by first
+// occurrence. The caller must hold the lock.
+func (s *Store) trackedErrors() []domain.TrackedError {
+	out := make([]domain.TrackedError, 0, len(s.tracked))
+	for _, te := range s.tracked {
+		out = append(out, *te)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].Timestamp.Equal(out[j].Timestamp) {
+			return out[i].Timestamp.Before(out[j].Timestamp)
+		}
+		return out[i].ID < out[j].ID
+	})
+	return out
+}
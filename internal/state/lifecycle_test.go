@@ -0,0 +1,214 @@
+package state
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/config"
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/domain"
+)
+
+func newLifecycleStore(now *time.Time) *Store {
+	s := New(config.StateConfig{ErrorsLimit: 10, AutoResolveCycles: 2})
+	s.now = func() time.Time { return *now }
+	return s
+}
+
+func TestStore_TrackErrors(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	s := newLifecycleStore(&now)
+
+	s.ApplyError(domain.KKTError{KKTID: "kkt-001", ErrorCode: "E-235", ErrorType: domain.ErrorTypeFiscalDrive,
+		Severity: domain.ErrorSeverityWarning, Timestamp: now})
+	s.ApplyError(domain.KKTError{KKTID: "kkt-001", ErrorCode: "E-235", ErrorType: domain.ErrorTypeFiscalDrive,
+		Severity: domain.ErrorSeverityCritical, Timestamp: now.Add(time.Minute)})
+	// Another KKT, and an error without a code
+	s.ApplyError(domain.KKTError{KKTID: "kkt-002", ErrorCode: "E-235", ErrorType: domain.ErrorTypeFiscalDrive, Timestamp: now})
+	s.ApplyError(domain.KKTError{KKTID: "kkt-001", ErrorType: domain.ErrorTypeOFD, Message: "timeout", Timestamp: now})
+	s.ApplyError(domain.KKTError{KKTID: "kkt-001", ErrorType: domain.ErrorTypeOFD, Message: "timeout", Timestamp: now})
+	// Informational errors are not tracked
+	s.ApplyError(domain.KKTError{KKTID: "kkt-001", Severity: domain.ErrorSeverityInfo, Timestamp: now})
+
+	tracked := s.TrackedErrors()
+	if len(tracked) != 3 {
+		t.Fatalf("Expected 3 tracked errors, got %+v", tracked)
+	}
+	te, ok := s.TrackedError("err-1")
+	if !ok {
+		t.Fatal("Expected tracked error err-1")
+	}
+	if te.State != domain.ErrorStateOpen || te.Occurrences != 2 || te.Severity != domain.ErrorSeverityCritical {
+		t.Errorf("Expected open critical error seen twice, got %+v", te)
+	}
+	if !te.Timestamp.Equal(now) || !te.LastSeen.Equal(now.Add(time.Minute)) {
+		t.Errorf("Expected first and last occurrence, got %v and %v", te.Timestamp, te.LastSeen)
+	}
+	if te, _ := s.TrackedError("err-3"); te.Occurrences != 2 {
+		t.Errorf("Expected error without a code correlated by message, got %+v", te)
+	}
+}
+
+func TestStore_AutoResolve(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	s := newLifecycleStore(&now)
+
+	healthy := func(ts time.Time) domain.Metrics {
+		return domain.Metrics{KKTID: "kkt-001", Collector: "store-1", Timestamp: ts,
+			Status: domain.KKTStatusRunning, OFDSyncStatus: domain.OFDSyncStatusSynced}
+	}
+
+	s.ApplyError(domain.KKTError{KKTID: "kkt-001", ErrorCode: "E-1", ErrorType: domain.ErrorTypeOFD, Timestamp: now})
+	s.ApplyError(domain.KKTError{KKTID: "kkt-002", ErrorCode: "E-1", ErrorType: domain.ErrorTypeOFD, Timestamp: now})
+	s.ApplyMetrics(healthy(now.Add(time.Minute)))
+	// An unhealthy report restarts the count
+	s.ApplyMetrics(domain.Metrics{KKTID: "kkt-001", Collector: "store-1", Timestamp: now.Add(2 * time.Minute),
+		Status: domain.KKTStatusRunning, OFDSyncStatus: domain.OFDSyncStatusError})
+	s.ApplyMetrics(healthy(now.Add(3 * time.Minute)))
+	if te, _ := s.TrackedError("err-1"); te.State != domain.ErrorStateOpen {
+		t.Fatalf("Expected error to stay open, got %v", te.State)
+	}
+
+	now = now.Add(10 * time.Minute)
+	s.ApplyMetrics(healthy(now))
+
+	te, _ := s.TrackedError("err-1")
+	if te.State != domain.ErrorStateResolved || !te.Resolved || te.ResolvedBy != AutoResolvedBy {
+		t.Fatalf("Expected error resolved automatically, got %+v", te)
+	}
+	if te.ResolvedAt == nil || !te.ResolvedAt.Equal(now) {
+		t.Errorf("Expected resolved at %v, got %v", now, te.ResolvedAt)
+	}
+	if te, _ := s.TrackedError("err-2"); te.State != domain.ErrorStateOpen {
+		t.Errorf("Expected error of another KKT to stay open, got %v", te.State)
+	}
+	if errs := s.Errors(); !errs[0].Resolved || errs[1].Resolved {
+		t.Errorf("Expected only the recent error of kkt-001 resolved, got %+v", errs)
+	}
+	if got := testutil.CollectAndCount(s.timeToResolve); got != 1 {
+		t.Errorf("Expected 1 time-to-resolve series, got %d", got)
+	}
+
+	// A recurrence opens a new error
+	s.ApplyError(domain.KKTError{KKTID: "kkt-001", ErrorCode: "E-1", ErrorType: domain.ErrorTypeOFD, Timestamp: now})
+	if te, _ := s.TrackedError("err-3"); te.State != domain.ErrorStateOpen {
+		t.Errorf("Expected recurrence to open err-3, got %+v", te)
+	}
+}
+
+func TestStore_AutoResolveNeedsNewEvents(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	s := newLifecycleStore(&now)
+
+	report := func(collector string, ts, event time.Time) domain.Metrics {
+		return domain.Metrics{KKTID: "kkt-001", Collector: collector, Timestamp: ts, LastEventTime: event,
+			Status: domain.KKTStatusRunning, OFDSyncStatus: domain.OFDSyncStatusSynced}
+	}
+
+	// A warning does not stop the register
+	s.ApplyMetrics(report("store-1", now, now))
+	s.ApplyError(domain.KKTError{KKTID: "kkt-001", ErrorCode: "W-1", ErrorType: domain.ErrorTypeOFD,
+		Severity: domain.ErrorSeverityWarning, Timestamp: now.Add(time.Second)})
+
+	// The idle device is flushed again with its last known state
+	for i := 1; i <= 5; i++ {
+		s.ApplyMetrics(report("store-1", now.Add(time.Duration(i)*10*time.Second), now))
+	}
+	if te, _ := s.TrackedError("err-1"); te.State != domain.ErrorStateOpen {
+		t.Fatalf("Expected the warning to stay open without new events, got %v", te.State)
+	}
+
+	// Two collectors reporting the same cycle count once
+	s.ApplyMetrics(report("store-1", now.Add(time.Minute), now.Add(time.Minute)))
+	s.ApplyMetrics(report("ofd", now.Add(time.Minute+time.Second), now.Add(time.Minute+time.Second)))
+	if te, _ := s.TrackedError("err-1"); te.State != domain.ErrorStateOpen {
+		t.Fatalf("Expected the warning to stay open after one cycle, got %v", te.State)
+	}
+
+	s.ApplyMetrics(report("store-1", now.Add(2*time.Minute), now.Add(2*time.Minute)))
+	if te, _ := s.TrackedError("err-1"); te.State != domain.ErrorStateResolved {
+		t.Errorf("Expected the warning resolved after two cycles with new events, got %v", te.State)
+	}
+}
+
+func TestStore_AcknowledgeResolve(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	s := newLifecycleStore(&now)
+
+	s.ApplyError(domain.KKTError{KKTID: "kkt-001", ErrorCode: "E-1", ErrorType: domain.ErrorTypeFiscalDrive, Timestamp: now})
+	s.ApplyError(domain.KKTError{KKTID: "kkt-002", ErrorCode: "E-1", ErrorType: domain.ErrorTypeFiscalDrive, Timestamp: now})
+
+	now = now.Add(time.Hour)
+	te, err := s.Acknowledge("err-1", "ivanov", "FN replacement ordered")
+	if err != nil {
+		t.Fatalf("Failed to acknowledge: %v", err)
+	}
+	if te.State != domain.ErrorStateAcknowledged || te.AcknowledgedBy != "ivanov" || !te.AcknowledgedAt.Equal(now) {
+		t.Errorf("Expected acknowledged error, got %+v", te)
+	}
+
+	expected := `
+		# HELP kkt_open_errors Number of unresolved KKT errors by state (open or acknowledged)
+		# TYPE kkt_open_errors gauge
+		kkt_open_errors{error_type="fiscal_drive",kkt_id="kkt-001",state="acknowledged"} 1
+		kkt_open_errors{error_type="fiscal_drive",kkt_id="kkt-002",state="open"} 1
+	`
+	if err := testutil.CollectAndCompare(openErrorsCollector{store: s}, strings.NewReader(expected)); err != nil {
+		t.Errorf("Unexpected open errors metric: %v", err)
+	}
+
+	now = now.Add(time.Hour)
+	te, err = s.Resolve("err-1", "petrov", "")
+	if err != nil {
+		t.Fatalf("Failed to resolve: %v", err)
+	}
+	if te.State != domain.ErrorStateResolved || te.ResolvedBy != "petrov" || te.Note != "FN replacement ordered" {
+		t.Errorf("Expected resolved error keeping the note, got %+v", te)
+	}
+
+	if _, err := s.Resolve("err-1", "petrov", ""); !errors.Is(err, domain.ErrTrackedErrorResolved) {
+		t.Errorf("Expected ErrTrackedErrorResolved, got %v", err)
+	}
+	if _, err := s.Acknowledge("err-9", "petrov", ""); !errors.Is(err, domain.ErrTrackedErrorNotFound) {
+		t.Errorf("Expected ErrTrackedErrorNotFound, got %v", err)
+	}
+
+	if got := testutil.CollectAndCount(s.timeToResolve); got != 1 {
+		t.Errorf("Expected 1 time-to-resolve series, got %d", got)
+	}
+	if got := testutil.CollectAndCount(openErrorsCollector{store: s}); got != 1 {
+		t.Errorf("Expected 1 open errors series, got %d", got)
+	}
+}
+
+func TestStore_PruneResolved(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	s := newLifecycleStore(&now)
+	s.errorsLimit = 2
+
+	for i := 0; i < 4; i++ {
+		s.ApplyError(domain.KKTError{KKTID: "kkt-001", ErrorCode: "E-1", Timestamp: now})
+		now = now.Add(time.Minute)
+		if _, err := s.Resolve(s.TrackedErrors()[len(s.TrackedErrors())-1].ID, "ivanov", ""); err != nil {
+			t.Fatalf("Failed to resolve: %v", err)
+		}
+	}
+
+	tracked := s.TrackedErrors()
+	if len(tracked) != 2 || tracked[0].ID != "err-3" || tracked[1].ID != "err-4" {
+		t.Errorf("Expected the 2 latest resolved errors, got %+v", tracked)
+	}
+
+	// A restored store with a lower limit keeps the latest resolved errors
+	s.ApplyError(domain.KKTError{KKTID: "kkt-002", ErrorCode: "E-2", Timestamp: now})
+	restored := newLifecycleStore(&now)
+	restored.errorsLimit = 1
+	restored.restoreTracked(s.TrackedErrors(), 5)
+	tracked = restored.TrackedErrors()
+	if len(tracked) != 2 || tracked[0].ID != "err-4" || tracked[1].ID != "err-5" {
+		t.Errorf("Expected the latest resolved and the open error, got %+v", tracked)
+	}
+}
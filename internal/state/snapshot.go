@@ -0,0 +1,123 @@
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/domain"
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/pkg/logger"
+)
+
+// snapshot is the on-disk form of the store
+type snapshot struct {
+	SavedAt       time.Time             `json:"saved_at"`
+	Devices       []deviceSnapshot      `json:"devices"`
+	Errors        []domain.KKTError     `json:"errors"`
+	TrackedErrors []domain.TrackedError `json:"tracked_errors"`
+	NextErrorID   int                   `json:"next_error_id"`
+}
+
+// deviceSnapshot is the on-disk form of a device
+type deviceSnapshot struct {
+	Device  domain.KKTDevice `json:"device"`
+	Metrics []domain.Metrics `json:"metrics"`
+}
+
+// Save atomically writes a snapshot of the store to path
+func (s *Store) Save(path string) error {
+	s.mu.RLock()
+	snap := snapshot{
+		SavedAt: time.Now(),
+		Devices: make([]deviceSnapshot, 0, len(s.devices)),
+		Errors:  s.errors,
+
+		TrackedErrors: s.trackedErrors(),
+		NextErrorID:   s.nextID,
+	}
+	for _, d := range s.devices {
+		snap.Devices = append(snap.Devices, deviceSnapshot{Device: d.info, Metrics: d.sortedMetrics()})
+	}
+	data, err := json.Marshal(snap)
+	s.mu.RUnlock()
+	if err != nil {
+		return fmt.Errorf("failed to encode state snapshot: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write state snapshot: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to replace state snapshot: %w", err)
+	}
+
+	return nil
+}
+
+// Load restores the store from a snapshot at path, replacing its state,
+// and reports whether there was one. A missing snapshot leaves the store
+// empty.
+func (s *Store) Load(path string) (bool, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read state snapshot: %w", err)
+	}
+
+	var snap snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return false, fmt.Errorf("failed to parse state snapshot: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.devices = make(map[string]*device, len(snap.Devices))
+	for _, ds := range snap.Devices {
+		d := &device{info: ds.Device, metrics: make(map[string]domain.Metrics, len(ds.Metrics))}
+		for _, m := range ds.Metrics {
+			d.metrics[m.Collector] = m
+		}
+		s.devices[ds.Device.ID] = d
+	}
+	s.errors = snap.Errors
+	if len(s.errors) > s.errorsLimit {
+		s.errors = s.errors[len(s.errors)-s.errorsLimit:]
+	}
+	s.restoreTracked(snap.TrackedErrors, snap.NextErrorID)
+
+	return true, nil
+}
+
+// Run saves a snapshot to path every interval and once more when ctx is
+// canceled
+func (s *Store) Run(ctx context.Context, path string, interval time.Duration, log *logger.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if err := s.Save(path); err != nil {
+				log.Error("Failed to save state snapshot", "path", path, "error", err)
+			}
+			return
+		case <-ticker.C:
+			if err := s.Save(path); err != nil {
+				log.Error("Failed to save state snapshot", "path", path, "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,277 @@
+package state
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/config"
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/domain"
+)
+
+// Store holds the current state of the KKT fleet. It merges metrics,
+// device details, documents and errors from all collectors into one
+// domain.KKTDevice per KKT ID, keeps the most recent errors and tracks the
+// lifecycle of errors. It is safe for concurrent use.
+type Store struct {
+	mu          sync.RWMutex
+	devices     map[string]*device
+	errors      []domain.KKTError
+	errorsLimit int
+
+	// Error lifecycle, see lifecycle.go
+	tracked           map[string]*domain.TrackedError
+	unresolved        map[string]map[string]string // tracked error IDs by KKT ID and code
+	resolved          resolvedRing
+	nextID            int
+	autoResolveCycles int
+	timeToResolve     *prometheus.HistogramVec
+	now               func() time.Time
+}
+
+// device is the state of a device
+type device struct {
+	info    domain.KKTDevice
+	metrics map[string]domain.Metrics // latest metrics by collector
+	// healthyReports is the number of healthy collection cycles since the
+	// last error
+	healthyReports int
+	// lastEvent is the time of the latest device event counted for health,
+	// a healthy report or an error
+	lastEvent time.Time
+	// cycleCollectors are the collectors that reported in the current
+	// healthy collection cycle
+	cycleCollectors map[string]bool
+	// lastDocument is the time of the latest document applied
+	lastDocument time.Time
+}
+
+// New creates an empty store
+func New(cfg config.StateConfig) *Store {
+	return &Store{
+		devices:           make(map[string]*device),
+		errorsLimit:       cfg.ErrorsLimit,
+		tracked:           make(map[string]*domain.TrackedError),
+		unresolved:        make(map[string]map[string]string),
+		autoResolveCycles: cfg.AutoResolveCycles,
+		timeToResolve:     newTimeToResolve(),
+		now:               time.Now,
+	}
+}
+
+// device returns the state of a device, creating it when missing.
+// The caller must hold the write lock.
+func (s *Store) device(id string) *device {
+	d, ok := s.devices[id]
+	if !ok {
+		d = &device{
+			info:    domain.KKTDevice{ID: id},
+			metrics: make(map[string]domain.Metrics),
+		}
+		s.devices[id] = d
+	}
+	return d
+}
+
+// ApplyMetrics merges metrics of a device. The latest metrics of every
+// collector are kept; metrics older than the device's last report update
+// only those and the details of the installed fiscal drive. Healthy
+// reports count towards the automatic resolution of the device's errors.
+func (s *Store) ApplyMetrics(m domain.Metrics) {
+	if m.KKTID == "" {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d := s.device(m.KKTID)
+	d.metrics[m.Collector] = m
+	if m.Timestamp.Before(d.info.LastSeen) {
+		// Details of the installed fiscal drive may come from any collector
+		if m.FiscalDrive.Number == "" || m.FiscalDrive.Number == d.info.FiscalDriveNum {
+			d.mergeFiscalDrive(m.FiscalDrive)
+		}
+		return
+	}
+
+	d.info.LastSeen = m.Timestamp
+	d.info.Status = m.Status
+	d.info.ShiftStatus = m.ShiftStatus
+	d.info.OFDSyncStatus = m.OFDSyncStatus
+	d.mergeFiscalDrive(m.FiscalDrive)
+	s.countHealth(d, m)
+}
+
+// ApplyDevice merges device details reported by a collector, e.g. the
+// factory and registration numbers known to the OFD. Zero fields are
+// ignored.
+func (s *Store) ApplyDevice(info domain.KKTDevice) {
+	if info.ID == "" {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d := s.device(info.ID)
+	if info.FactoryNumber != "" {
+		d.info.FactoryNumber = info.FactoryNumber
+	}
+	if info.RegNumber != "" {
+		d.info.RegNumber = info.RegNumber
+	}
+	if info.FiscalDriveNum != "" && info.FiscalDriveInfo.Number == "" {
+		info.FiscalDriveInfo.Number = info.FiscalDriveNum
+	}
+	d.mergeFiscalDrive(info.FiscalDriveInfo)
+	if info.LastSeen.After(d.info.LastSeen) {
+		d.info.LastSeen = info.LastSeen
+	}
+}
+
+// ApplyDocument merges a fiscal document: the device was seen at the
+// document time, and shift documents open or close the shift unless a
+// later document was applied. Documents are compared with each other and
+// not with metrics, which are timestamped when collectors report them.
+func (s *Store) ApplyDocument(doc domain.FiscalDocument) {
+	if doc.KKTID == "" {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d := s.device(doc.KKTID)
+	if doc.DateTime.Before(d.lastDocument) {
+		return
+	}
+	d.lastDocument = doc.DateTime
+	if doc.DateTime.After(d.info.LastSeen) {
+		d.info.LastSeen = doc.DateTime
+	}
+	switch doc.Type {
+	case domain.DocumentTypeOpenShift:
+		d.info.ShiftStatus = domain.ShiftStatusOpen
+	case domain.DocumentTypeCloseShift:
+		d.info.ShiftStatus = domain.ShiftStatusClosed
+	}
+}
+
+// ApplyError records an error, evicting the oldest one when the store
+// holds errorsLimit errors, and tracks it
+func (s *Store) ApplyError(kktErr domain.KKTError) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if kktErr.KKTID != "" {
+		d := s.device(kktErr.KKTID)
+		d.resetHealth()
+		at := kktErr.Timestamp
+		if at.IsZero() {
+			at = s.now()
+		}
+		if at.After(d.lastEvent) {
+			d.lastEvent = at
+		}
+	}
+	s.track(kktErr)
+	s.errors = append(s.errors, kktErr)
+	if len(s.errors) > s.errorsLimit {
+		s.errors = s.errors[len(s.errors)-s.errorsLimit:]
+	}
+}
+
+// Devices returns all known devices ordered by ID
+func (s *Store) Devices() []domain.KKTDevice {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	devices := make([]domain.KKTDevice, 0, len(s.devices))
+	for _, d := range s.devices {
+		devices = append(devices, d.info)
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].ID < devices[j].ID })
+	return devices
+}
+
+// Device returns a device by ID
+func (s *Store) Device(id string) (domain.KKTDevice, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	d, ok := s.devices[id]
+	if !ok {
+		return domain.KKTDevice{}, false
+	}
+	return d.info, true
+}
+
+// DeviceMetrics returns the latest metrics of a device, one per collector
+// ordered by collector name
+func (s *Store) DeviceMetrics(id string) []domain.Metrics {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	d, ok := s.devices[id]
+	if !ok {
+		return nil
+	}
+	return d.sortedMetrics()
+}
+
+// Metrics returns the latest metrics of all devices
+func (s *Store) Metrics() []domain.Metrics {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var metrics []domain.Metrics
+	for _, d := range s.devices {
+		metrics = append(metrics, d.sortedMetrics()...)
+	}
+	return metrics
+}
+
+// Errors returns a copy of the recent errors, oldest first
+func (s *Store) Errors() []domain.KKTError {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	out := make([]domain.KKTError, len(s.errors))
+	copy(out, s.errors)
+	return out
+}
+
+// sortedMetrics returns the latest metrics ordered by collector name
+func (d *device) sortedMetrics() []domain.Metrics {
+	metrics := make([]domain.Metrics, 0, len(d.metrics))
+	for _, m := range d.metrics {
+		metrics = append(metrics, m)
+	}
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i].Collector < metrics[j].Collector })
+	return metrics
+}
+
+// mergeFiscalDrive merges the non-zero fields of a fiscal drive report.
+// A different drive number replaces the drive.
+func (d *device) mergeFiscalDrive(fd domain.FiscalDrive) {
+	current := &d.info.FiscalDriveInfo
+	if fd.Number != "" && fd.Number != current.Number {
+		*current = domain.FiscalDrive{Number: fd.Number}
+	}
+	if !fd.ExpiryDate.IsZero() {
+		current.ExpiryDate = fd.ExpiryDate
+	}
+	if fd.DocumentsMax != 0 {
+		current.DocumentsMax = fd.DocumentsMax
+	}
+	if fd.DocumentsUsed != 0 {
+		current.DocumentsUsed = fd.DocumentsUsed
+	}
+	if fd.MemoryUsage != 0 {
+		current.MemoryUsage = fd.MemoryUsage
+	}
+	d.info.FiscalDriveNum = current.Number
+}
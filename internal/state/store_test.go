@@ -0,0 +1,164 @@
+package state
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/config"
+	"github.com/ranas-mukminov/kkt-54fz-monitoring/internal/domain"
+)
+
+func TestStore_Merge(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	s := New(config.StateConfig{ErrorsLimit: 10})
+
+	s.ApplyMetrics(domain.Metrics{
+		KKTID:         "kkt-001",
+		Collector:     "store-1",
+		Timestamp:     now,
+		Status:        domain.KKTStatusRunning,
+		ShiftStatus:   domain.ShiftStatusOpen,
+		OFDSyncStatus: domain.OFDSyncStatusPending,
+		FiscalDrive:   domain.FiscalDrive{Number: "9960", DocumentsUsed: 100},
+	})
+	// Older metrics of another collector do not override the device
+	s.ApplyMetrics(domain.Metrics{
+		KKTID:         "kkt-001",
+		Collector:     "ofd",
+		Timestamp:     now.Add(-time.Minute),
+		Status:        domain.KKTStatusError,
+		OFDSyncStatus: domain.OFDSyncStatusSynced,
+		FiscalDrive:   domain.FiscalDrive{Number: "9960", ExpiryDate: now.AddDate(1, 0, 0)},
+	})
+	s.ApplyDevice(domain.KKTDevice{ID: "kkt-001", FactoryNumber: "00106", RegNumber: "0001"})
+
+	d, ok := s.Device("kkt-001")
+	if !ok {
+		t.Fatal("Expected device kkt-001")
+	}
+	if d.Status != domain.KKTStatusRunning || d.OFDSyncStatus != domain.OFDSyncStatusPending {
+		t.Errorf("Expected status of the latest metrics, got %v and %v", d.Status, d.OFDSyncStatus)
+	}
+	if !d.LastSeen.Equal(now) {
+		t.Errorf("Expected last seen %v, got %v", now, d.LastSeen)
+	}
+	if d.FactoryNumber != "00106" || d.RegNumber != "0001" {
+		t.Errorf("Expected device details, got %+v", d)
+	}
+	if d.FiscalDriveNum != "9960" || d.FiscalDriveInfo.DocumentsUsed != 100 || d.FiscalDriveInfo.ExpiryDate.IsZero() {
+		t.Errorf("Expected merged fiscal drive, got %+v", d.FiscalDriveInfo)
+	}
+	if m := s.DeviceMetrics("kkt-001"); len(m) != 2 || m[0].Collector != "ofd" {
+		t.Errorf("Expected metrics of 2 collectors ordered by name, got %+v", m)
+	}
+
+	// A new fiscal drive replaces the old one
+	s.ApplyMetrics(domain.Metrics{KKTID: "kkt-001", Collector: "store-1", Timestamp: now.Add(time.Minute),
+		FiscalDrive: domain.FiscalDrive{Number: "9961"}})
+	if d, _ := s.Device("kkt-001"); d.FiscalDriveInfo != (domain.FiscalDrive{Number: "9961"}) {
+		t.Errorf("Expected new fiscal drive, got %+v", d.FiscalDriveInfo)
+	}
+
+	s.ApplyDocument(domain.FiscalDocument{KKTID: "kkt-001", Type: domain.DocumentTypeCloseShift, DateTime: now.Add(2 * time.Minute)})
+	if d, _ := s.Device("kkt-001"); d.ShiftStatus != domain.ShiftStatusClosed {
+		t.Errorf("Expected shift closed, got %v", d.ShiftStatus)
+	}
+
+	// Metrics flushed after the document was written do not hide it
+	s.ApplyMetrics(domain.Metrics{KKTID: "kkt-001", Collector: "file_log", Timestamp: now.Add(5 * time.Minute),
+		ShiftStatus: domain.ShiftStatusClosed})
+	s.ApplyDocument(domain.FiscalDocument{KKTID: "kkt-001", Type: domain.DocumentTypeOpenShift, DateTime: now.Add(3 * time.Minute)})
+	if d, _ := s.Device("kkt-001"); d.ShiftStatus != domain.ShiftStatusOpen || !d.LastSeen.Equal(now.Add(5*time.Minute)) {
+		t.Errorf("Expected shift opened by the document, got %v last seen %v", d.ShiftStatus, d.LastSeen)
+	}
+	s.ApplyDocument(domain.FiscalDocument{KKTID: "kkt-001", Type: domain.DocumentTypeCloseShift, DateTime: now.Add(time.Minute)})
+	if d, _ := s.Device("kkt-001"); d.ShiftStatus != domain.ShiftStatusOpen {
+		t.Errorf("Expected an older document to be ignored, got %v", d.ShiftStatus)
+	}
+
+	for i := 0; i < 12; i++ {
+		s.ApplyError(domain.KKTError{KKTID: "kkt-002", ErrorCode: "E", Timestamp: now})
+	}
+	if errs := s.Errors(); len(errs) != 10 {
+		t.Errorf("Expected 10 errors, got %d", len(errs))
+	}
+	if devices := s.Devices(); len(devices) != 2 || devices[1].ID != "kkt-002" {
+		t.Errorf("Expected 2 devices ordered by ID, got %+v", devices)
+	}
+}
+
+func TestStore_Concurrent(t *testing.T) {
+	s := New(config.StateConfig{ErrorsLimit: 100})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				s.ApplyMetrics(domain.Metrics{KKTID: "kkt-001", Collector: "store-1", Timestamp: time.Now()})
+				s.ApplyError(domain.KKTError{KKTID: "kkt-001"})
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				s.Devices()
+				s.Metrics()
+				s.Errors()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if errs := s.Errors(); len(errs) != 100 {
+		t.Errorf("Expected 100 errors, got %d", len(errs))
+	}
+}
+
+func TestStore_Snapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "state.json")
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	s := New(config.StateConfig{ErrorsLimit: 10})
+	if loaded, err := s.Load(path); err != nil || loaded {
+		t.Fatalf("Expected missing snapshot to be ignored, got %v, %v", loaded, err)
+	}
+	s.ApplyMetrics(domain.Metrics{
+		KKTID:        "kkt-001",
+		Collector:    "store-1",
+		Timestamp:    now,
+		Status:       domain.KKTStatusRunning,
+		ErrorsByType: map[domain.ErrorType]int64{domain.ErrorTypeOFD: 2},
+	})
+	s.ApplyError(domain.KKTError{KKTID: "kkt-001", ErrorType: domain.ErrorTypeOFD, Timestamp: now})
+	if err := s.Save(path); err != nil {
+		t.Fatalf("Failed to save snapshot: %v", err)
+	}
+
+	restored := New(config.StateConfig{ErrorsLimit: 10})
+	if loaded, err := restored.Load(path); err != nil || !loaded {
+		t.Fatalf("Failed to load snapshot: %v, %v", loaded, err)
+	}
+	d, ok := restored.Device("kkt-001")
+	if !ok || d.Status != domain.KKTStatusRunning || !d.LastSeen.Equal(now) {
+		t.Errorf("Expected restored device, got %+v", d)
+	}
+	m := restored.DeviceMetrics("kkt-001")
+	if len(m) != 1 || m[0].ErrorsByType[domain.ErrorTypeOFD] != 2 {
+		t.Errorf("Expected restored metrics, got %+v", m)
+	}
+	if errs := restored.Errors(); len(errs) != 1 || errs[0].ErrorType != domain.ErrorTypeOFD {
+		t.Errorf("Expected restored error, got %+v", errs)
+	}
+
+	// Recurrences correlate with the restored tracked error
+	restored.ApplyError(domain.KKTError{KKTID: "kkt-001", ErrorType: domain.ErrorTypeOFD, Timestamp: now.Add(time.Minute)})
+	restored.ApplyError(domain.KKTError{KKTID: "kkt-002", ErrorType: domain.ErrorTypeOFD, Timestamp: now.Add(time.Minute)})
+	tracked := restored.TrackedErrors()
+	if len(tracked) != 2 || tracked[0].ID != "err-1" || tracked[0].Occurrences != 2 || tracked[1].ID != "err-2" {
+		t.Errorf("Expected restored tracked errors, got %+v", tracked)
+	}
+}
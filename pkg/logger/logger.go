@@ -47,3 +47,10 @@ func New(level, format string) *Logger {
 		Logger: slog.New(handler),
 	}
 }
+
+// With returns a logger that includes the given attributes in each record
+func (l *Logger) With(args ...any) *Logger {
+	return &Logger{
+		Logger: l.Logger.With(args...),
+	}
+}